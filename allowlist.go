@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// allowedActionsEnv restricts the actions of this deployment. It is read directly from the environment,
+// not as an option, so event annotations cannot change it.
+const allowedActionsEnv = "SYSTEMD_HANDLER_ALLOWED_ACTIONS"
+
+// buildAllowedActions restricts the actions at build time:
+//
+//	go build -ldflags "-X main.buildAllowedActions=reload,restart"
+var buildAllowedActions string
+
+// deploymentAllowlist returns actions permitted by the build and the environment, nil when unrestricted.
+// When both are set, only actions allowed by both are permitted.
+func deploymentAllowlist() []string {
+	var allowed []string
+	for _, list := range []string{buildAllowedActions, os.Getenv(allowedActionsEnv)} {
+		if strings.TrimSpace(list) == "" {
+			continue
+		}
+
+		names := []string{}
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+
+		if allowed == nil {
+			allowed = names
+			continue
+		}
+
+		both := []string{}
+		for _, name := range names {
+			if stringsContains(allowed, name) {
+				both = append(both, name)
+			}
+		}
+		allowed = both
+	}
+
+	return allowed
+}
+
+// checkAllowlist refuses actions this deployment may never perform
+func checkAllowlist(actions ...string) error {
+	allowed := deploymentAllowlist()
+	if allowed == nil {
+		return nil
+	}
+
+	for _, action := range actions {
+		if action != "" && action != "none" && !stringsContains(allowed, action) {
+			return fmt.Errorf("action %s is not allowed by this deployment, allowed actions: %s", action, strings.Join(allowed, ", "))
+		}
+	}
+
+	return nil
+}
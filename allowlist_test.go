@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckAllowlist(t *testing.T) {
+	defer func(build string) { buildAllowedActions = build }(buildAllowedActions)
+
+	buildAllowedActions = ""
+	if err := checkAllowlist("stop"); err != nil {
+		t.Errorf("unrestricted: %v", err)
+	}
+
+	buildAllowedActions = "reload,restart"
+	if err := checkAllowlist("restart", "none"); err != nil {
+		t.Errorf("allowed: %v", err)
+	}
+	if err := checkAllowlist("stop"); err == nil {
+		t.Error("expected stop to be refused")
+	}
+
+	t.Setenv(allowedActionsEnv, "stop")
+	if err := checkAllowlist("restart"); err == nil {
+		t.Error("expected restart to be refused by the environment allowlist")
+	}
+	if err := checkAllowlist("stop"); err == nil {
+		t.Error("expected stop to be refused by the build allowlist")
+	}
+}
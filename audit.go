@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	corev2 "github.com/sensu/core/v2"
+	"go.uber.org/multierr"
+)
+
+// auditRecord describes a single mutating call made by the handler
+type auditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventID   string    `json:"event_id,omitempty"`
+	// CorrelationID ties the record to logs, reports and follow-up events of the same run
+	CorrelationID string  `json:"correlation_id,omitempty"`
+	Entity        string  `json:"entity,omitempty"`
+	Host          string  `json:"host"`
+	Unit          string  `json:"unit"`
+	Action        string  `json:"action"`
+	Mode          string  `json:"mode"`
+	Result        string  `json:"result"`
+	Duration      float64 `json:"duration_seconds"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// auditLogger appends audit records as JSON lines to a file and/or local syslog
+type auditLogger struct {
+	mu     sync.Mutex
+	file   *os.File
+	syslog *syslog.Writer
+}
+
+func newAuditLogger(path string, useSyslog bool) (*auditLogger, error) {
+	a := &auditLogger{}
+
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit file error: %w", err)
+		}
+		a.file = f
+	}
+
+	if useSyslog {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, plugin.Name)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("syslog error: %w", err)
+		}
+		a.syslog = w
+	}
+
+	return a, nil
+}
+
+// Record writes the record to all configured sinks
+func (a *auditLogger) Record(rec auditRecord) error {
+	if a == nil || (a.file == nil && a.syslog == nil) {
+		return nil
+	}
+
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		_, err2 := a.file.Write(append(buf, '\n'))
+		err = multierr.Append(err, err2)
+	}
+	if a.syslog != nil {
+		err = multierr.Append(err, a.syslog.Info(string(buf)))
+	}
+
+	return err
+}
+
+// Close closes all sinks
+func (a *auditLogger) Close() error {
+	var err error
+
+	if a.file != nil {
+		err = multierr.Append(err, a.file.Close())
+	}
+	if a.syslog != nil {
+		err = multierr.Append(err, a.syslog.Close())
+	}
+
+	return err
+}
+
+// eventID returns string representation of the event UUID
+func eventID(event *corev2.Event) string {
+	if event == nil || len(event.ID) == 0 {
+		return ""
+	}
+
+	id, err := uuid.FromBytes(event.ID)
+	if err != nil {
+		return ""
+	}
+
+	return id.String()
+}
+
+// newCorrelationID derives the run correlation ID from the event ID, or generates one
+func newCorrelationID(event *corev2.Event) string {
+	if id := eventID(event); id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// correlationID returns the correlation ID of the current run, the event ID until the run started
+func correlationID(event *corev2.Event) string {
+	if plugin.correlationID != "" {
+		return plugin.correlationID
+	}
+	return eventID(event)
+}
+
+// entityName returns event entity name or empty string
+func entityName(event *corev2.Event) string {
+	if event == nil || event.Entity == nil {
+		return ""
+	}
+
+	return event.Entity.Name
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestCorrelationID(t *testing.T) {
+	defer func(id string) { plugin.correlationID = id }(plugin.correlationID)
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	if id := newCorrelationID(event); id == "" {
+		t.Error("expected generated correlation ID for event without ID")
+	}
+
+	event.ID = []byte("0123456789abcdef")
+	plugin.correlationID = newCorrelationID(event)
+	if plugin.correlationID != eventID(event) {
+		t.Errorf("expected event ID, got %s", plugin.correlationID)
+	}
+
+	events := unitResultEvents(event, []unitResult{{Host: "web1", Unit: "nginx.service", Action: "restart", Result: "done"}}, nil)
+	if events[0].Check.Annotations[correlationAnnotation] != plugin.correlationID {
+		t.Errorf("follow-up event without correlation ID: %v", events[0].Check.Annotations)
+	}
+	if !strings.Contains(hookCommand("true", "web1", "restart", nil), "SENSU_SYSTEMD_CORRELATION_ID='"+plugin.correlationID+"'") {
+		t.Error("hook environment without correlation ID")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// blackoutWindow disables remediation for duration after each cron activation.
+// Format: "<cron spec>|<duration>[|<unit pattern>]", e.g. "0 2 * * SUN|2h|mysql*.service".
+type blackoutWindow struct {
+	spec        string
+	schedule    cron.Schedule
+	duration    time.Duration
+	unitPattern string
+}
+
+func parseBlackout(s string) (blackoutWindow, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return blackoutWindow{}, fmt.Errorf("blackout %q: expected <cron>|<duration>[|<unit pattern>]", s)
+	}
+
+	schedule, err := cron.ParseStandard(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return blackoutWindow{}, fmt.Errorf("blackout %q: cron parse error: %w", s, err)
+	}
+
+	duration, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || duration <= 0 {
+		return blackoutWindow{}, fmt.Errorf("blackout %q: bad duration: %s", s, parts[1])
+	}
+
+	w := blackoutWindow{
+		spec:     s,
+		schedule: schedule,
+		duration: duration,
+	}
+
+	if len(parts) == 3 {
+		w.unitPattern = strings.TrimSpace(parts[2])
+		if _, err := filepath.Match(w.unitPattern, ""); err != nil {
+			return blackoutWindow{}, fmt.Errorf("blackout %q: bad unit pattern: %w", s, err)
+		}
+	}
+
+	return w, nil
+}
+
+// Active reports whether now falls within the window
+func (w blackoutWindow) Active(now time.Time) bool {
+	return !w.schedule.Next(now.Add(-w.duration)).After(now)
+}
+
+// Covers reports whether the window applies to the unit
+func (w blackoutWindow) Covers(unit string) bool {
+	if w.unitPattern == "" {
+		return true
+	}
+
+	ok, _ := filepath.Match(w.unitPattern, unit)
+	return ok
+}
+
+// activeBlackout returns first active window covering the unit, empty unit looks for handler-wide windows
+func activeBlackout(windows []blackoutWindow, unit string, now time.Time) (blackoutWindow, bool) {
+	for _, w := range windows {
+		if unit == "" && w.unitPattern != "" {
+			continue
+		}
+		if w.Covers(unit) && w.Active(now) {
+			return w, true
+		}
+	}
+
+	return blackoutWindow{}, false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlackoutWindow(t *testing.T) {
+	w, err := parseBlackout("0 2 * * SUN|2h|mysql*.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sunday := time.Date(2024, 6, 2, 3, 0, 0, 0, time.Local)
+	if !w.Active(sunday) {
+		t.Errorf("expected window to be active at %s", sunday)
+	}
+	if w.Active(sunday.Add(2 * time.Hour)) {
+		t.Errorf("expected window to be inactive at %s", sunday.Add(2*time.Hour))
+	}
+	if !w.Covers("mysql.service") || w.Covers("nginx.service") {
+		t.Errorf("unexpected unit pattern coverage")
+	}
+
+	if _, err := parseBlackout("0 2 * * SUN"); err == nil {
+		t.Errorf("expected error for missing duration")
+	}
+}
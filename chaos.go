@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chaosEnv enables fault injection like the hidden --chaos flag. Neither is an option,
+// so event annotations cannot turn it on.
+const chaosEnv = "SYSTEMD_HANDLER_CHAOS"
+
+// errChaos marks injected faults
+var errChaos = errors.New("chaos")
+
+// chaosConfig holds fault injection probabilities, nil when disabled. For tests and staging only.
+type chaosConfig struct {
+	spec string
+
+	// Tunnel drops the tunnel: fails to open it or loses it during the unit action
+	Tunnel float64
+	// Delay holds the D-Bus reply of the unit action for up to DelayMax
+	Delay    float64
+	DelayMax time.Duration
+	// Job fails the job result without acting on the unit
+	Job float64
+}
+
+var chaos *chaosConfig
+
+// parseChaos parses comma separated kind=probability pairs, e.g. "tunnel=0.1,delay=0.3:5s,job=0.2"
+func parseChaos(spec string) (*chaosConfig, error) {
+	c := &chaosConfig{spec: spec, DelayMax: 5 * time.Second}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kind, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid chaos %q, expected kind=probability", item)
+		}
+
+		value, delay, hasDelay := strings.Cut(value, ":")
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid chaos %q, probability must be within [0, 1]", item)
+		}
+		if hasDelay && kind != "delay" {
+			return nil, fmt.Errorf("invalid chaos %q, only delay takes a duration", item)
+		}
+
+		switch kind {
+		case "tunnel":
+			c.Tunnel = p
+		case "job":
+			c.Job = p
+		case "delay":
+			c.Delay = p
+			if hasDelay {
+				c.DelayMax, err = time.ParseDuration(delay)
+				if err != nil || c.DelayMax <= 0 {
+					return nil, fmt.Errorf("invalid chaos %q, bad delay duration", item)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown chaos kind %q, expected tunnel, delay or job", kind)
+		}
+	}
+
+	return c, nil
+}
+
+// chaosFlag removes the hidden --chaos flag from os.Args and returns its value
+func chaosFlag() string {
+	var spec string
+
+	args := os.Args[:1]
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == "--chaos" && i+1 < len(os.Args):
+			i++
+			spec = os.Args[i]
+		case strings.HasPrefix(arg, "--chaos="):
+			spec = strings.TrimPrefix(arg, "--chaos=")
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+
+	return spec
+}
+
+// setupChaos enables fault injection from the flag or the environment
+func setupChaos() error {
+	spec := chaosFlag()
+	if spec == "" {
+		spec = os.Getenv(chaosEnv)
+	}
+	if spec == "" {
+		return nil
+	}
+
+	var err error
+	chaos, err = parseChaos(spec)
+	return err
+}
+
+func (c *chaosConfig) hit(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// tunnelError returns an injected tunnel failure, nil when disabled
+func (c *chaosConfig) tunnelError() error {
+	if c != nil && c.hit(c.Tunnel) {
+		return fmt.Errorf("%w: tunnel dropped", errChaos)
+	}
+	return nil
+}
+
+// wrapAction injects faults into the unit action, the real job is not started for injected failures
+func (c *chaosConfig) wrapAction(logger *slog.Logger, af actionFunc) actionFunc {
+	if c == nil {
+		return af
+	}
+
+	return func(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+		if err := c.tunnelError(); err != nil {
+			logger.Warn("Chaos: dropping tunnel", "unit", name)
+			return 0, err
+		}
+		if c.hit(c.Job) {
+			logger.Warn("Chaos: failing job", "unit", name)
+			go func() { ch <- "failed" }()
+			return 0, nil
+		}
+
+		id, err := af(ctx, name, mode, ch)
+		if err == nil && c.hit(c.Delay) {
+			delay := rand.N(c.DelayMax)
+			logger.Warn("Chaos: delaying D-Bus reply", "unit", name, "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+		}
+
+		return id, err
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	c, err := parseChaos("tunnel=0.1, delay=0.5:2s,job=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Tunnel != 0.1 || c.Delay != 0.5 || c.DelayMax != 2*time.Second || c.Job != 1 {
+		t.Errorf("unexpected chaos config: %+v", c)
+	}
+
+	for _, spec := range []string{"tunnel", "job=2", "flood=0.1", "job=0.1:1s", "delay=1:0s"} {
+		if _, err := parseChaos(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+
+	called := 0
+	af := func(_ context.Context, _ string, _ string, ch chan<- string) (int, error) {
+		called++
+		go func() { ch <- "done" }()
+		return 1, nil
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ch := make(chan string, 1)
+
+	// job failures do not touch the unit
+	_, err = (&chaosConfig{Job: 1}).wrapAction(logger, af)(context.Background(), "nginx.service", "replace", ch)
+	if err != nil || <-ch != "failed" || called != 0 {
+		t.Errorf("expected injected job failure, got %v, called %d", err, called)
+	}
+
+	_, err = (&chaosConfig{Tunnel: 1}).wrapAction(logger, af)(context.Background(), "nginx.service", "replace", ch)
+	if !errors.Is(err, errChaos) || called != 0 {
+		t.Errorf("expected injected tunnel drop, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = (&chaosConfig{Delay: 1, DelayMax: 20 * time.Millisecond}).wrapAction(logger, af)(context.Background(), "nginx.service", "replace", ch)
+	if err != nil || <-ch != "done" || called != 1 || time.Since(start) > time.Second {
+		t.Errorf("expected delayed real action, got %v, called %d", err, called)
+	}
+
+	var disabled *chaosConfig
+	if disabled.tunnelError() != nil {
+		t.Error("disabled chaos must not inject faults")
+	}
+
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = []string{"handler", "mux", "--chaos", "job=1", "-a", "restart", "--chaos=tunnel=1"}
+	if spec := chaosFlag(); spec != "tunnel=1" || strings.Join(os.Args, " ") != "handler mux -a restart" {
+		t.Errorf("unexpected flag extraction: %q, %v", spec, os.Args)
+	}
+}
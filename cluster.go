@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// membersAnnotation lists cluster member hosts on a cluster proxy entity
+const membersAnnotation = "systemd-handler/members"
+
+// clusterMembers returns member hosts from the check or entity annotation
+func clusterMembers(event *corev2.Event) []string {
+	if event == nil {
+		return nil
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+
+		value, ok := meta.Annotations[membersAnnotation]
+		if !ok {
+			continue
+		}
+
+		members := make([]string, 0)
+		for _, m := range strings.Split(value, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+
+		return members
+	}
+
+	return nil
+}
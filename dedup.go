@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+)
+
+// handledAt returns when the event was handled successfully within ttl, expired entries are dropped
+func handledAt(st *handlerState, id string, ttl time.Duration, now time.Time) (time.Time, bool) {
+	for k, at := range st.Handled {
+		if now.Sub(at) > ttl {
+			delete(st.Handled, k)
+		}
+	}
+
+	at, ok := st.Handled[id]
+	return at, ok
+}
+
+// markHandled records the event as handled, re-deliveries within --dedup-ttl are no-ops
+func markHandled(st *handlerState, id string, now time.Time) {
+	if st.Handled == nil {
+		st.Handled = make(map[string]time.Time)
+	}
+	st.Handled[id] = now
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandledEvents(t *testing.T) {
+	st := &handlerState{}
+	now := time.Now()
+
+	if _, ok := handledAt(st, "event1", time.Hour, now); ok {
+		t.Error("unknown event reported as handled")
+	}
+
+	markHandled(st, "event1", now.Add(-30*time.Minute))
+	markHandled(st, "event2", now.Add(-2*time.Hour))
+	if at, ok := handledAt(st, "event1", time.Hour, now); !ok || !at.Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("expected event1 handled, got %v %v", at, ok)
+	}
+	if _, ok := handledAt(st, "event2", time.Hour, now); ok || len(st.Handled) != 1 {
+		t.Errorf("expired event must be dropped: %v", st.Handled)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/templates"
+)
+
+// drainData is the drain template source: event fields plus the run details
+type drainData struct {
+	*corev2.Event
+
+	CorrelationID string
+
+	Host   string
+	Action string
+	Units  []string
+}
+
+// drainRequest is a templated HTTP call made around the actions
+type drainRequest struct {
+	name   string
+	method string
+	url    string
+	body   string
+}
+
+// callDrain renders the request templates and calls the endpoint, non-2xx status is an error
+func callDrain(ctx context.Context, logger *slog.Logger, r drainRequest, headers []string, data drainData) error {
+	url, err := templates.EvalTemplate(r.name+"-url", r.url, data)
+	if err != nil {
+		return &HookError{Host: data.Host, Hook: r.name, Err: err}
+	}
+
+	var body string
+	if r.body != "" {
+		body, err = templates.EvalTemplate(r.name+"-body", r.body, data)
+		if err != nil {
+			return &HookError{Host: data.Host, Hook: r.name, Err: err}
+		}
+	}
+
+	logger.Info("Calling drain endpoint", "hook", r.name, "method", r.method, "url", url)
+
+	err = doDrain(ctx, r.method, url, body, headers)
+	if err != nil {
+		return &HookError{Host: data.Host, Hook: r.name, Err: err}
+	}
+
+	return nil
+}
+
+func doDrain(ctx context.Context, method, url, body string, headers []string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q, expected Name: value", h)
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
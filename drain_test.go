@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestCallDrain(t *testing.T) {
+	var gotPath, gotBody, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		gotPath, gotBody, gotAuth = r.URL.Path, string(buf), r.Header.Get("Authorization")
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer srv.Close()
+
+	event := corev2.FixtureEvent("web01", "check-nginx")
+	data := drainData{Event: event, Host: "10.0.0.1", Action: "restart", Units: []string{"nginx.service"}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := drainRequest{"drain", http.MethodPost, srv.URL + "/pool/{{.Entity.Name}}", `{"host":"{{.Host}}","units":{{toJSON .Units}}}`}
+	err := callDrain(context.Background(), logger, req, []string{"Authorization: Bearer x"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/pool/web01" || gotBody != `{"host":"10.0.0.1","units":["nginx.service"]}` || gotAuth != "Bearer x" {
+		t.Fatalf("unexpected request: %s %s %s", gotPath, gotBody, gotAuth)
+	}
+
+	req.url = srv.URL + "/fail"
+	if err := callDrain(context.Background(), logger, req, nil, data); err == nil {
+		t.Fatal("expected error on 409")
+	}
+}
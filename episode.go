@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// episodeExpiry drops episodes not seen for that long from the state
+const episodeExpiry = 24 * time.Hour
+
+// episodeState tracks remediations within one failure episode of a check
+type episodeState struct {
+	Occurrences int64     `json:"occurrences"`
+	Watermark   int64     `json:"watermark"`
+	Actions     int       `json:"actions"`
+	Updated     time.Time `json:"updated"`
+}
+
+// reserveEpisode records a remediation for the failure episode of key unless limit is reached.
+// The episode continues while occurrences grow, or while the watermark is kept over a status change
+// within the same run of non-zero statuses. Returns whether to proceed and remediations already done.
+func reserveEpisode(st *handlerState, key string, occurrences, watermark int64, limit int, now time.Time) (bool, int) {
+	if st.Episodes == nil {
+		st.Episodes = make(map[string]episodeState)
+	}
+
+	for k, ep := range st.Episodes {
+		if now.Sub(ep.Updated) > episodeExpiry {
+			delete(st.Episodes, k)
+		}
+	}
+
+	ep, ok := st.Episodes[key]
+	same := ok && (occurrences > ep.Occurrences || (watermark > occurrences && watermark >= ep.Watermark))
+	if !same {
+		ep = episodeState{}
+	}
+
+	ep.Occurrences = occurrences
+	ep.Watermark = watermark
+	ep.Updated = now
+
+	allowed := ep.Actions < limit
+	if allowed {
+		ep.Actions++
+	}
+	st.Episodes[key] = ep
+
+	if allowed {
+		return true, ep.Actions - 1
+	}
+	return false, ep.Actions
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveEpisode(t *testing.T) {
+	st := &handlerState{}
+	now := time.Now()
+
+	if ok, _ := reserveEpisode(st, "default/entity1/check1", 1, 1, 1, now); !ok {
+		t.Error("first failure: expected remediation")
+	}
+	if ok, done := reserveEpisode(st, "default/entity1/check1", 2, 2, 1, now); ok || done != 1 {
+		t.Errorf("same episode: expected skip after 1 action, got %v, %d", ok, done)
+	}
+	// warning -> critical resets occurrences, but the watermark keeps the episode
+	if ok, _ := reserveEpisode(st, "default/entity1/check1", 1, 2, 1, now); ok {
+		t.Error("status change within episode: expected skip")
+	}
+	// the check passed in between
+	if ok, _ := reserveEpisode(st, "default/entity1/check1", 1, 1, 1, now); !ok {
+		t.Error("new episode: expected remediation")
+	}
+	if ok, _ := reserveEpisode(st, "default/entity1/check1", 5, 5, 1, now.Add(2*episodeExpiry)); !ok {
+		t.Error("expired episode: expected remediation")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// Exit codes of the handler run
+const (
+	exitOK             = 0
+	exitError          = 1
+	exitAllFailed      = 2
+	exitPartialFailure = 3
+	exitVerifyFailed   = 4
+)
+
+var outcomeNames = map[int]string{
+	exitOK:             "success",
+	exitError:          "error",
+	exitAllFailed:      "all actions failed",
+	exitPartialFailure: "partial failure",
+	exitVerifyFailed:   "verification failed",
+}
+
+// exitCodeError carries the exit code of a failed run
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("%s: %v", outcomeNames[e.code], e.err)
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// runExitCode classifies the run by unit results
+func runExitCode(results []unitResult, err error) int {
+	failed, unverified := 0, 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		} else if r.Verify != "" {
+			unverified++
+		}
+	}
+
+	switch {
+	case len(results) > 0 && failed == len(results):
+		return exitAllFailed
+	case failed > 0:
+		return exitPartialFailure
+	case err != nil:
+		return exitError
+	case unverified > 0:
+		return exitVerifyFailed
+	default:
+		return exitOK
+	}
+}
+
+// exitCode returns the exit code for the error returned by executeHandler
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var ee *exitCodeError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+
+	return exitError
+}
+
+// executeHandlerMode runs the handler and exits with differentiated code,
+// the SDK would otherwise exit with 1 on any error
+func executeHandlerMode(event *corev2.Event) error {
+	err := redactError(executeHandler(event))
+	if code := exitCode(err); code > exitError {
+		fmt.Fprintf(os.Stderr, "Error executing %s: error executing handler: %v\n", plugin.Name, err)
+		os.Exit(code)
+	}
+
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.uber.org/multierr"
+)
+
+func TestRunExitCode(t *testing.T) {
+	done := unitResult{Unit: "a.service", Result: "done"}
+	failed := unitResult{Unit: "b.service", Result: "failed"}
+	unverified := unitResult{Unit: "c.service", Result: "done", Verify: "unit is failed after restart"}
+	inProgress := unitResult{Unit: "d.service", Result: resultInProgress}
+	errRun := errors.New("tunnel error")
+
+	for _, tc := range []struct {
+		name    string
+		results []unitResult
+		err     error
+		code    int
+	}{
+		{"success", []unitResult{done, inProgress}, nil, exitOK},
+		{"nothing to do", nil, nil, exitOK},
+		{"all failed", []unitResult{failed, failed}, errRun, exitAllFailed},
+		{"some failed", []unitResult{done, failed, unverified}, nil, exitPartialFailure},
+		{"error without results", nil, errRun, exitError},
+		{"error beats unverified", []unitResult{unverified}, errRun, exitError},
+		{"unverified only", []unitResult{done, unverified}, nil, exitVerifyFailed},
+	} {
+		if code := runExitCode(tc.results, tc.err); code != tc.code {
+			t.Errorf("%s: expected %d (%s), got %d", tc.name, tc.code, outcomeNames[tc.code], code)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	wrapped := fmt.Errorf("line 3: %w", &exitCodeError{code: exitPartialFailure, err: errors.New("1 unit failed")})
+
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitError},
+		{&exitCodeError{code: exitAllFailed, err: errors.New("2 units failed")}, exitAllFailed},
+		{wrapped, exitPartialFailure},
+		{multierr.Append(errors.New("boom"), wrapped), exitPartialFailure},
+	} {
+		if code := exitCode(tc.err); code != tc.code {
+			t.Errorf("exitCode(%v): expected %d, got %d", tc.err, tc.code, code)
+		}
+	}
+
+	if msg := wrapped.Error(); msg != "line 3: partial failure: 1 unit failed" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
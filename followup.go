@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// remediationEvent makes follow-up event reporting the remediation results
+func remediationEvent(event *corev2.Event, results []unitResult, runErr error, handlers []string) *corev2.Event {
+	checkName := "systemd"
+	if event.Check != nil {
+		checkName = event.Check.Name
+	}
+
+	status := uint32(sensu.CheckStateOK)
+	var out strings.Builder
+	for _, r := range results {
+		if r.Failed() {
+			status = sensu.CheckStateCritical
+		}
+		fmt.Fprintf(&out, "%s: %s %s: %s", r.Host, r.Action, r.Unit, r.Result)
+		if r.Error != "" {
+			fmt.Fprintf(&out, " (%s)", r.Error)
+		}
+		out.WriteString("\n")
+	}
+	if runErr != nil {
+		status = sensu.CheckStateCritical
+		fmt.Fprintf(&out, "error: %v\n", runErr)
+	}
+
+	now := time.Now().Unix()
+	check := &corev2.Check{
+		ObjectMeta: corev2.ObjectMeta{
+			Name:        checkName + "-remediation",
+			Namespace:   event.Entity.Namespace,
+			Annotations: correlationAnnotations(event),
+		},
+		Status:          status,
+		Output:          out.String(),
+		Handlers:        handlers,
+		ProxyEntityName: event.Entity.Name,
+		Issued:          now,
+		Executed:        now,
+	}
+
+	return &corev2.Event{
+		ObjectMeta: corev2.ObjectMeta{Namespace: event.Entity.Namespace},
+		Timestamp:  now,
+		Entity:     event.Entity,
+		Check:      check,
+	}
+}
+
+// correlationAnnotation carries the correlation ID on follow-up events
+const correlationAnnotation = "systemd-handler/correlation-id"
+
+func correlationAnnotations(event *corev2.Event) map[string]string {
+	id := correlationID(event)
+	if id == "" {
+		return nil
+	}
+	return map[string]string{correlationAnnotation: id}
+}
+
+// invalidCheckNameChars are replaced in unit names to make check names
+var invalidCheckNameChars = regexp.MustCompile(`[^\w.\-]`)
+
+// unitResultEvents makes one proxy check result per acted unit, the proxy entity is the target host
+func unitResultEvents(event *corev2.Event, results []unitResult, handlers []string) []*corev2.Event {
+	namespace := "default"
+	if event.Entity != nil {
+		namespace = event.Entity.Namespace
+	}
+
+	now := time.Now().Unix()
+	events := make([]*corev2.Event, 0, len(results))
+	for _, r := range results {
+		status := uint32(sensu.CheckStateOK)
+		output := fmt.Sprintf("%s %s: %s", r.Action, r.Unit, r.Result)
+		switch {
+		case r.Failed():
+			status = sensu.CheckStateCritical
+			if r.Error != "" {
+				output += " (" + r.Error + ")"
+			}
+		case r.Verify != "":
+			status = sensu.CheckStateWarning
+			output += ", not verified: " + r.Verify
+		}
+
+		check := &corev2.Check{
+			ObjectMeta: corev2.ObjectMeta{
+				Name:        "systemd-remediation-" + invalidCheckNameChars.ReplaceAllString(r.Unit, "-"),
+				Namespace:   namespace,
+				Labels:      map[string]string{"systemd_unit": r.Unit, "systemd_action": r.Action},
+				Annotations: correlationAnnotations(event),
+			},
+			Status:          status,
+			Output:          output + "\n",
+			Handlers:        handlers,
+			ProxyEntityName: r.Host,
+			Issued:          now,
+			Executed:        now,
+		}
+
+		events = append(events, &corev2.Event{
+			ObjectMeta: corev2.ObjectMeta{Namespace: namespace},
+			Timestamp:  now,
+			Check:      check,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestUnitResultEvents(t *testing.T) {
+	event := corev2.FixtureEvent("web01", "check-nginx")
+	results := []unitResult{
+		{Host: "10.0.0.1", Unit: "getty@tty1.service", Action: "restart", Result: "done"},
+		{Host: "10.0.0.1", Unit: "nginx.service", Action: "restart", Result: "failed"},
+	}
+
+	events := unitResultEvents(event, results, nil)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if c := events[0].Check; c.Name != "systemd-remediation-getty-tty1.service" || c.ProxyEntityName != "10.0.0.1" || c.Status != 0 {
+		t.Errorf("unexpected check: %s %s %d", c.Name, c.ProxyEntityName, c.Status)
+	}
+	if c := events[1].Check; c.Status != 2 {
+		t.Errorf("expected critical for failed unit, got %d", c.Status)
+	}
+	if err := events[0].Check.Validate(); err != nil {
+		t.Errorf("invalid check: %v", err)
+	}
+}
+
+func TestReportEvent(t *testing.T) {
+	var gotPath, gotAuth string
+	var got corev2.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("event decode error: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	handlerConfig(t)
+	plugin.ReportEvent = "backend"
+	plugin.SensuAPIURL = srv.URL
+	plugin.SensuAPIKey = "secret"
+	plugin.ReportHandlers = []string{"slack"}
+
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed", "mysql.service": "failed"})
+	conn.JobResults["mysql.service"] = "failed"
+	event := corev2.FixtureEvent("node1", "check-nginx")
+	if _, _, err := runHandler(t, event, conn); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	if gotPath != "/api/core/v2/namespaces/default/events" || gotAuth != "Key secret" {
+		t.Errorf("unexpected request: %s %q", gotPath, gotAuth)
+	}
+	c := got.Check
+	if c == nil || c.Name != "check-nginx-remediation" || c.ProxyEntityName != "node1" || c.Status != 2 || !slices.Equal(c.Handlers, []string{"slack"}) {
+		t.Fatalf("unexpected follow-up check: %+v", c)
+	}
+	for _, line := range []string{"node1: restart nginx.service: done\n", "node1: restart mysql.service: failed\n"} {
+		if !strings.Contains(c.Output, line) {
+			t.Errorf("expected %q in output %q", line, c.Output)
+		}
+	}
+	if c.Annotations[correlationAnnotation] != correlationID(event) {
+		t.Errorf("expected the correlation ID annotation, got %v", c.Annotations)
+	}
+
+	ev := remediationEvent(event, []unitResult{{Host: "node1", Unit: "nginx.service", Action: "restart", Result: "done"}}, nil, nil)
+	if ev.Check.Status != 0 {
+		t.Errorf("expected OK follow-up for a successful run, got %d", ev.Check.Status)
+	}
+	if err := ev.Check.Validate(); err != nil {
+		t.Errorf("invalid check: %v", err)
+	}
+}
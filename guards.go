@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// allowDestructiveAnnotation must be set to true on the check or entity to permit destructive actions
+const allowDestructiveAnnotation = "systemd-handler/allow-destructive"
+
+var (
+	destructiveActions = []string{"stop", "preset", "revert"}
+	destructiveModes   = []string{"isolate", "flush"}
+
+	// manualStartActions and manualStopActions are refused by units with RefuseManualStart/RefuseManualStop, as systemd does
+	manualStartActions = []string{"start", "restart", "try-restart", "reload-or-restart", "reload-or-try-restart", "revert"}
+	manualStopActions  = []string{"stop", "restart", "try-restart", "reload-or-try-restart", "revert"}
+)
+
+// isDestructive reports whether action/mode combination may take services down
+func isDestructive(action, mode string) bool {
+	return stringsContains(destructiveActions, action) || stringsContains(destructiveModes, mode)
+}
+
+// checkDestructive refuses destructive action/mode combinations unless the check or entity opts in
+func checkDestructive(event *corev2.Event, actions, modes []string) error {
+	if annotationBool(event, allowDestructiveAnnotation) {
+		return nil
+	}
+
+	for _, action := range actions {
+		for _, mode := range modes {
+			if isDestructive(action, mode) {
+				return fmt.Errorf("refusing destructive %s action (mode: %s): set %q annotation to \"true\" on the check or entity to allow it",
+					action, mode, allowDestructiveAnnotation)
+			}
+		}
+	}
+
+	return nil
+}
+
+// manualRefusal returns the unit property refusing the action, or empty string
+func manualRefusal(action string, refuseStart, refuseStop bool) string {
+	switch {
+	case refuseStart && stringsContains(manualStartActions, action):
+		return "RefuseManualStart"
+	case refuseStop && stringsContains(manualStopActions, action):
+		return "RefuseManualStop"
+	default:
+		return ""
+	}
+}
+
+// annotationBool looks up boolean annotation on the check first, then on the entity
+func annotationBool(event *corev2.Event, key string) bool {
+	if event == nil {
+		return false
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+
+		if value, ok := meta.Annotations[key]; ok {
+			b, err := strconv.ParseBool(value)
+			return err == nil && b
+		}
+	}
+
+	return false
+}
+
+// labelValue looks up the label on the check first, then on the entity
+func labelValue(event *corev2.Event, key string) string {
+	if event == nil {
+		return ""
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+
+		if value, ok := meta.Labels[key]; ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// eventNamespace returns the namespace of the event entity, or of the check for entity-less events
+func eventNamespace(event *corev2.Event) string {
+	switch {
+	case event == nil:
+		return ""
+	case event.Entity != nil:
+		return event.Entity.Namespace
+	case event.Check != nil:
+		return event.Check.Namespace
+	default:
+		return ""
+	}
+}
+
+func checkMeta(event *corev2.Event) *corev2.ObjectMeta {
+	if event.Check == nil {
+		return nil
+	}
+	return &event.Check.ObjectMeta
+}
+
+func entityMeta(event *corev2.Event) *corev2.ObjectMeta {
+	if event.Entity == nil {
+		return nil
+	}
+	return &event.Entity.ObjectMeta
+}
+
+// eventSkipReason returns the kind and the reason why the handler must not act on the event, or empty strings
+func eventSkipReason(event *corev2.Event) (kind, reason string) {
+	if plugin.RequireSubscription != "" {
+		if event == nil || event.Entity == nil || !stringsContains(event.Entity.Subscriptions, plugin.RequireSubscription) {
+			return "subscription", "entity does not have required subscription: " + plugin.RequireSubscription
+		}
+	}
+
+	if len(plugin.Namespaces) > 0 {
+		if ns := eventNamespace(event); !stringsContains(plugin.Namespaces, ns) {
+			return "namespace", fmt.Sprintf("namespace %q is not in --namespace list", ns)
+		}
+	}
+
+	if len(plugin.EntityClasses) > 0 {
+		if event == nil || event.Entity == nil || !stringsContains(plugin.EntityClasses, event.Entity.EntityClass) {
+			return "entity_class", "entity class is not in --entity-class list"
+		}
+	}
+
+	if plugin.RequireLabel != "" {
+		key, value, _ := strings.Cut(plugin.RequireLabel, "=")
+		if labelValue(event, key) != value {
+			return "label", "event does not carry required label: " + plugin.RequireLabel
+		}
+	}
+
+	return "", ""
+}
+
+// skip records why the handler does not act on the event, the kind is used as the metric tag
+func skip(kind, reason string) {
+	plugin.skipKind = kind
+	plugin.skipReason = reason
+}
+
+// isKeepalive reports whether the event is a Sensu agent keepalive event
+func isKeepalive(event *corev2.Event) bool {
+	return event != nil && event.Check != nil && event.Check.Name == corev2.KeepaliveCheckName
+}
+
+// isResolve reports whether the event reports a passing check
+func isResolve(event *corev2.Event) bool {
+	return event != nil && event.Check != nil && event.Check.Status == 0
+}
+
+// eventAge returns time passed since the event timestamp
+func eventAge(event *corev2.Event, now time.Time) time.Duration {
+	if event == nil || event.Timestamp <= 0 {
+		return 0
+	}
+
+	return now.Sub(time.Unix(event.Timestamp, 0))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestRequireLabel(t *testing.T) {
+	defer func(label string) { plugin.RequireLabel = label }(plugin.RequireLabel)
+	plugin.RequireLabel = "auto_remediate=true"
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	if _, reason := eventSkipReason(event); reason == "" {
+		t.Error("expected skip without the label")
+	}
+
+	event.Entity.Labels = map[string]string{"auto_remediate": "true"}
+	if _, reason := eventSkipReason(event); reason != "" {
+		t.Errorf("entity label: unexpected skip: %s", reason)
+	}
+
+	event.Check.Labels = map[string]string{"auto_remediate": "false"}
+	if _, reason := eventSkipReason(event); reason == "" {
+		t.Error("check label must take precedence over the entity label")
+	}
+}
+
+func TestNamespaceAndClassRestrictions(t *testing.T) {
+	defer func(ns, classes []string) { plugin.Namespaces, plugin.EntityClasses = ns, classes }(plugin.Namespaces, plugin.EntityClasses)
+
+	event := corev2.FixtureEvent("entity1", "check1")
+
+	plugin.Namespaces = []string{"production"}
+	if _, reason := eventSkipReason(event); reason == "" {
+		t.Error("expected skip for event from default namespace")
+	}
+	event.Entity.Namespace = "production"
+	if _, reason := eventSkipReason(event); reason != "" {
+		t.Errorf("unexpected skip: %s", reason)
+	}
+
+	plugin.EntityClasses = []string{corev2.EntityProxyClass}
+	if _, reason := eventSkipReason(event); reason == "" {
+		t.Error("expected skip for agent entity")
+	}
+	event.Entity.EntityClass = corev2.EntityProxyClass
+	if _, reason := eventSkipReason(event); reason != "" {
+		t.Errorf("unexpected skip: %s", reason)
+	}
+}
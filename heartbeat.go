@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// skipMetrics notes a run which decided not to act, tagged with the skip kind
+func skipMetrics(kind string, now time.Time) *corev2.Metrics {
+	return &corev2.Metrics{Points: []*corev2.MetricPoint{{
+		Name:      metricPrefix + ".skipped",
+		Value:     1,
+		Timestamp: now.Unix(),
+		Tags:      []*corev2.MetricTag{{Name: "reason", Value: kind}},
+	}}}
+}
+
+// skipHeartbeat tells a suppressed remediation apart from a handler which never ran:
+// it writes the skip metric and posts the report event noting the reason, if reporting is enabled
+func skipHeartbeat(ctx context.Context, logger *slog.Logger, event *corev2.Event) {
+	metrics := skipMetrics(plugin.skipKind, time.Now())
+	out := service.NewRedactingWriter(os.Stdout)
+	writeMetrics(out, metrics)
+	if err := out.Flush(); err != nil {
+		logger.Error("Metrics output error", "error", err)
+	}
+
+	if plugin.ReportEvent == "none" || event == nil || event.Entity == nil {
+		return
+	}
+
+	api := newSensuAPI(plugin.SensuAPIURL, plugin.SensuAPIKey)
+	if plugin.ReportEvent == "agent" {
+		api = newSensuAPI(plugin.AgentAPIURL, "")
+	}
+
+	ev := remediationEvent(event, nil, nil, plugin.ReportHandlers)
+	ev.Check.Output = "skipped: " + plugin.skipReason + "\n"
+	ev.Metrics = metrics
+	err := api.PostEvent(context.WithoutCancel(ctx), ev, plugin.ReportEvent == "agent")
+	if err != nil {
+		logger.Error("Report event error", "error", err)
+	}
+}
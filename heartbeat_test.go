@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestSkipHeartbeat(t *testing.T) {
+	defer func(cfg Config) { plugin = cfg }(plugin)
+
+	posted := make(chan *corev2.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := &corev2.Event{}
+		if err := json.NewDecoder(r.Body).Decode(ev); err != nil {
+			t.Error(err)
+		}
+		posted <- ev
+	}))
+	defer srv.Close()
+
+	plugin.ReportEvent = "agent"
+	plugin.AgentAPIURL = srv.URL
+	skip("stale", "stale event: 1h0m0s old")
+
+	skipHeartbeat(context.Background(), slog.Default(), corev2.FixtureEvent("entity1", "check1"))
+
+	ev := <-posted
+	if ev.Check.Status != 0 || ev.Check.Output != "skipped: stale event: 1h0m0s old\n" {
+		t.Errorf("unexpected heartbeat event: status %d, output %q", ev.Check.Status, ev.Check.Output)
+	}
+	if ev.Metrics == nil || len(ev.Metrics.Points) != 1 || ev.Metrics.Points[0].Tags[0].Value != "stale" {
+		t.Errorf("unexpected heartbeat metrics: %v", ev.Metrics)
+	}
+
+	// the metrics go to the handler output like the run summary, secrets hidden
+	service.RegisterSecret("hb-s3cr3t-kind")
+	plugin.ReportEvent = "none"
+	skip("hb-s3cr3t-kind", "skipped")
+	out := captureStdout(t, func() { skipHeartbeat(context.Background(), slog.Default(), nil) })
+	if strings.Contains(string(out), "s3cr3t") || !strings.Contains(string(out), metricPrefix+".skipped") {
+		t.Errorf("unexpected heartbeat output: %s", out)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// hookEvent builds an event from the environment of a check hook, which has no event on stdin
+func hookEvent() *corev2.Event {
+	hostname, _ := os.Hostname()
+
+	entityName := os.Getenv("SENSU_ENTITY_NAME")
+	if entityName == "" {
+		entityName = hostname
+	}
+
+	namespace := os.Getenv("SENSU_NAMESPACE")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	status := uint32(sensu.CheckStateCritical)
+	if v, err := strconv.ParseUint(os.Getenv("SENSU_CHECK_STATUS"), 10, 32); err == nil {
+		status = uint32(v)
+	}
+
+	return syntheticEvent(namespace, entityName, hostname, os.Getenv("SENSU_CHECK_NAME"), status)
+}
+
+func checkHookArgs(_ *corev2.Event) (int, error) {
+	checkModeEvent = hookEvent()
+
+	err := checkArgs(checkModeEvent)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	return sensu.CheckStateOK, nil
+}
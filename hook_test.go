@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestCheckHookArgs(t *testing.T) {
+	handlerConfig(t)
+	t.Cleanup(func() { checkModeEvent = nil })
+	t.Setenv("SENSU_ENTITY_NAME", "node1")
+	t.Setenv("SENSU_NAMESPACE", "")
+	t.Setenv("SENSU_CHECK_NAME", "check-nginx")
+	t.Setenv("SENSU_CHECK_STATUS", "")
+
+	// hooks run on the failing entity, a missing status means the check failed
+	if code, err := checkHookArgs(nil); err != nil || code != sensu.CheckStateOK {
+		t.Fatalf("unexpected result %d: %v", code, err)
+	}
+	ev := checkModeEvent
+	if ev.Entity.Name != "node1" || ev.Namespace != "default" || ev.Check.Name != "check-nginx" || ev.Check.Status != 2 {
+		t.Errorf("unexpected hook event: %+v %+v", ev.Entity.ObjectMeta, ev.Check)
+	}
+	if err := ev.Validate(); err != nil {
+		t.Errorf("invalid hook event: %v", err)
+	}
+	if plugin.skipReason != "" {
+		t.Errorf("expected the failing check acted on, got skip %q", plugin.skipReason)
+	}
+
+	t.Setenv("SENSU_NAMESPACE", "prod")
+	t.Setenv("SENSU_CHECK_STATUS", "0")
+	if _, err := checkHookArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	if checkModeEvent.Namespace != "prod" || checkModeEvent.Check.Status != 0 || plugin.skipKind != "resolve" {
+		t.Errorf("expected a passing check in prod skipped as resolve, got %s %d %q", checkModeEvent.Namespace, checkModeEvent.Check.Status, plugin.skipKind)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// hookCommand wraps the hook so it gets the run details in the environment
+func hookCommand(command, host, action string, units []string) string {
+	env := []string{
+		"SENSU_SYSTEMD_HOST=" + service.ShellQuote(host),
+		"SENSU_SYSTEMD_ACTION=" + service.ShellQuote(action),
+		"SENSU_SYSTEMD_UNITS=" + service.ShellQuote(strings.Join(units, " ")),
+	}
+	if plugin.correlationID != "" {
+		env = append(env, "SENSU_SYSTEMD_CORRELATION_ID="+service.ShellQuote(plugin.correlationID))
+	}
+
+	return fmt.Sprintf("env %s sh -c %s", strings.Join(env, " "), service.ShellQuote(command))
+}
+
+// runHook executes the hook command on the remote host over the tunnel SSH connection
+func runHook(ctx context.Context, logger *slog.Logger, runner service.CommandRunner, name, command, host, action string, units []string) error {
+	logger.Info("Running hook", "hook", name, "command", command)
+
+	out, err := runner.RunCommand(ctx, hookCommand(command, host, action, units))
+	if s := strings.TrimSpace(string(out)); s != "" {
+		logger.Info("Hook output", "hook", name, "output", s)
+	}
+	if err != nil {
+		return &HookError{Host: host, Hook: name, Err: err}
+	}
+
+	return nil
+}
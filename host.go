@@ -0,0 +1,866 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// errHostSkipped ends the run of a host that should not be acted on, it is not a failure
+var errHostSkipped = errors.New("host skipped")
+
+// connectHost connects the run to its host, tests replace it to act without ssh
+var connectHost = (*hostRun).connect
+
+// hostRun is the state shared by the phases of the run on one host
+type hostRun struct {
+	event  *corev2.Event
+	host   string
+	runID  string
+	logger *slog.Logger
+	audit  *auditLogger
+	report *hostReport
+
+	stun *service.DBusTunnel
+	conn service.SystemdConnection
+	// runner runs the hooks on the host, it is the tunnel outside tests
+	runner service.CommandRunner
+
+	// drained is set once the drain endpoint accepted the host
+	drained bool
+
+	phaseStart time.Time
+}
+
+// runHost performs the action on the units of one host
+func runHost(ctx context.Context, event *corev2.Event, host string, audit *auditLogger) (report *hostReport, err error) {
+	h := &hostRun{
+		event:      event,
+		host:       host,
+		runID:      eventID(event),
+		logger:     eventLogger(event).With("host", host),
+		audit:      audit,
+		report:     &hostReport{Host: host},
+		phaseStart: time.Now(),
+	}
+	report = h.report
+
+	ctx, span := startSpan(ctx, "host", attribute.String("host", host))
+	defer func() { endSpan(span, err) }()
+
+	err = h.run(ctx)
+	if errors.Is(err, errHostSkipped) {
+		return report, nil
+	}
+
+	return report, err
+}
+
+// run goes through the phases, refused units do not stop the others and are returned with the run errors
+func (h *hostRun) run(ctx context.Context) error {
+	disconnect, err := connectHost(h, ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	h.describe()
+
+	if err := h.gateState(ctx); err != nil {
+		return err
+	}
+
+	units, err := h.listUnits(ctx)
+	if err != nil {
+		return err
+	}
+	h.report.Phases.List = phaseDuration(&h.phaseStart)
+	h.report.Units = units
+
+	unitActions, err := h.planActions(units)
+	if err != nil {
+		return err
+	}
+
+	pending, unmask, refused := h.checkUnits(ctx, units, unitActions)
+	pending, inProgress, err := h.filterPending(ctx, pending, unitActions)
+	if err != nil {
+		return err
+	}
+
+	if len(unmask) > 0 {
+		h.logger.Warn("Unmasking units (runtime)", "units", unmask)
+		if err := service.Unmask(ctx, h.conn, unmask); err != nil {
+			return fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	pending, err = h.rateLimit(pending)
+	if err != nil {
+		return err
+	}
+
+	actionFuncs, err := h.actionFuncs(ctx, pending, unitActions)
+	if err != nil {
+		return err
+	}
+
+	drain := drainData{Event: h.event, CorrelationID: plugin.correlationID, Host: h.host, Action: plugin.Action, Units: pending}
+	if err := h.prepare(ctx, pending, drain); err != nil {
+		if plugin.DrainURL != "" && !h.drained {
+			return multierr.Append(refused, err)
+		}
+
+		// the pre hook or daemon-reload failed, the host is returned into service all the same
+		return multierr.Combine(refused, err, h.finish(ctx, pending, drain))
+	}
+
+	results, err := h.runActions(ctx, pending, unitActions, actionFuncs)
+	err = h.verify(ctx, pending, results, drain, multierr.Append(refused, err))
+	h.report.Phases.Action = phaseDuration(&h.phaseStart)
+
+	err = multierr.Append(err, h.finish(ctx, pending, drain))
+
+	h.logger.Info("Phase timings", "tunnel", h.report.Phases.Tunnel, "dbus", h.report.Phases.DBus, "list", h.report.Phases.List, "action", h.report.Phases.Action)
+
+	h.collect(ctx, results)
+	h.report.Results = append(results, inProgress...)
+	return err
+}
+
+// connect opens the tunnel and the D-Bus connection to the host, the returned function closes both
+func (h *hostRun) connect(ctx context.Context) (func(), error) {
+	tunCfg := plugin.Tun
+	tunCfg.SSHHost = h.host
+
+	h.logger.Info("Connecting ssh tunnel", "port", tunCfg.SSHPort)
+	_, tunSpan := startSpan(ctx, "tunnel")
+	// tunnel outlives run cancellation to let in-flight jobs report, it is torn down on disconnect
+	stun, releaseTunnel, err := openTunnel(ctx, tunCfg)
+	endSpan(tunSpan, err)
+	h.report.Phases.Tunnel = phaseDuration(&h.phaseStart)
+	if err != nil {
+		return nil, fmt.Errorf("%s: SSH Tunnel error: %w", h.host, err)
+	}
+	h.report.Tunnel = "ssh"
+	if stun.Reused() {
+		h.report.Tunnel = "ssh-shared"
+		h.logger.Info("Reusing live ssh tunnel of another handler")
+	}
+	h.logger.Info("Tunnel ready", "tunnel", h.report.Tunnel, "duration", h.report.Phases.Tunnel.Round(time.Millisecond))
+
+	// pooled tunnel counters span several events, only the traffic of this run is reported
+	transportBase := stun.Stats()
+	closeTunnel := func() {
+		stats := stun.Stats().Sub(transportBase)
+		h.report.Transport = &stats
+		if plugin.TunnelStats {
+			h.logger.Info("Tunnel transport", "calls", stats.Calls, "rtt_avg", stats.AvgRoundTrip(), "rtt_max", stats.MaxRoundTrip,
+				"bytes_sent", stats.BytesSent, "bytes_received", stats.BytesReceived)
+		}
+		releaseTunnel()
+	}
+
+	rawConn, err := stun.New()
+	h.report.Phases.DBus = phaseDuration(&h.phaseStart)
+	if err != nil {
+		defer closeTunnel()
+		if err2 := stun.DiagnoseSystemd(ctx); err2 != nil {
+			if plugin.SkipNoSystemd && errors.Is(err2, service.ErrNoSystemd) {
+				h.logger.Warn("Skipped: target has no usable systemd", "error", err2)
+				return nil, errHostSkipped
+			}
+
+			return nil, fmt.Errorf("%s: %w", h.host, err2)
+		}
+
+		return nil, fmt.Errorf("%s: D-BUS error: %w", h.host, err)
+	}
+
+	if hostname, err := stun.Hostname(); err != nil {
+		h.logger.Debug("Remote hostname unavailable", "error", err)
+	} else {
+		h.report.Hostname = hostname
+	}
+
+	h.stun = stun
+	h.runner = stun
+	h.conn = service.WithCallTimeout(rawConn)
+	return func() {
+		if err := stun.CloseConn(rawConn); err != nil {
+			h.logger.Warn("D-Bus close error", "error", err)
+		}
+		closeTunnel()
+	}, nil
+}
+
+// describe reports the virtualization and the systemd version of the host, version gates report the error when they need it
+func (h *hostRun) describe() {
+	if virt, err := service.Virtualization(h.conn); err == nil && virt != "" {
+		h.logger.Info("Remote systemd runs virtualized", "virtualization", virt)
+		h.report.Virtualization = virt
+	}
+	if major, version, err := service.ManagerVersion(h.conn); err == nil {
+		h.report.systemdMajor, h.report.SystemdVersion = major, version
+		h.logger.Info("Remote systemd", "version", version)
+	}
+}
+
+// gateState applies --system-state-gate to the host
+func (h *hostRun) gateState(ctx context.Context) error {
+	if plugin.SystemStateGate == "off" {
+		return nil
+	}
+
+	state, err := gateSystemState(ctx, h.logger, h.conn)
+	h.report.SystemState = state
+	if errors.Is(err, errSystemBusy) && plugin.SystemStateGate == "skip" {
+		h.logger.Warn("Skipped: target is not running normally", "state", state)
+		return errHostSkipped
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.host, err)
+	}
+
+	return nil
+}
+
+// listUnits returns the units to act on: matched or canonical names, scoped by the target and unit file options
+func (h *hostRun) listUnits(ctx context.Context) ([]string, error) {
+	var unitNames []string
+	if plugin.MatchUnits {
+		var err error
+		unitNames, err = h.matchUnits(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		h.logger.Info("Use unit names as-is")
+		// listed units already have canonical names
+		unitNames = canonicalNames(ctx, h.logger, h.conn, slices.Clone(plugin.UnitPatterns))
+	}
+
+	return h.scopeUnits(ctx, unitNames)
+}
+
+// matchUnits lists the units matching the unit patterns with the configured or introspected list method
+func (h *hostRun) matchUnits(ctx context.Context) ([]string, error) {
+	h.logger.Info("Matching unit patterns...")
+
+	listMethod := plugin.ListMethod
+	h.report.ListSelection = "configured"
+	if listMethod == "auto" {
+		// NOTE(vermakov): use local systemd to introspect remote methods
+		introStart := time.Now()
+		_, introSpan := startSpan(ctx, "introspect")
+		var err error
+		listMethod, err = service.IntrospectListMethod(nil)
+		endSpan(introSpan, err)
+		if err != nil {
+			return nil, fmt.Errorf("could not introspect systemd dbus: %w", err)
+		}
+		h.report.ListSelection = "introspected"
+		h.report.IntrospectSeconds = time.Since(introStart).Seconds()
+	}
+	h.report.ListMethod = listMethod
+	h.report.ListCall = service.ListMethodCall(listMethod)
+	unitFetcher, err := service.UnitFetcherFor(listMethod)
+	if err != nil {
+		return nil, err
+	}
+	if len(plugin.UnitStates) > 0 && !service.RemoteStateFilter(listMethod) {
+		h.logger.Warn("Unit states are filtered locally, the full unit list is transferred", "method", listMethod)
+	}
+	h.logger.Debug("Listing units", "method", listMethod, "states", plugin.UnitStates)
+
+	listStart := time.Now()
+	listCtx, listSpan := startSpan(ctx, "match", attribute.String("list.call", h.report.ListCall))
+	unitStats, err := unitFetcher(listCtx, h.conn, plugin.UnitStates, plugin.UnitPatterns)
+	listSpan.SetAttributes(attribute.Int("units", len(unitStats)))
+	endSpan(listSpan, err)
+	h.report.ListCallSeconds = time.Since(listStart).Seconds()
+	if err != nil {
+		return nil, fmt.Errorf("%s: list units error: %w", h.host, err)
+	}
+	h.logger.Info("Units listed", "call", h.report.ListCall, "selection", h.report.ListSelection, "units", len(unitStats),
+		"duration", time.Since(listStart).Round(time.Millisecond))
+
+	unitNames := make([]string, 0, len(unitStats))
+	for _, unit := range unitStats {
+		unitNames = append(unitNames, unit.Name)
+	}
+
+	return unitNames, nil
+}
+
+// scopeUnits applies --of-target, --fragment-path and --expand-target to the units
+func (h *hostRun) scopeUnits(ctx context.Context, unitNames []string) ([]string, error) {
+	if plugin.OfTarget != "" {
+		members, err := service.ExpandTarget(ctx, h.conn, plugin.OfTarget)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h.host, err)
+		}
+
+		unitNames = slices.DeleteFunc(unitNames, func(unit string) bool {
+			return !slices.Contains(members, unit)
+		})
+		h.logger.Info("Scoped units to target", "target", plugin.OfTarget, "units", len(unitNames))
+	}
+
+	if len(plugin.FragmentPaths) > 0 {
+		var err error
+		unitNames, err = filterFragmentPaths(ctx, h.conn, unitNames, plugin.FragmentPaths)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h.host, err)
+		}
+		h.logger.Info("Scoped units to unit file directories", "paths", plugin.FragmentPaths, "units", len(unitNames))
+	}
+
+	if plugin.ExpandTarget {
+		var err error
+		unitNames, err = expandTargets(ctx, h.logger, h.conn, unitNames)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	return unitNames, nil
+}
+
+// planActions returns the action of every unit, with --two-phase it depends on the earlier runs
+func (h *hostRun) planActions(unitNames []string) (map[string]string, error) {
+	unitActions := make(map[string]string, len(unitNames))
+	for _, unitName := range unitNames {
+		unitActions[unitName] = plugin.Action
+	}
+
+	if plugin.TwoPhase {
+		err := updateState(plugin.StateFile, func(st *handlerState) error {
+			unitActions = phaseActions(st, h.host, unitNames, plugin.FirstAction, plugin.Action, plugin.escalationWindow, time.Now())
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("two-phase state error: %w", err)
+		}
+	}
+
+	return unitActions, nil
+}
+
+// checkUnits drops the units the guards skip and returns the masked units to unmask,
+// policy refusals and masked units are returned as the error, they do not stop the others
+func (h *hostRun) checkUnits(ctx context.Context, unitNames []string, unitActions map[string]string) (pending, unmask []string, err error) {
+	pending = make([]string, 0, len(unitNames))
+	for _, unitName := range unitNames {
+		action := unitActions[unitName]
+		if !h.admitted(ctx, unitName, action) {
+			continue
+		}
+		if err2 := plugin.policy.Allowed(h.event, unitName, action, modeFor(unitName)); err2 != nil {
+			h.logger.Warn("Refused", "unit", unitName, "error", err2)
+			err = multierr.Append(err, err2)
+			continue
+		}
+
+		if stringsContains(manualStartActions, action) {
+			masked, err2 := service.Masked(ctx, h.conn, unitName)
+			if err2 != nil {
+				h.logger.Warn("Mask check error", "unit", unitName, "error", err2)
+			} else if masked && plugin.UnmaskIfMasked {
+				unmask = append(unmask, unitName)
+			} else if masked {
+				h.logger.Error("Unit is masked", "unit", unitName, "action", action)
+				err = multierr.Append(err, &ActionError{Host: h.host, Unit: unitName, Action: action, Result: "masked", Err: errors.New("unit is masked")})
+				continue
+			}
+		}
+
+		pending = append(pending, unitName)
+	}
+
+	return pending, unmask, err
+}
+
+// admitted reports whether the unit passes the protection, blackout, manual action and uptime guards
+func (h *hostRun) admitted(ctx context.Context, unitName, action string) bool {
+	if plugin.protectedUnits != nil && plugin.protectedUnits.Match(unitName) {
+		h.logger.Info("Skipped: unit is protected", "unit", unitName)
+		return false
+	}
+	if w, ok := activeBlackout(plugin.blackouts, unitName, time.Now()); ok {
+		h.logger.Info("Skipped: blackout window", "unit", unitName, "blackout", w.spec)
+		return false
+	}
+	refuseStart, refuseStop, err := service.RefuseManual(ctx, h.conn, unitName)
+	if err != nil {
+		h.logger.Warn("Manual action check error", "unit", unitName, "error", err)
+	} else if prop := manualRefusal(action, refuseStart, refuseStop); prop != "" {
+		h.logger.Warn("Skipped: unit refuses manual start/stop", "unit", unitName, "action", action, "property", prop)
+		return false
+	}
+	if plugin.minUptime > 0 {
+		since, err := service.ActiveSince(ctx, h.conn, unitName)
+		if err != nil {
+			h.logger.Warn("Uptime check error", "unit", unitName, "error", err)
+		} else if up := time.Since(since); !since.IsZero() && up < plugin.minUptime {
+			h.logger.Info("Skipped: unit was started recently", "unit", unitName, "uptime", up.Round(time.Second), "min_uptime", plugin.minUptime)
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterPending drops the units done by an earlier attempt of the event and, per --pending-jobs,
+// the units with a queued job, those are returned as in-progress results
+func (h *hostRun) filterPending(ctx context.Context, pending []string, unitActions map[string]string) ([]string, []unitResult, error) {
+	if plugin.Resume && h.runID != "" && len(pending) > 0 {
+		var done []string
+		err := updateState(plugin.StateFile, func(st *handlerState) error {
+			done = completedUnits(st, h.runID, h.host, pending, time.Now())
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("resume state error: %w", err)
+		}
+
+		for _, unitName := range done {
+			h.logger.Info("Skipped: already done by an earlier attempt of the event", "unit", unitName)
+		}
+		pending = slices.DeleteFunc(pending, func(unit string) bool {
+			return slices.Contains(done, unit)
+		})
+	}
+
+	var inProgress []unitResult
+	if plugin.PendingJobs != "ignore" && len(pending) > 0 {
+		var err error
+		pending, inProgress, err = checkPendingJobs(ctx, h.logger, h.conn, h.host, pending, unitActions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	return pending, inProgress, nil
+}
+
+// rateLimit reserves the actions of the pending units against --max-actions-per-hour
+func (h *hostRun) rateLimit(pending []string) ([]string, error) {
+	if plugin.MaxActionsPerHour <= 0 {
+		return pending, nil
+	}
+
+	key := entityName(h.event)
+	if key == "" {
+		key = h.host
+	}
+
+	var limited []string
+	err := updateState(plugin.StateFile, func(st *handlerState) error {
+		pending, limited = reserveActions(st, key, pending, plugin.MaxActionsPerHour, time.Now())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rate limit state error: %w", err)
+	}
+
+	for _, unitName := range limited {
+		h.logger.Warn("RATE LIMITED: too many remediation actions within the last hour, skipping", "unit", unitName, "key", key, "limit", plugin.MaxActionsPerHour)
+	}
+
+	return pending, nil
+}
+
+// gateVersion checks the remote systemd is recent enough for the actions and modes
+func (h *hostRun) gateVersion(actions ...string) error {
+	if requiredVersion(actions...) == 0 {
+		return nil
+	}
+
+	if h.report.SystemdVersion == "" {
+		var err error
+		h.report.systemdMajor, h.report.SystemdVersion, err = service.ManagerVersion(h.conn)
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	return checkVersion(h.host, h.report.systemdMajor, h.report.SystemdVersion, actions...)
+}
+
+// actionFuncs gates the versions and modes of the pending units and resolves their action functions once,
+// two-phase may use different actions per unit
+func (h *hostRun) actionFuncs(ctx context.Context, pending []string, unitActions map[string]string) (map[string]actionFunc, error) {
+	if plugin.EnqueueMarked {
+		if err := h.gateVersion("enqueue-marked"); err != nil {
+			return nil, err
+		}
+	}
+
+	var isolated []string
+	for _, unitName := range pending {
+		mode := modeFor(unitName)
+		if err := h.gateVersion(mode); err != nil {
+			return nil, fmt.Errorf("--mode: %w", err)
+		}
+		if mode == "isolate" {
+			isolated = append(isolated, unitName)
+		}
+	}
+	if len(isolated) > 0 {
+		if err := checkIsolate(ctx, h.conn, isolated); err != nil {
+			return nil, fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	actionFuncs := make(map[string]actionFunc)
+	for _, unitName := range pending {
+		action := unitActions[unitName]
+		if _, ok := actionFuncs[action]; ok {
+			continue
+		}
+
+		if err := h.gateVersion(action); err != nil {
+			return nil, err
+		}
+
+		var af actionFunc
+		switch action {
+		case "cancel-jobs":
+			af = cancelJobsFunc(h.logger, h.conn, h.stun)
+		case "preset":
+			af = presetFunc(h.logger, h.conn, h.stun)
+		case "revert":
+			af = revertFunc(h.logger, h.conn, h.stun)
+		default:
+			var err error
+			af, err = getActionFunc(h.conn, action)
+			if err != nil {
+				return nil, err
+			}
+		}
+		actionFuncs[action] = chaos.wrapAction(h.logger, af)
+	}
+
+	return actionFuncs, nil
+}
+
+// prepare drains the host, runs the pre hook and reloads changed unit files before the actions
+func (h *hostRun) prepare(ctx context.Context, pending []string, drain drainData) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if plugin.DrainURL != "" {
+		err := callDrain(ctx, h.logger, drainRequest{"drain", plugin.DrainMethod, plugin.DrainURL, plugin.DrainBody}, plugin.DrainHeaders, drain)
+		if err != nil {
+			return err
+		}
+		h.drained = true
+	}
+
+	if plugin.PreHook != "" {
+		err := runHook(ctx, h.logger, h.runner, "pre", plugin.PreHook, h.host, plugin.Action, pending)
+		if err != nil {
+			return err
+		}
+	}
+
+	if plugin.DaemonReload {
+		err := daemonReloadIfNeeded(ctx, h.logger, h.conn, pending)
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.host, err)
+		}
+	}
+
+	return nil
+}
+
+// runActions performs the actions on up to --max-parallel units at once, failed units are returned as ActionErrors
+func (h *hostRun) runActions(ctx context.Context, pending []string, unitActions map[string]string, actionFuncs map[string]actionFunc) ([]unitResult, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(plugin.MaxParallel)
+
+	results := make([]unitResult, len(pending))
+	unitErrs := make([]error, len(pending))
+	progress := newActionProgress(len(pending))
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	if plugin.progressInterval > 0 && len(pending) > 1 {
+		go progress.report(progressCtx, h.logger, plugin.progressInterval)
+	}
+	for idx, unitName := range pending {
+		action := unitActions[unitName]
+		mode := modeFor(unitName)
+		af := actionFuncs[action]
+
+		h.logger.Info("Triggering action", "unit", unitName, "action", action, "mode", mode, "n", idx+1, "total", len(pending))
+		g.Go(func() error {
+			results[idx], unitErrs[idx] = h.runUnit(gctx, unitName, action, mode, af, progress)
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	stopProgress()
+	for idx, r := range results {
+		if r.Failed() {
+			err = multierr.Append(err, &ActionError{Host: r.Host, Unit: r.Unit, Action: r.Action, Result: r.Result, Err: unitErrs[idx]})
+		}
+	}
+
+	return results, err
+}
+
+// runUnit performs the action on one unit. Its failure is returned with the result, not to the group:
+// it must not cancel other units
+func (h *hostRun) runUnit(ctx context.Context, unitName, action, mode string, af actionFunc, progress *actionProgress) (res unitResult, unitErr error) {
+	ctx, span := startSpan(ctx, "action",
+		attribute.String("unit", unitName),
+		attribute.String("action", action),
+		attribute.String("mode", mode))
+	defer func() { endSpan(span, unitError(res)) }()
+
+	rec := auditRecord{
+		Timestamp:     time.Now(),
+		EventID:       h.runID,
+		CorrelationID: plugin.correlationID,
+		Entity:        entityName(h.event),
+		Host:          h.host,
+		Unit:          unitName,
+		Action:        action,
+		Mode:          mode,
+	}
+	var before, after service.UnitSnapshot
+	var finalState, verifyError string
+	var startLimitReset bool
+	var err error
+	if plugin.PropertyReport {
+		before, err = service.Snapshot(ctx, h.conn, unitName)
+		if err != nil {
+			h.logger.Warn("Property snapshot error", "unit", unitName, "error", err)
+		}
+	}
+	var dependents service.DependentStates
+	var affected []string
+	if plugin.PropagationReport {
+		dependents, err = service.SnapshotDependents(ctx, h.conn, unitName)
+		if err != nil {
+			h.logger.Warn("Dependents snapshot error", "unit", unitName, "error", err)
+		}
+	}
+
+	defer func() {
+		res = unitResult{
+			Host:            h.host,
+			Unit:            unitName,
+			Action:          action,
+			Mode:            mode,
+			Result:          rec.Result,
+			Duration:        time.Since(rec.Timestamp),
+			Error:           rec.Error,
+			State:           finalState,
+			Verify:          verifyError,
+			StartLimitReset: startLimitReset,
+			Affected:        affected,
+			Before:          before,
+			After:           after,
+		}
+		h.record(rec, res, progress)
+	}()
+
+	if err = ctx.Err(); err != nil {
+		rec.Result = "canceled"
+		rec.Error = err.Error()
+		return res, err
+	}
+
+	if stringsContains(activatingActions, action) && !plugin.KeepStartLimit {
+		startLimitReset = h.resetStartLimit(ctx, unitName)
+	}
+
+	if len(plugin.SetEnv) > 0 {
+		err = service.SetEnvironment(ctx, h.conn, unitName, plugin.SetEnv)
+		if err != nil {
+			h.logger.Error("Set environment error", "unit", unitName, "error", err)
+			rec.Result = "error"
+			rec.Error = err.Error()
+			return res, err
+		}
+		h.logger.Info("Environment set", "unit", unitName, "env", len(plugin.SetEnv))
+	}
+
+	resultCh := make(chan string, 1)
+	_, err = af(ctx, unitName, mode, resultCh)
+	if err != nil {
+		h.logger.Error("Action error", "unit", unitName, "action", action, "error", err)
+		rec.Result = "error"
+		rec.Error = err.Error()
+		return res, err
+	}
+
+	result, err := waitResult(ctx, resultCh)
+	rec.Result = result
+	if err != nil {
+		h.logger.Warn("Job did not report before shutdown", "unit", unitName, "action", action)
+		rec.Error = err.Error()
+		return res, err
+	}
+
+	finalState, err = service.UnitState(ctx, h.conn, unitName)
+	if err != nil {
+		h.logger.Warn("Unit state error", "unit", unitName, "error", err)
+	} else if stringsContains(activatingActions, action) && result == "done" && !strings.HasPrefix(finalState, "active ") {
+		verifyError = fmt.Sprintf("unit is %s after %s", finalState, action)
+		h.logger.Warn("Verification failed", "unit", unitName, "state", finalState)
+	}
+
+	if plugin.PropertyReport {
+		after, err = service.Snapshot(ctx, h.conn, unitName)
+		if err != nil {
+			h.logger.Warn("Property snapshot error", "unit", unitName, "error", err)
+		}
+	}
+
+	if len(dependents) > 0 {
+		afterDeps, err := service.SnapshotDependents(ctx, h.conn, unitName)
+		if err != nil {
+			h.logger.Warn("Dependents snapshot error", "unit", unitName, "error", err)
+		} else if affected = dependents.Changed(afterDeps); len(affected) > 0 {
+			h.logger.Info("Action propagated to bound units", "unit", unitName, "affected", affected)
+		}
+	}
+
+	h.logger.Info("Action result", "unit", unitName, "action", action, "result", result)
+	return res, nil
+}
+
+// resetStartLimit clears the start limit of the unit with reset-failed, it reports whether it did
+func (h *hostRun) resetStartLimit(ctx context.Context, unitName string) bool {
+	hit, err := service.StartLimitHit(ctx, h.conn, unitName)
+	if err != nil {
+		h.logger.Warn("Start limit check error", "unit", unitName, "error", err)
+		return false
+	}
+	if !hit {
+		return false
+	}
+
+	if err := h.conn.ResetFailedUnitContext(ctx, unitName); err != nil {
+		h.logger.Warn("Start limit reset error", "unit", unitName, "error", err)
+		return false
+	}
+
+	h.logger.Info("Start limit was hit, cleared with reset-failed", "unit", unitName)
+	return true
+}
+
+// record accounts the finished unit in the progress, the resume state and the audit log
+func (h *hostRun) record(rec auditRecord, res unitResult, progress *actionProgress) {
+	rec.Duration = res.Duration.Seconds()
+	progress.finish(res.Failed())
+	if plugin.Resume && h.runID != "" && !res.Failed() {
+		err := updateState(plugin.StateFile, func(st *handlerState) error {
+			markCompleted(st, h.runID, h.host, res.Unit, time.Now())
+			return nil
+		})
+		if err != nil {
+			h.logger.Warn("Resume state error", "unit", res.Unit, "error", err)
+		}
+	}
+	if err := h.audit.Record(rec); err != nil {
+		h.logger.Error("Audit log error", "unit", res.Unit, "error", err)
+	}
+}
+
+// verify confirms the recovery once the actions succeeded: marked jobs, the verify command and, in rolling mode,
+// the unit health. It returns err with the failures that count added
+func (h *hostRun) verify(ctx context.Context, pending []string, results []unitResult, drain drainData, err error) error {
+	if plugin.EnqueueMarked && err == nil {
+		err = flushMarked(ctx, h.logger, h.stun, h.conn, h.report)
+	}
+
+	if plugin.VerifyCheck && err == nil && len(pending) > 0 {
+		if err2 := verifyCheck(ctx, h.logger, h.stun, plugin.VerifyCommand); err2 != nil {
+			h.logger.Warn("Recovery not confirmed", "error", err2)
+			h.report.CheckVerifyError = err2.Error()
+			markUnverified(results, err2.Error())
+			if plugin.Rolling {
+				err = fmt.Errorf("%s: %w", h.host, err2)
+			}
+		}
+	}
+
+	if plugin.Rolling && err == nil && len(pending) > 0 {
+		err = waitHealthy(ctx, h.logger, h.conn, pending, drain)
+		if err != nil {
+			h.report.HealthError = err.Error()
+		}
+	}
+
+	return err
+}
+
+// finish runs the post hook and undrains the host. Both undo the preparation,
+// so they run even after shutdown was requested
+func (h *hostRun) finish(ctx context.Context, pending []string, drain drainData) (err error) {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if plugin.PostHook != "" {
+		hookCtx, cancel := cleanupContext(ctx)
+		err2 := runHook(hookCtx, h.logger, h.runner, "post", plugin.PostHook, h.host, plugin.Action, pending)
+		cancel()
+		if err2 != nil {
+			h.logger.Error("Post hook failed", "error", err2)
+			h.report.PostHookError = hookCause(err2)
+			err = multierr.Append(err, err2)
+		}
+	}
+
+	if plugin.UndrainURL != "" {
+		hookCtx, cancel := cleanupContext(ctx)
+		err2 := callDrain(hookCtx, h.logger, drainRequest{"undrain", plugin.DrainMethod, plugin.UndrainURL, plugin.UndrainBody}, plugin.DrainHeaders, drain)
+		cancel()
+		if err2 != nil {
+			h.logger.Error("Undrain failed", "error", err2)
+			h.report.UndrainError = hookCause(err2)
+			err = multierr.Append(err, err2)
+		}
+	}
+
+	return err
+}
+
+// collect adds the remote audit entry and the unit journals to the report
+func (h *hostRun) collect(ctx context.Context, results []unitResult) {
+	if plugin.RemoteAuditTag != "" {
+		auditCtx, cancel := cleanupContext(ctx)
+		err := remoteAudit(auditCtx, h.stun, h.event, results)
+		cancel()
+		if err != nil {
+			h.logger.Warn("Remote audit error", "error", err)
+		}
+	}
+
+	if plugin.JournalLines > 0 {
+		for idx := range results {
+			var err error
+			results[idx].Journal, err = remoteJournal(ctx, h.stun, results[idx].Unit, plugin.JournalLines)
+			if err != nil {
+				h.logger.Warn("Journal fetch error", "unit", results[idx].Unit, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"go.uber.org/multierr"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+// testHostRun returns a run of check-nginx on node1 served by conn
+func testHostRun(conn *servicetest.Conn) *hostRun {
+	return &hostRun{
+		event:  corev2.FixtureEvent("node1", "check-nginx"),
+		host:   "node1",
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		report: &hostReport{Host: "node1"},
+		conn:   conn,
+	}
+}
+
+func TestRunHostPrepareFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		drainStatus int
+		wantCalls   []string
+		wantHooks   int
+	}{
+		// the host was drained, it must be undrained and see its post hook
+		{"pre hook fails after drain", http.StatusOK, []string{"/drain", "/undrain"}, 2},
+		// nothing was drained, nothing to return into service
+		{"drain fails", http.StatusServiceUnavailable, []string{"/drain"}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlerConfig(t)
+
+			var mu sync.Mutex
+			var calls []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls = append(calls, r.URL.Path)
+				mu.Unlock()
+				if r.URL.Path == "/drain" {
+					w.WriteHeader(tc.drainStatus)
+				}
+			}))
+			defer srv.Close()
+
+			plugin.DrainURL = srv.URL + "/drain"
+			plugin.UndrainURL = srv.URL + "/undrain"
+			plugin.PreHook = "exit 3"
+			plugin.PostHook = "true"
+
+			conn := servicetest.NewConn(map[string]string{"nginx.service": "failed"})
+			runner := &shellRunner{}
+			savedConnect := connectHost
+			t.Cleanup(func() { connectHost = savedConnect })
+			connectHost = func(h *hostRun, _ context.Context) (func(), error) {
+				h.conn = conn
+				h.runner = runner
+				return func() {}, nil
+			}
+
+			_, err := runHost(context.Background(), corev2.FixtureEvent("node1", "check-nginx"), "node1", nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !slices.Equal(calls, tc.wantCalls) {
+				t.Errorf("drain calls %v, want %v", calls, tc.wantCalls)
+			}
+			if len(runner.commands) != tc.wantHooks {
+				t.Fatalf("hooks run %q, want %d", runner.commands, tc.wantHooks)
+			}
+			if tc.wantHooks > 0 {
+				if !strings.Contains(err.Error(), "pre") {
+					t.Errorf("error misses the pre hook failure: %v", err)
+				}
+				if !strings.Contains(runner.commands[1], "true") {
+					t.Errorf("post hook not run: %q", runner.commands)
+				}
+			}
+			if calls := conn.Calls(); slices.ContainsFunc(calls, func(c servicetest.Call) bool { return c.Unit == "nginx.service" }) {
+				t.Errorf("units acted on after a failed preparation: %+v", calls)
+			}
+		})
+	}
+}
+
+func TestDaemonReloadIfNeeded(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		daemonReload bool
+		needReload   bool
+		wantReload   bool
+	}{
+		// opt-in: a reload picks up every pending unit file edit on the host
+		{"off by default", false, true, false},
+		{"unit files unchanged", true, false, false},
+		{"unit file changed", true, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defaultConfig(t)
+			plugin.DaemonReload = tc.daemonReload
+
+			conn := servicetest.NewConn(map[string]string{"nginx.service": "active", "mysql.service": "active"})
+			conn.Properties["nginx.service"] = map[string]any{"NeedDaemonReload": tc.needReload}
+			h := testHostRun(conn)
+			if err := h.prepare(context.Background(), []string{"nginx.service", "mysql.service"}, drainData{}); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := conn.Calls()
+			if reloaded := len(calls) == 1 && calls[0].Method == "Reload"; reloaded != tc.wantReload || len(calls) > 1 {
+				t.Errorf("unexpected calls: %v", calls)
+			}
+		})
+	}
+}
+
+func TestShutdownMidJob(t *testing.T) {
+	defaultConfig(t)
+	plugin.MaxParallel = 1
+
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed", "mysql.service": "failed"})
+	h := testHostRun(conn)
+
+	// termination signal arrives while the first job runs, the job reports within the grace period
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var started []string
+	af := func(ctx context.Context, name, mode string, ch chan<- string) (int, error) {
+		started = append(started, name)
+		cancel()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			ch <- "done"
+		}()
+		return 1, nil
+	}
+
+	pending := []string{"nginx.service", "mysql.service"}
+	unitActions := map[string]string{"nginx.service": "restart", "mysql.service": "restart"}
+	results, err := h.runActions(ctx, pending, unitActions, map[string]actionFunc{"restart": af})
+
+	if !slices.Equal(started, []string{"nginx.service"}) {
+		t.Errorf("expected no unit started after shutdown, started %v", started)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	if r := results[0]; r.Result != "done" || r.Error != "" {
+		t.Errorf("expected the in-flight job reported done, got %+v", r)
+	}
+	if r := results[1]; r.Result != "canceled" || r.Error != context.Canceled.Error() {
+		t.Errorf("expected the queued unit canceled, got %+v", r)
+	}
+
+	var actionErr *ActionError
+	if !errors.As(err, &actionErr) || actionErr.Unit != "mysql.service" || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled unit error, got %v", err)
+	}
+	if errs := multierr.Errors(err); len(errs) != 1 {
+		t.Errorf("expected only the canceled unit to fail, got %v", errs)
+	}
+}
+
+func TestBoundedWorkers(t *testing.T) {
+	defaultConfig(t)
+	plugin.MaxParallel = 2
+
+	h := testHostRun(servicetest.NewConn(nil))
+
+	var mu sync.Mutex
+	var running, peak int
+	af := func(ctx context.Context, name, mode string, ch chan<- string) (int, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if name == "ceph-osd@2.service" {
+				ch <- "failed"
+				return
+			}
+			ch <- "done"
+		}()
+		return 1, nil
+	}
+
+	var pending []string
+	unitActions := make(map[string]string)
+	for i := 1; i <= 5; i++ {
+		unit := fmt.Sprintf("ceph-osd@%d.service", i)
+		pending = append(pending, unit)
+		unitActions[unit] = "restart"
+	}
+	results, err := h.runActions(context.Background(), pending, unitActions, map[string]actionFunc{"restart": af})
+
+	if peak != 2 {
+		t.Errorf("expected at most --max-parallel actions running, peak %d", peak)
+	}
+	// a failed unit does not cancel the rest of the group
+	var done []string
+	for _, r := range results {
+		if r.Result == "done" {
+			done = append(done, r.Unit)
+		}
+	}
+	if len(done) != 4 {
+		t.Errorf("expected other units done, got %+v", results)
+	}
+	var actionErr *ActionError
+	if !errors.As(err, &actionErr) || actionErr.Unit != "ceph-osd@2.service" || len(multierr.Errors(err)) != 1 {
+		t.Errorf("expected the failed unit error, got %v", err)
+	}
+}
+
+func TestActionError(t *testing.T) {
+	defaultConfig(t)
+
+	h := testHostRun(servicetest.NewConn(nil))
+
+	errNoUnit := errors.New("Unit php-fpm.service not found.")
+	af := func(ctx context.Context, name, mode string, ch chan<- string) (int, error) {
+		switch name {
+		case "php-fpm.service":
+			return 0, errNoUnit
+		case "mysql.service":
+			ch <- "failed"
+		default:
+			ch <- "done"
+		}
+		return 1, nil
+	}
+
+	pending := []string{"nginx.service", "mysql.service", "php-fpm.service"}
+	unitActions := map[string]string{"nginx.service": "restart", "mysql.service": "restart", "php-fpm.service": "start"}
+	_, err := h.runActions(context.Background(), pending, unitActions, map[string]actionFunc{"restart": af, "start": af})
+
+	errs := multierr.Errors(err)
+	if len(errs) != 2 {
+		t.Fatalf("expected an error per failed unit, got %v", errs)
+	}
+	var jobErr, callErr *ActionError
+	if !errors.As(errs[0], &jobErr) || !errors.As(errs[1], &callErr) {
+		t.Fatalf("expected ActionErrors, got %v", errs)
+	}
+	if jobErr.Host != "node1" || jobErr.Unit != "mysql.service" || jobErr.Result != "failed" || jobErr.Unwrap() != nil ||
+		jobErr.Error() != "node1: restart mysql.service: job result: failed" {
+		t.Errorf("unexpected job error: %+v", jobErr)
+	}
+	if callErr.Unit != "php-fpm.service" || callErr.Action != "start" || callErr.Result != "error" || !errors.Is(err, errNoUnit) ||
+		callErr.Error() != "node1: start php-fpm.service: Unit php-fpm.service not found." {
+		t.Errorf("unexpected call error: %+v", callErr)
+	}
+}
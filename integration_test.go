@@ -0,0 +1,166 @@
+//go:build integration
+
+// Integration tests run the handler against a systemd container over SSH.
+// They need docker and ssh client: go test -tags integration -run Integration ./...
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const integrationImage = "sensu-go-systemd-handler-it"
+
+type integrationTarget struct {
+	t         *testing.T
+	container string
+	port      string
+	key       string
+	binary    string
+	dir       string
+}
+
+func dockerCmd(t *testing.T, stdin []byte, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("docker", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func setupIntegration(t *testing.T) *integrationTarget {
+	t.Helper()
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+
+	dir := t.TempDir()
+	it := &integrationTarget{
+		t:      t,
+		key:    filepath.Join(dir, "id"),
+		binary: filepath.Join(dir, "handler"),
+		dir:    dir,
+	}
+
+	out, err := exec.Command("go", "build", "-o", it.binary, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("build error: %v: %s", err, out)
+	}
+
+	out, err = exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", it.key).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen error: %v: %s", err, out)
+	}
+	pub, err := os.ReadFile(it.key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dockerCmd(t, nil, "build", "-q", "-t", integrationImage, "testdata/integration")
+	it.container = dockerCmd(t, nil, "run", "-d", "--rm", "--privileged", "--cgroupns=host",
+		"--tmpfs", "/run", "--tmpfs", "/run/lock", "-v", "/sys/fs/cgroup:/sys/fs/cgroup:rw",
+		"-p", "127.0.0.1::22", integrationImage)
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", it.container).Run() //nolint:errcheck
+	})
+
+	it.exec(pub, "sh", "-c", "cat > /root/.ssh/authorized_keys && chmod 600 /root/.ssh/authorized_keys")
+	it.waitRunning()
+
+	hostPort := dockerCmd(t, nil, "port", it.container, "22/tcp")
+	it.port = hostPort[strings.LastIndex(hostPort, ":")+1:]
+
+	return it
+}
+
+func (it *integrationTarget) exec(stdin []byte, args ...string) string {
+	it.t.Helper()
+	return dockerCmd(it.t, stdin, append([]string{"exec", "-i", it.container}, args...)...)
+}
+
+func (it *integrationTarget) waitRunning() {
+	it.t.Helper()
+
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		out, _ := exec.Command("docker", "exec", it.container, "systemctl", "is-system-running").Output()
+		state := strings.TrimSpace(string(out))
+		if state == "running" || state == "degraded" {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+
+	it.t.Fatalf("container systemd did not start")
+}
+
+func (it *integrationTarget) unitProperty(unit, prop string) string {
+	it.t.Helper()
+	return it.exec(nil, "systemctl", "show", "--value", "-p", prop, unit)
+}
+
+// run executes the handler in run mode against the container
+func (it *integrationTarget) run(args ...string) (string, error) {
+	args = append([]string{"run",
+		"--ssh-host", "127.0.0.1",
+		"--ssh-port", it.port,
+		"--ssh-identity-file", it.key,
+		"--state-file", filepath.Join(it.dir, "state.json"),
+		"--list-method", "by-patterns",
+	}, args...)
+
+	out, err := exec.Command(it.binary, args...).CombinedOutput()
+	return string(out), err
+}
+
+func TestIntegration(t *testing.T) {
+	it := setupIntegration(t)
+
+	t.Run("start", func(t *testing.T) {
+		it.exec(nil, "systemctl", "stop", "sleeper.service")
+
+		out, err := it.run("-s", "sleeper.service", "-a", "start")
+		if err != nil {
+			t.Fatalf("handler error: %v: %s", err, out)
+		}
+
+		if state := it.unitProperty("sleeper.service", "ActiveState"); state != "active" {
+			t.Errorf("expected active, got %s", state)
+		}
+	})
+
+	t.Run("match and restart", func(t *testing.T) {
+		before := it.unitProperty("sleeper.service", "ExecMainPID")
+
+		out, err := it.run("-s", "sleep*", "--match", "-a", "restart")
+		if err != nil {
+			t.Fatalf("handler error: %v: %s", err, out)
+		}
+
+		after := it.unitProperty("sleeper.service", "ExecMainPID")
+		if after == before || after == "0" {
+			t.Errorf("expected new main PID, before %s, after %s", before, after)
+		}
+	})
+
+	t.Run("missing unit", func(t *testing.T) {
+		out, err := it.run("-s", "missing.service", "-a", "restart")
+		if err == nil {
+			t.Errorf("expected failure: %s", out)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// remoteJournal fetches last lines of the unit journal over the tunnel SSH connection
+func remoteJournal(ctx context.Context, runner service.CommandRunner, unit string, lines int) ([]string, error) {
+	cmd := fmt.Sprintf("journalctl --no-pager -o short-iso -n %d -u %s", lines, service.ShellQuote(unit))
+
+	out, err := runner.RunCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimRight(string(out), "\n"), "\n"), nil
+}
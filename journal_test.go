@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRemoteJournal(t *testing.T) {
+	dir := t.TempDir()
+	stub := "#!/bin/sh\necho \"$@\"\necho '2026-10-17T02:00:00+0000 node1 nginx[42]: started'\n"
+	if err := os.WriteFile(filepath.Join(dir, "journalctl"), []byte(stub), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	runner := &shellRunner{}
+	lines, err := remoteJournal(context.Background(), runner, "it's.service", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--no-pager -o short-iso -n 20 -u it's.service", "2026-10-17T02:00:00+0000 node1 nginx[42]: started"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("unexpected journal lines: %q", lines)
+	}
+	if len(runner.commands) != 1 || !strings.Contains(runner.commands[0], `'it'\''s.service'`) {
+		t.Errorf("expected the unit name quoted, got %q", runner.commands)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"gopkg.in/yaml.v3"
+)
+
+// structuredConfigPath is the keyspace annotation holding the structured config.
+// Not the keyspace itself: the SDK resolves options without a path, like secrets, from that annotation.
+const structuredConfigPath = "structured"
+
+// optionPath returns the annotation path of the option
+func optionPath(opt sensu.ConfigOption) string {
+	switch o := opt.(type) {
+	case *sensu.PluginConfigOption[string]:
+		return o.Path
+	case *sensu.PluginConfigOption[bool]:
+		return o.Path
+	case *sensu.PluginConfigOption[int]:
+		return o.Path
+	case *sensu.SlicePluginConfigOption[string]:
+		return o.Path
+	default:
+		return ""
+	}
+}
+
+// expandStructuredConfig turns the JSON/YAML object in the structured config annotation
+// into per-option annotations, so nested values like policy rules can be set in one annotation.
+// Per-option annotations of the same object take precedence.
+func expandStructuredConfig(event *corev2.Event) error {
+	if event == nil {
+		return nil
+	}
+
+	structured := path.Join(plugin.Keyspace, structuredConfigPath)
+	known := make(map[string]bool, len(options))
+	for _, opt := range options {
+		if p := optionPath(opt); p != "" {
+			known[p] = true
+		}
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+
+		// the SDK sets options without a path, like credentials and --ssh-run-as, from it
+		if _, ok := meta.Annotations[plugin.Keyspace]; ok {
+			return fmt.Errorf("%s annotation is not supported, use %s/<option> or %s", plugin.Keyspace, plugin.Keyspace, structured)
+		}
+
+		value, ok := meta.Annotations[structured]
+		if !ok {
+			continue
+		}
+
+		var cfg map[string]any
+		err := yaml.Unmarshal([]byte(value), &cfg)
+		if err != nil {
+			return fmt.Errorf("%s annotation parse error: %w", structured, err)
+		}
+
+		keys := make([]string, 0, len(cfg))
+		for key := range cfg {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !known[key] {
+				return fmt.Errorf("%s annotation: unknown option %q", structured, key)
+			}
+
+			annotation := path.Join(plugin.Keyspace, key)
+			if _, ok := meta.Annotations[annotation]; ok {
+				continue
+			}
+
+			s, err := annotationString(cfg[key])
+			if err != nil {
+				return fmt.Errorf("%s annotation: option %q: %w", structured, key, err)
+			}
+			meta.Annotations[annotation] = s
+		}
+	}
+
+	for _, opt := range options {
+		_, err := opt.SetAnnotationValue(plugin.Keyspace, event)
+		if err != nil {
+			return fmt.Errorf("annotation override error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// annotationString encodes the value as the SDK expects it in an annotation: scalars as-is, the rest as JSON
+func annotationString(v any) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case int, bool, float64:
+		return fmt.Sprint(value), nil
+	default:
+		buf, err := json.Marshal(value)
+		return string(buf), err
+	}
+}
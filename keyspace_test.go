@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestExpandStructuredConfig(t *testing.T) {
+	defer func(user string, units []string) { plugin.Tun.User, plugin.UnitPatterns = user, units }(plugin.Tun.User, plugin.UnitPatterns)
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Annotations = map[string]string{
+		plugin.Keyspace + "/structured": `{"ssh_user": "nested", "unit": ["nginx.service", "php-fpm.service"]}`,
+	}
+	event.Entity.Annotations = map[string]string{
+		plugin.Keyspace + "/structured": "ssh_user: entity",
+		plugin.Keyspace + "/ssh_user":   "flat",
+	}
+
+	if err := expandStructuredConfig(event); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Tun.User != "nested" {
+		t.Errorf("expected check keyspace value, got %s", plugin.Tun.User)
+	}
+	if !slices.Equal(plugin.UnitPatterns, []string{"nginx.service", "php-fpm.service"}) {
+		t.Errorf("nested list: got %v", plugin.UnitPatterns)
+	}
+	if event.Entity.Annotations[plugin.Keyspace+"/ssh_user"] != "flat" {
+		t.Error("flat annotation must take precedence over the keyspace object")
+	}
+
+	event.Check.Annotations = map[string]string{plugin.Keyspace + "/structured": `{"no_such_option": 1}`}
+	if err := expandStructuredConfig(event); err == nil {
+		t.Error("expected unknown option error")
+	}
+
+	event.Check.Annotations = map[string]string{plugin.Keyspace: "nobody"}
+	if err := expandStructuredConfig(event); err == nil {
+		t.Error("expected bare keyspace annotation to be refused")
+	}
+}
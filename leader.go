@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+const (
+	// roleAnnotation marks the entity host as the cluster leader, e.g. "primary"
+	roleAnnotation = "systemd-handler/role"
+	// leadersAnnotation lists leader hosts among cluster members
+	leadersAnnotation = "systemd-handler/leaders"
+)
+
+var (
+	leaderRoles    = []string{"leader", "primary", "master"}
+	leaderPolicies = []string{"last", "skip"}
+)
+
+// annotatedLeaders returns leader hosts declared by annotations of the check or entity
+func annotatedLeaders(event *corev2.Event, hosts []string) []string {
+	if event == nil {
+		return nil
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+
+		if value, ok := meta.Annotations[leadersAnnotation]; ok {
+			leaders := make([]string, 0)
+			for _, l := range strings.Split(value, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					leaders = append(leaders, l)
+				}
+			}
+			return leaders
+		}
+
+		// role describes the entity itself, so it only applies when it is the only target
+		if value, ok := meta.Annotations[roleAnnotation]; ok && len(hosts) == 1 {
+			if stringsContains(leaderRoles, strings.ToLower(strings.TrimSpace(value))) {
+				return hosts
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// isLeader runs --leader-command on the host, exit status 0 means the host is the leader
+func isLeader(ctx context.Context, host string) (bool, error) {
+	tunCfg := plugin.Tun
+	tunCfg.SSHHost = host
+
+	stun, releaseTunnel, err := openTunnel(ctx, tunCfg)
+	if err != nil {
+		return false, fmt.Errorf("%s: SSH Tunnel error: %w", host, err)
+	}
+	defer releaseTunnel()
+
+	_, err = stun.RunCommand(ctx, plugin.LeaderCommand)
+	// ssh itself fails with 255
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() != 255 {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("%s: leader command error: %w", host, err)
+	}
+
+	return true, nil
+}
+
+// splitLeaders separates cluster leaders from the other hosts.
+// With "last" policy leaders are returned to be acted on after the others, with "skip" they are dropped.
+func splitLeaders(ctx context.Context, event *corev2.Event, hosts []string) (followers, leaders []string, err error) {
+	if plugin.LeaderCommand == "" && !hasLeaderAnnotations(event) {
+		return hosts, nil, nil
+	}
+
+	annotated := annotatedLeaders(event, hosts)
+	logger := eventLogger(event)
+
+	for _, host := range hosts {
+		leader := stringsContains(annotated, host)
+		if !leader && plugin.LeaderCommand != "" {
+			leader, err = isLeader(ctx, host)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		switch {
+		case !leader:
+			followers = append(followers, host)
+		case plugin.LeaderPolicy == "skip":
+			logger.Warn("Skipped: host is the cluster leader", "host", host)
+		default:
+			leaders = append(leaders, host)
+		}
+	}
+
+	if len(followers) == 0 && len(leaders) == 0 {
+		skip("leader", "all targets are cluster leaders")
+	}
+
+	return followers, leaders, nil
+}
+
+func hasLeaderAnnotations(event *corev2.Event) bool {
+	if event == nil {
+		return false
+	}
+
+	for _, meta := range []*corev2.ObjectMeta{checkMeta(event), entityMeta(event)} {
+		if meta == nil {
+			continue
+		}
+		if _, ok := meta.Annotations[leadersAnnotation]; ok {
+			return true
+		}
+		if _, ok := meta.Annotations[roleAnnotation]; ok {
+			return true
+		}
+	}
+
+	return false
+}
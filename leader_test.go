@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestSplitLeaders(t *testing.T) {
+	event := corev2.FixtureEvent("galera", "check-galera")
+	event.Entity.Annotations = map[string]string{leadersAnnotation: "node2"}
+	hosts := []string{"node1", "node2", "node3"}
+
+	plugin.LeaderPolicy = "last"
+	followers, leaders, err := splitLeaders(context.Background(), event, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 2 || len(leaders) != 1 || leaders[0] != "node2" {
+		t.Fatalf("unexpected split: %v %v", followers, leaders)
+	}
+
+	plugin.LeaderPolicy = "skip"
+	followers, leaders, err = splitLeaders(context.Background(), event, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 2 || len(leaders) != 0 {
+		t.Fatalf("leader not skipped: %v %v", followers, leaders)
+	}
+
+	event.Entity.Annotations = map[string]string{roleAnnotation: "Primary"}
+	if got := annotatedLeaders(event, []string{"galera"}); len(got) != 1 {
+		t.Fatalf("role annotation ignored: %v", got)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// actionInfo describes a supported action for list-actions
+type actionInfo struct {
+	Name              string   `json:"name"`
+	Options           []string `json:"options"`
+	Destructive       bool     `json:"destructive"`
+	VerifiesActive    bool     `json:"verifies_active"`
+	MinSystemdVersion int      `json:"min_systemd_version,omitempty"`
+}
+
+// modeInfo describes a supported job mode for list-modes
+type modeInfo struct {
+	Name              string   `json:"name"`
+	Destructive       bool     `json:"destructive"`
+	Actions           []string `json:"actions,omitempty"`
+	MinSystemdVersion int      `json:"min_systemd_version,omitempty"`
+}
+
+// supportedActions enumerates actions from the validation tables, so additions are listed automatically
+func supportedActions() []actionInfo {
+	var out []actionInfo
+	seen := make(map[string]int)
+
+	add := func(option string, names []string) {
+		for _, name := range names {
+			if name == "none" {
+				continue
+			}
+
+			idx, ok := seen[name]
+			if !ok {
+				idx = len(out)
+				seen[name] = idx
+				out = append(out, actionInfo{
+					Name:              name,
+					Destructive:       stringsContains(destructiveActions, name),
+					VerifiesActive:    stringsContains(activatingActions, name),
+					MinSystemdVersion: actionMinVersion[name],
+				})
+			}
+			out[idx].Options = append(out[idx].Options, option)
+		}
+	}
+
+	add("action", allowedActions)
+	add("first-action", allowedFirstActions)
+	add("on-resolve", allowedResolveActions)
+
+	return out
+}
+
+func supportedModes() []modeInfo {
+	out := make([]modeInfo, 0, len(allowedModes))
+	for _, name := range allowedModes {
+		out = append(out, modeInfo{
+			Name:              name,
+			Destructive:       stringsContains(destructiveModes, name),
+			Actions:           modeActions[name],
+			MinSystemdVersion: actionMinVersion[name],
+		})
+	}
+
+	return out
+}
+
+func checkListArgs(_ *corev2.Event) (int, error) {
+	return sensu.CheckStateOK, nil
+}
+
+// executeListActions prints supported actions one per line, or with details as JSON
+func executeListActions(_ *corev2.Event) (int, error) {
+	actions := supportedActions()
+	if plugin.OutputFormat != "json" {
+		for _, a := range actions {
+			fmt.Println(a.Name)
+		}
+		return sensu.CheckStateOK, nil
+	}
+
+	return writeListing(actions)
+}
+
+// executeListModes prints supported job modes one per line, or with details as JSON
+func executeListModes(_ *corev2.Event) (int, error) {
+	modes := supportedModes()
+	if plugin.OutputFormat != "json" {
+		for _, m := range modes {
+			fmt.Println(m.Name)
+		}
+		return sensu.CheckStateOK, nil
+	}
+
+	return writeListing(modes)
+}
+
+func writeListing(v any) (int, error) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	return sensu.CheckStateOK, nil
+}
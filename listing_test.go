@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListings(t *testing.T) {
+	defaultConfig(t)
+
+	plugin.OutputFormat = "text"
+	out := captureStdout(t, func() { executeListActions(nil) })
+	names := strings.Fields(string(out))
+	if !slices.Equal(names[:len(allowedActions)], allowedActions) || !slices.Contains(names, "reset-failed") || slices.Contains(names, "none") {
+		t.Errorf("unexpected actions: %v", names)
+	}
+
+	plugin.OutputFormat = "json"
+	var actions []actionInfo
+	if err := json.Unmarshal(captureStdout(t, func() { executeListActions(nil) }), &actions); err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]actionInfo)
+	for _, a := range actions {
+		byName[a.Name] = a
+	}
+	if a := byName["reload"]; !slices.Equal(a.Options, []string{"action", "first-action", "on-resolve"}) || a.Destructive || a.VerifiesActive {
+		t.Errorf("unexpected reload: %+v", a)
+	}
+	if a := byName["reset-failed"]; !slices.Equal(a.Options, []string{"on-resolve"}) {
+		t.Errorf("unexpected reset-failed: %+v", a)
+	}
+	if !byName["stop"].Destructive || !byName["restart"].VerifiesActive || byName["mark-restart"].MinSystemdVersion != 248 {
+		t.Errorf("unexpected action details: %+v", actions)
+	}
+
+	var modes []modeInfo
+	if err := json.Unmarshal(captureStdout(t, func() { executeListModes(nil) }), &modes); err != nil {
+		t.Fatal(err)
+	}
+	if len(modes) != len(allowedModes) {
+		t.Fatalf("unexpected modes: %+v", modes)
+	}
+	for _, m := range modes {
+		switch m.Name {
+		case "isolate":
+			if !m.Destructive || !slices.Equal(m.Actions, []string{"start"}) {
+				t.Errorf("unexpected isolate: %+v", m)
+			}
+		case "triggering":
+			if m.Destructive || !slices.Equal(m.Actions, []string{"stop"}) || m.MinSystemdVersion != 250 {
+				t.Errorf("unexpected triggering: %+v", m)
+			}
+		case "replace":
+			if m.Destructive || len(m.Actions) != 0 || m.MinSystemdVersion != 0 {
+				t.Errorf("unexpected replace: %+v", m)
+			}
+		}
+	}
+}
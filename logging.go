@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"sync"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+var (
+	allowedLogLevels  = []string{"debug", "info", "warn", "error"}
+	allowedLogFormats = []string{"text", "json"}
+)
+
+var (
+	// openSyslog connects to the local syslog/journal, tests replace it
+	openSyslog = func() (*syslog.Writer, error) {
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, plugin.Name)
+	}
+
+	// logSyslog is shared by the loggers of all events, mux mode sets the logger up once per event
+	logSyslog *syslog.Writer
+)
+
+// setupLogger configures the default slog logger, std log output is routed to it too.
+// With useSyslog records are duplicated to the local syslog/journal under the plugin name.
+func setupLogger(level, format string, useSyslog bool) error {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("--log-level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("--log-format must be one of %v, but it is: %v", allowedLogFormats, format)
+	}
+
+	if useSyslog {
+		if logSyslog == nil {
+			w, err := openSyslog()
+			if err != nil {
+				return fmt.Errorf("syslog error: %w", err)
+			}
+			logSyslog = w
+		}
+
+		handler = teeHandler{handler, newSyslogHandler(logSyslog, opts)}
+	}
+
+	slog.SetDefault(slog.New(redactingHandler{handler}))
+	return nil
+}
+
+// redactingHandler hides secrets in messages and attribute values, logs end up in the Sensu event store
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, service.Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return redactingHandler{h.Handler.WithAttrs(out)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+// redactedError hides secrets in the error message, keeping the chain for errors.As
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return service.Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError wraps err, so it can be printed to the handler output
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, service.Redact(v.String()))
+
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, ga := range group {
+			attrs[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, attrs...)
+
+	case slog.KindAny:
+		s := fmt.Sprint(v.Any())
+		if r := service.Redact(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// teeHandler passes records to all handlers
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// syslogHandler sends each record as one syslog message with matching severity
+type syslogHandler struct {
+	slog.Handler
+	w   *syslog.Writer
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func newSyslogHandler(w *syslog.Writer, opts *slog.HandlerOptions) syslogHandler {
+	buf := &bytes.Buffer{}
+	sopts := *opts
+	sopts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		// syslog has its own timestamp and severity
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+
+	return syslogHandler{
+		Handler: slog.NewTextHandler(buf, &sopts),
+		w:       w,
+		mu:      &sync.Mutex{},
+		buf:     buf,
+	}
+}
+
+func (h syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	err := h.Handler.Handle(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	msg := string(bytes.TrimSpace(h.buf.Bytes()))
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+func (h syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.Handler = h.Handler.WithAttrs(attrs)
+	return h
+}
+
+func (h syslogHandler) WithGroup(name string) slog.Handler {
+	h.Handler = h.Handler.WithGroup(name)
+	return h
+}
+
+// eventLogger returns logger with event fields attached
+func eventLogger(event *corev2.Event) *slog.Logger {
+	logger := slog.Default()
+
+	if id := eventID(event); id != "" {
+		logger = logger.With("event_id", id)
+	}
+	if id := correlationID(event); id != "" {
+		logger = logger.With("correlation_id", id)
+	}
+	if name := entityName(event); name != "" {
+		logger = logger.With("entity", name)
+	}
+	if event != nil && event.Check != nil && event.Check.Name != "" {
+		logger = logger.With("check", event.Check.Name)
+	}
+
+	return logger
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestEventLogger(t *testing.T) {
+	saved := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	if err := setupLogger("verbose", "text", false); err == nil || !strings.HasPrefix(err.Error(), "--log-level") {
+		t.Errorf("expected unknown level rejected, got %v", err)
+	}
+	if err := setupLogger("info", "xml", false); err == nil || !strings.HasPrefix(err.Error(), "--log-format") {
+		t.Errorf("expected unknown format rejected, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	event := corev2.FixtureEvent("node1", "check-nginx")
+	logger := eventLogger(event)
+	logger.Info("Filtered by level")
+	logger.Warn("Unit is masked", "unit", "nginx.service")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected a single JSON record: %v\n%s", err, buf.String())
+	}
+	want := map[string]any{
+		"level": "WARN", "msg": "Unit is masked", "unit": "nginx.service",
+		"event_id": eventID(event), "correlation_id": correlationID(event), "entity": "node1", "check": "check-nginx",
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, rec[k])
+		}
+	}
+}
+
+func TestSyslogTee(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	w, err := syslog.Dial("unixgram", sock, syslog.LOG_INFO|syslog.LOG_DAEMON, "sensu-go-systemd-handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var stderr bytes.Buffer
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	logger := slog.New(teeHandler{slog.NewTextHandler(&stderr, opts), newSyslogHandler(w, opts)}).With("host", "node1")
+	logger.Debug("Filtered by level")
+	logger.Error("Action error", "unit", "nginx.service")
+
+	buf := make([]byte, 1024)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <daemon.err>, no timestamp and level of slog: syslog has its own
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<27>") || !strings.HasSuffix(strings.TrimSpace(msg), `sensu-go-systemd-handler[`+strconv.Itoa(os.Getpid())+`]: msg="Action error" host=node1 unit=nginx.service`) {
+		t.Errorf("unexpected syslog message: %q", msg)
+	}
+	if out := stderr.String(); strings.Contains(out, "Filtered") || !strings.Contains(out, `level=ERROR msg="Action error" host=node1 unit=nginx.service`) {
+		t.Errorf("unexpected stderr log: %s", out)
+	}
+}
+
+func TestSetupLoggerSyslogOnce(t *testing.T) {
+	saved, savedOpen, savedWriter := slog.Default(), openSyslog, logSyslog
+	t.Cleanup(func() { slog.SetDefault(saved); openSyslog, logSyslog = savedOpen, savedWriter })
+
+	sock := filepath.Join(t.TempDir(), "log")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// mux mode sets the logger up for every event, they all share one connection
+	opened := 0
+	logSyslog = nil
+	openSyslog = func() (*syslog.Writer, error) {
+		opened++
+		return syslog.Dial("unixgram", sock, syslog.LOG_INFO|syslog.LOG_DAEMON, "sensu-go-systemd-handler")
+	}
+	for range 3 {
+		if err := setupLogger("info", "text", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer logSyslog.Close()
+
+	if opened != 1 {
+		t.Errorf("syslog opened %d times", opened)
+	}
+}
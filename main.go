@@ -3,12 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/coreos/go-systemd/v22/dbus"
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/multierr"
 
 	"github.com/sardinasystems/sensu-go-systemd-handler/service"
@@ -17,16 +24,157 @@ import (
 // Config represents the handler plugin config.
 type Config struct {
 	sensu.PluginConfig
-	UnitPatterns []string
-	MatchUnits   bool
-	Action       string
-	Mode         string
-	Tun          service.DBusTunnelConfig
+	UnitPatterns        []string
+	MatchUnits          bool
+	UnitStates          []string
+	Action              string
+	Mode                string
+	Tun                 service.DBusTunnelConfig
+	AuditFile           string
+	AuditSyslog         bool
+	StateFile           string
+	EpisodeLimit        int
+	TwoPhase            bool
+	FirstAction         string
+	EscalationWindow    string
+	Jitter              string
+	PolicyFile          string
+	Policy              string
+	Blackouts           []string
+	MaxActionsPerHour   int
+	ProtectedUnits      []string
+	SensuAPIURL         string
+	SensuAPIKey         string
+	Annotate            string
+	ReportEvent         string
+	ReportHandlers      []string
+	AgentAPIURL         string
+	Metrics             bool
+	ProxyHostLabel      string
+	SSHHostAnnotation   string
+	SerialMembers       bool
+	RequireSubscription string
+	RequireLabel        string
+	VaultAddr           string
+	VaultToken          string
+	VaultRoleID         string
+	VaultSecretID       string
+	VaultSSHKey         string
+	VaultSSHIssue       string
+	Namespaces          []string
+	EntityClasses       []string
+	Keepalive           bool
+	KeepaliveUnit       string
+	OnResolve           string
+	MaxEventAge         string
+	OutputFormat        string
+	OTLPEndpoint        string
+	EventFile           string
+	LogLevel            string
+	LogFormat           string
+	LogSyslog           bool
+	JournalLines        int
+	SkipHeartbeat       bool
+	StatsdAddr          string
+	StatsdPrefix        string
+	RemoteAuditTag      string
+	PropertyReport      bool
+	TunnelStats         bool
+	PropagationReport   bool
+	ReportFile          string
+	ListMethod          string
+	MaxParallel         int
+	MaxTunnels          int
+	EscapeInstance      bool
+	MinSystemdVersion   int
+	SkipNoSystemd       bool
+	SystemStateGate     string
+	SystemStateWait     string
+	PendingJobs         string
+	PendingJobsWait     string
+	UnitModes           []string
+	ProgressInterval    string
+	Resume              bool
+	DedupTTL            string
+	PreHook             string
+	PostHook            string
+	DrainURL            string
+	DrainBody           string
+	UndrainURL          string
+	UndrainBody         string
+	DrainMethod         string
+	DrainHeaders        []string
+	Rolling             bool
+	MaxUnavailable      int
+	HealthURL           string
+	RollingTimeout      string
+	DBusCallTimeout     string
+	LeaderCommand       string
+	LeaderPolicy        string
+	EnqueueMarked       bool
+	SetEnv              []string
+	KeepStartLimit      bool
+	ExpandTarget        bool
+	DaemonReload        bool
+	MinUptime           string
+	UnmaskIfMasked      bool
+	OfTarget            string
+	FragmentPaths       []string
+	VerifyCheck         bool
+	VerifyCommand       string
+	VerifyTimeout       string
+	UnitResults         bool
+
+	escalationWindow time.Duration
+	jitter           time.Duration
+	maxEventAge      time.Duration
+	rollingTimeout   time.Duration
+	minUptime        time.Duration
+	verifyTimeout    time.Duration
+	systemStateWait  time.Duration
+	pendingJobsWait  time.Duration
+	progressInterval time.Duration
+	dedupTTL         time.Duration
+	policy           *policy
+	blackouts        []blackoutWindow
+	protectedUnits   *service.Matcher
+	unitModes        []unitMode
+	skipReason       string
+	skipKind         string
+	correlationID    string
+}
+
+// clone copies the config with its own slices. Annotation overrides decode into slice options in place
+// and checkArgs reuses the parsed slices, so a shallow copy would see the changes made for another event.
+func (c Config) clone() Config {
+	c.UnitPatterns = slices.Clone(c.UnitPatterns)
+	c.UnitStates = slices.Clone(c.UnitStates)
+	c.Blackouts = slices.Clone(c.Blackouts)
+	c.ProtectedUnits = slices.Clone(c.ProtectedUnits)
+	c.ReportHandlers = slices.Clone(c.ReportHandlers)
+	c.Namespaces = slices.Clone(c.Namespaces)
+	c.EntityClasses = slices.Clone(c.EntityClasses)
+	c.UnitModes = slices.Clone(c.UnitModes)
+	c.DrainHeaders = slices.Clone(c.DrainHeaders)
+	c.SetEnv = slices.Clone(c.SetEnv)
+	c.FragmentPaths = slices.Clone(c.FragmentPaths)
+	c.blackouts = slices.Clone(c.blackouts)
+	c.unitModes = slices.Clone(c.unitModes)
+
+	return c
 }
 
 var (
-	allowedActions = []string{"start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "reload-or-try-restart"}
-	allowedModes   = []string{"replace", "fail", "isolate", "ignore-dependencies", "ignore-requirements"}
+	allowedActions = []string{"start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "reload-or-try-restart", "mark-restart", "mark-reload", "cancel-jobs", "preset", "revert"}
+	allowedModes   = []string{"replace", "fail", "isolate", "ignore-dependencies", "ignore-requirements", "replace-irreversibly", "flush", "triggering", "restart-dependencies"}
+
+	// envAssignment is the KEY=VALUE form of Environment= entries
+	envAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+	// activatingActions must leave the unit active
+	activatingActions = []string{"start", "restart", "reload-or-restart", "revert"}
+
+	allowedResolveActions = append([]string{"none", "reset-failed"}, allowedActions...)
 
 	plugin = Config{
 		PluginConfig: sensu.PluginConfig{
@@ -53,6 +201,13 @@ var (
 			Usage:     "Match unit(s) patterns",
 			Value:     &plugin.MatchUnits,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "unit_state",
+			Env:      "SYSTEMD_UNIT_STATE",
+			Argument: "unit-state",
+			Usage:    "With --match, only act on units in the load, active or sub state(s), e.g. failed",
+			Value:    &plugin.UnitStates,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "action",
 			Env:       "SYSTEMD_ACTION",
@@ -68,11 +223,18 @@ var (
 			Env:       "SYSTEMD_MODE",
 			Argument:  "mode",
 			Shorthand: "M",
-			Usage:     "Action mode: replace, fail, isolate, ignore-dependencies, ignore-requirements",
+			Usage:     "Action mode: replace, fail, isolate, ignore-dependencies, ignore-requirements, replace-irreversibly, flush, triggering, restart-dependencies",
 			Value:     &plugin.Mode,
 			Default:   "replace",
 			Allow:     allowedModes,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "unit_mode",
+			Env:      "SYSTEMD_UNIT_MODE",
+			Argument: "unit-mode",
+			Usage:    "Job mode for matching units, <unit pattern>=<mode>, e.g. 'postgresql*=fail' (repeatable, first match wins over --mode)",
+			Value:    &plugin.UnitModes,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "ssh_host",
 			Argument:  "ssh-host",
@@ -103,6 +265,102 @@ var (
 			Usage:    "SSH Verbose mode (for debugging)",
 			Value:    &plugin.Tun.SSHVerbose,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ssh_identity_file",
+			Env:      "SSH_IDENTITY_FILE",
+			Argument: "ssh-identity-file",
+			Usage:    "SSH private key file",
+			Value:    &plugin.Tun.IdentityFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SSH_PRIVATE_KEY",
+			Argument: "ssh-private-key",
+			Usage:    "SSH private key PEM contents (use SSH_PRIVATE_KEY env with secrets providers)",
+			Value:    &plugin.Tun.PrivateKey,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SSH_PASSWORD",
+			Argument: "ssh-password",
+			Usage:    "SSH password (use SSH_PASSWORD env with secrets providers)",
+			Value:    &plugin.Tun.Password,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "VAULT_ADDR",
+			Argument: "vault-addr",
+			Usage:    "Vault server address to read SSH credentials from",
+			Value:    &plugin.VaultAddr,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "VAULT_TOKEN",
+			Argument: "vault-token",
+			Usage:    "Vault token (use VAULT_TOKEN env with secrets providers)",
+			Value:    &plugin.VaultToken,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "VAULT_ROLE_ID",
+			Argument: "vault-role-id",
+			Usage:    "Vault AppRole role ID, used when no token is set",
+			Value:    &plugin.VaultRoleID,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "VAULT_SECRET_ID",
+			Argument: "vault-secret-id",
+			Usage:    "Vault AppRole secret ID (use VAULT_SECRET_ID env with secrets providers)",
+			Value:    &plugin.VaultSecretID,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "vault_ssh_key",
+			Env:      "SYSTEMD_VAULT_SSH_KEY",
+			Argument: "vault-ssh-key",
+			Usage:    "Vault KV secret path with the SSH private key in private_key field (e.g. secret/data/sensu/ssh)",
+			Value:    &plugin.VaultSSHKey,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "vault_ssh_issue",
+			Env:      "SYSTEMD_VAULT_SSH_ISSUE",
+			Argument: "vault-ssh-issue",
+			Usage:    "Vault SSH secrets engine issue endpoint for a key and signed certificate (e.g. ssh/issue/sensu)",
+			Value:    &plugin.VaultSSHIssue,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SSH_RUN_AS",
+			Argument: "ssh-run-as",
+			Usage:    "Run ssh as this local low-privilege account (name or uid), the handler must run as root",
+			Value:    &plugin.Tun.RunAs,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "dbus_debug",
+			Argument: "dbus-debug",
+			Usage:    "Log D-Bus method calls, arguments and replies (for debugging)",
+			Value:    &plugin.Tun.DBusDebug,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "dbus_call_timeout",
+			Env:      "SYSTEMD_DBUS_CALL_TIMEOUT",
+			Argument: "dbus-call-timeout",
+			Usage:    "Deadline of every single D-Bus call (introspect, list, action, property read), 0 to disable",
+			Value:    &plugin.DBusCallTimeout,
+			Default:  "30s",
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "tunnel_reuse",
+			Env:      "SYSTEMD_TUNNEL_REUSE",
+			Argument: "tunnel-reuse",
+			Usage:    "Reuse a live ssh tunnel another handler opened to the same host and share ours; a killed owner leaves its ssh master to the stale tunnel cleanup",
+			Value:    &plugin.Tun.Reuse,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "tunnel_stats",
+			Env:      "SYSTEMD_TUNNEL_STATS",
+			Argument: "tunnel-stats",
+			Usage:    "Log D-Bus round trip latency and bytes transferred over the tunnel for each host",
+			Value:    &plugin.TunnelStats,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:     "dbus_socket",
 			Argument: "dbus-socket",
@@ -110,12 +368,681 @@ var (
 			Value:    &plugin.Tun.RemoteSocket,
 			Default:  "/var/run/systemd/private",
 		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_AUDIT_FILE",
+			Argument: "audit-file",
+			Usage:    "Append JSON lines audit records of every action to this file",
+			Value:    &plugin.AuditFile,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "audit_syslog",
+			Env:      "SYSTEMD_AUDIT_SYSLOG",
+			Argument: "audit-syslog",
+			Usage:    "Send audit records to the local syslog",
+			Value:    &plugin.AuditSyslog,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_STATE_FILE",
+			Argument: "state-file",
+			Usage:    "Path to the file keeping handler state between runs",
+			Value:    &plugin.StateFile,
+			Default:  "/var/cache/sensu/sensu-go-systemd-handler/state.json",
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "episode_limit",
+			Env:      "SYSTEMD_EPISODE_LIMIT",
+			Argument: "episode-limit",
+			Usage:    "Remediate at most this many times per check failure episode (0 - on every event)",
+			Value:    &plugin.EpisodeLimit,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "two_phase",
+			Env:      "SYSTEMD_TWO_PHASE",
+			Argument: "two-phase",
+			Usage:    "Perform --first-action on the first event and escalate to --action on recurrence",
+			Value:    &plugin.TwoPhase,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "first_action",
+			Env:      "SYSTEMD_FIRST_ACTION",
+			Argument: "first-action",
+			Usage:    "Two-phase first action: reload, try-restart, reload-or-try-restart",
+			Value:    &plugin.FirstAction,
+			Default:  "reload",
+			Allow:    allowedFirstActions,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "escalation_window",
+			Env:      "SYSTEMD_ESCALATION_WINDOW",
+			Argument: "escalation-window",
+			Usage:    "Two-phase window in which a recurring event escalates to --action",
+			Value:    &plugin.EscalationWindow,
+			Default:  "1h",
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "jitter",
+			Env:      "SYSTEMD_JITTER",
+			Argument: "jitter",
+			Usage:    "Maximum random delay before acting (e.g. 30s), 0 to disable",
+			Value:    &plugin.Jitter,
+			Default:  "0s",
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_POLICY_FILE",
+			Argument: "policy-file",
+			Usage:    "YAML policy file with rules of allowed actions per check/subscription/unit",
+			Value:    &plugin.PolicyFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_POLICY",
+			Argument: "policy",
+			Usage:    "Inline YAML/JSON policy, overrides --policy-file",
+			Value:    &plugin.Policy,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:                "blackout",
+			Env:                 "SYSTEMD_BLACKOUT",
+			Argument:            "blackout",
+			Usage:               "Blackout window(s) disabling remediation: <cron>|<duration>[|<unit pattern>]",
+			Value:               &plugin.Blackouts,
+			UseCobraStringArray: true,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max_actions_per_hour",
+			Env:      "SYSTEMD_MAX_ACTIONS_PER_HOUR",
+			Argument: "max-actions-per-hour",
+			Usage:    "Maximum remediation actions per entity per hour, 0 to disable",
+			Value:    &plugin.MaxActionsPerHour,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Env:      "SYSTEMD_PROTECTED_UNITS",
+			Argument: "protected-unit",
+			Usage:    "Unit name pattern(s) the handler must never act on",
+			Value:    &plugin.ProtectedUnits,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SENSU_API_URL",
+			Argument: "sensu-api-url",
+			Usage:    "Sensu backend API URL",
+			Value:    &plugin.SensuAPIURL,
+			Default:  "http://localhost:8080",
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SENSU_API_KEY",
+			Argument: "sensu-api-key",
+			Usage:    "Sensu backend API key",
+			Value:    &plugin.SensuAPIKey,
+			Secret:   true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "annotate",
+			Env:      "SYSTEMD_ANNOTATE",
+			Argument: "annotate",
+			Usage:    "Write remediation outcome annotations back via Sensu API: none, entity, event",
+			Value:    &plugin.Annotate,
+			Default:  "none",
+			Allow:    []string{"none", "entity", "event"},
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "report_event",
+			Env:      "SYSTEMD_REPORT_EVENT",
+			Argument: "report-event",
+			Usage:    "Send <check>-remediation event with the result: none, agent, backend",
+			Value:    &plugin.ReportEvent,
+			Default:  "none",
+			Allow:    []string{"none", "agent", "backend"},
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "report_event_handlers",
+			Env:      "SYSTEMD_REPORT_EVENT_HANDLERS",
+			Argument: "report-event-handler",
+			Usage:    "Handler(s) of the remediation result event",
+			Value:    &plugin.ReportHandlers,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SENSU_AGENT_API_URL",
+			Argument: "agent-api-url",
+			Usage:    "Sensu agent API URL",
+			Value:    &plugin.AgentAPIURL,
+			Default:  "http://127.0.0.1:3031",
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "metrics",
+			Env:      "SYSTEMD_METRICS",
+			Argument: "metrics",
+			Usage:    "Print remediation metrics after the text summary and attach them to the remediation event",
+			Value:    &plugin.Metrics,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "proxy_host_label",
+			Env:      "SYSTEMD_PROXY_HOST_LABEL",
+			Argument: "proxy-host-label",
+			Usage:    "Proxy entity label holding the SSH target address",
+			Value:    &plugin.ProxyHostLabel,
+			Default:  "ssh_host",
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ssh_host_annotation",
+			Env:      "SYSTEMD_SSH_HOST_ANNOTATION",
+			Argument: "ssh-host-annotation",
+			Usage:    "Entity annotation holding the SSH target when the entity has no hostname nor name",
+			Value:    &plugin.SSHHostAnnotation,
+			Default:  "systemd-handler/ssh-host",
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "serial_members",
+			Env:      "SYSTEMD_SERIAL_MEMBERS",
+			Argument: "serial-members",
+			Usage:    "Act on cluster members one at a time",
+			Value:    &plugin.SerialMembers,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "rolling",
+			Env:      "SYSTEMD_ROLLING",
+			Argument: "rolling",
+			Usage:    "Roll the action over cluster members, waiting for each host to become healthy and halting on failure",
+			Value:    &plugin.Rolling,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max_unavailable",
+			Env:      "SYSTEMD_MAX_UNAVAILABLE",
+			Argument: "max-unavailable",
+			Usage:    "Number of hosts acted on at once in rolling mode",
+			Value:    &plugin.MaxUnavailable,
+			Default:  1,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_HEALTH_URL",
+			Argument: "health-url",
+			Usage:    "URL template which must answer 2xx before the rollout proceeds to the next host",
+			Value:    &plugin.HealthURL,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "rolling_timeout",
+			Env:      "SYSTEMD_ROLLING_TIMEOUT",
+			Argument: "rolling-timeout",
+			Usage:    "Time a host has to become healthy in rolling mode",
+			Value:    &plugin.RollingTimeout,
+			Default:  "5m",
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_LEADER_COMMAND",
+			Argument: "leader-command",
+			Usage:    "Remote shell command telling the host is the cluster leader by exit status 0",
+			Value:    &plugin.LeaderCommand,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "leader_policy",
+			Env:      "SYSTEMD_LEADER_POLICY",
+			Argument: "leader-policy",
+			Usage:    "What to do with cluster leaders: last (act after other members succeeded), skip",
+			Value:    &plugin.LeaderPolicy,
+			Default:  "last",
+			Allow:    leaderPolicies,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "enqueue_marked",
+			Env:      "SYSTEMD_ENQUEUE_MARKED",
+			Argument: "enqueue-marked",
+			Usage:    "After the actions restart or reload all units marked needs-restart/needs-reload (systemd 248+)",
+			Value:    &plugin.EnqueueMarked,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "set_env",
+			Env:      "SYSTEMD_SET_ENV",
+			Argument: "set-env",
+			Usage:    "KEY=VALUE added to the unit Environment (runtime, until reboot) before the action",
+			Value:    &plugin.SetEnv,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "keep_start_limit",
+			Env:      "SYSTEMD_KEEP_START_LIMIT",
+			Argument: "keep-start-limit",
+			Usage:    "Do not reset-failed units down because of the start rate limit before activating them",
+			Value:    &plugin.KeepStartLimit,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "expand_target",
+			Env:      "SYSTEMD_EXPAND_TARGET",
+			Argument: "expand-target",
+			Usage:    "Act on Wants/Requires member units of .target units instead of the target itself",
+			Value:    &plugin.ExpandTarget,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "daemon_reload",
+			Env:      "SYSTEMD_DAEMON_RELOAD",
+			Argument: "daemon-reload",
+			Usage:    "Run daemon-reload before acting on units whose unit files changed on disk",
+			Value:    &plugin.DaemonReload,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "min_uptime",
+			Env:      "SYSTEMD_MIN_UPTIME",
+			Argument: "min-uptime",
+			Usage:    "Skip units (re)started on the remote host within this window (e.g. 10m), 0 to disable",
+			Value:    &plugin.MinUptime,
+			Default:  "0s",
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "unmask_if_masked",
+			Env:      "SYSTEMD_UNMASK_IF_MASKED",
+			Argument: "unmask-if-masked",
+			Usage:    "Unmask (runtime) masked units before starting them, otherwise they fail with unit is masked error",
+			Value:    &plugin.UnmaskIfMasked,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "of_target",
+			Env:      "SYSTEMD_OF_TARGET",
+			Argument: "of-target",
+			Usage:    "Act only on units in the Requires/Wants closure of this target (e.g. openstack.target)",
+			Value:    &plugin.OfTarget,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "fragment_path",
+			Env:      "SYSTEMD_FRAGMENT_PATHS",
+			Argument: "fragment-path",
+			Usage:    "Act only on units whose unit files live under this directory (e.g. /etc/systemd/system/myapp/)",
+			Value:    &plugin.FragmentPaths,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "verify_check",
+			Env:      "SYSTEMD_VERIFY_CHECK",
+			Argument: "verify-check",
+			Usage:    "After the action run --verify-command on the remote host, zero exit confirms recovery",
+			Value:    &plugin.VerifyCheck,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_VERIFY_COMMAND",
+			Argument: "verify-command",
+			Usage:    "Remote command of --verify-check, e.g. the check command",
+			Value:    &plugin.VerifyCommand,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "verify_timeout",
+			Env:      "SYSTEMD_VERIFY_TIMEOUT",
+			Argument: "verify-timeout",
+			Usage:    "Time the check has to pass in --verify-check",
+			Value:    &plugin.VerifyTimeout,
+			Default:  "1m",
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "unit_results",
+			Env:      "SYSTEMD_UNIT_RESULTS",
+			Argument: "unit-results",
+			Usage:    "Submit systemd-remediation-<unit> check result per acted unit for the target host proxy entity via the agent API",
+			Value:    &plugin.UnitResults,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_REQUIRE_SUBSCRIPTION",
+			Argument: "require-subscription",
+			Usage:    "Act only when the entity has this subscription (e.g. auto-remediate)",
+			Value:    &plugin.RequireSubscription,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_REQUIRE_LABEL",
+			Argument: "require-label",
+			Usage:    "Act only when the check or entity carries this label (key=value, e.g. auto_remediate=true)",
+			Value:    &plugin.RequireLabel,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Env:      "SYSTEMD_NAMESPACES",
+			Argument: "namespace",
+			Usage:    "Act only on events from these Sensu namespace(s)",
+			Value:    &plugin.Namespaces,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Env:      "SYSTEMD_ENTITY_CLASSES",
+			Argument: "entity-class",
+			Usage:    "Act only on entities of these class(es): agent, proxy",
+			Value:    &plugin.EntityClasses,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "keepalive",
+			Env:      "SYSTEMD_KEEPALIVE",
+			Argument: "keepalive",
+			Usage:    "On keepalive events restart --keepalive-unit instead of the configured units",
+			Value:    &plugin.Keepalive,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "keepalive_unit",
+			Env:      "SYSTEMD_KEEPALIVE_UNIT",
+			Argument: "keepalive-unit",
+			Usage:    "Unit restarted on keepalive events",
+			Value:    &plugin.KeepaliveUnit,
+			Default:  "sensu-agent.service",
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "on_resolve",
+			Env:      "SYSTEMD_ON_RESOLVE",
+			Argument: "on-resolve",
+			Usage:    "Action on resolve events (check status 0): none, reset-failed or any --action",
+			Value:    &plugin.OnResolve,
+			Default:  "none",
+			Allow:    allowedResolveActions,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "max_event_age",
+			Env:      "SYSTEMD_MAX_EVENT_AGE",
+			Argument: "max-event-age",
+			Usage:    "Do not act on events older than this (e.g. 10m), 0 to disable",
+			Value:    &plugin.MaxEventAge,
+			Default:  "0s",
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "output_format",
+			Env:      "SYSTEMD_OUTPUT_FORMAT",
+			Argument: "output-format",
+			Usage:    "Result output format: text, json",
+			Value:    &plugin.OutputFormat,
+			Default:  "text",
+			Allow:    []string{"text", "json"},
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "otlp_endpoint",
+			Env:      "SYSTEMD_OTLP_ENDPOINT",
+			Argument: "otlp-endpoint",
+			Usage:    "OTLP/HTTP traces endpoint URL (e.g. http://collector:4318/v1/traces), empty to disable tracing",
+			Value:    &plugin.OTLPEndpoint,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "event-file",
+			Usage:    "Read event JSON from the file (run subcommand only)",
+			Value:    &plugin.EventFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "log_level",
+			Env:      "SYSTEMD_LOG_LEVEL",
+			Argument: "log-level",
+			Usage:    "Log level: debug, info, warn, error",
+			Value:    &plugin.LogLevel,
+			Default:  "info",
+			Allow:    allowedLogLevels,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "log_format",
+			Env:      "SYSTEMD_LOG_FORMAT",
+			Argument: "log-format",
+			Usage:    "Log format: text, json",
+			Value:    &plugin.LogFormat,
+			Default:  "text",
+			Allow:    allowedLogFormats,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "log_syslog",
+			Env:      "SYSTEMD_LOG_SYSLOG",
+			Argument: "log-syslog",
+			Usage:    "Duplicate handler logs to the local syslog/journal",
+			Value:    &plugin.LogSyslog,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "skip_heartbeat",
+			Env:      "SYSTEMD_SKIP_HEARTBEAT",
+			Argument: "skip-heartbeat",
+			Usage:    "When not acting on the event, write systemd_handler.skipped metric and post the --report-event noting the reason",
+			Value:    &plugin.SkipHeartbeat,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "statsd_addr",
+			Env:      "SYSTEMD_STATSD_ADDR",
+			Argument: "statsd-addr",
+			Usage:    "StatsD UDP address (host:port) to send action timers and counters to",
+			Value:    &plugin.StatsdAddr,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "statsd_prefix",
+			Env:      "SYSTEMD_STATSD_PREFIX",
+			Argument: "statsd-prefix",
+			Usage:    "StatsD metric name prefix",
+			Value:    &plugin.StatsdPrefix,
+			Default:  metricPrefix,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "journal_lines",
+			Env:      "SYSTEMD_JOURNAL_LINES",
+			Argument: "journal-lines",
+			Usage:    "Include last N remote journal lines of each acted unit in the output, 0 to disable",
+			Value:    &plugin.JournalLines,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "remote_audit_tag",
+			Env:      "SYSTEMD_REMOTE_AUDIT_TAG",
+			Argument: "remote-audit-tag",
+			Usage:    "Record performed actions in the target host journal with logger under this tag (e.g. sensu-remediation)",
+			Value:    &plugin.RemoteAuditTag,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "property_report",
+			Env:      "SYSTEMD_PROPERTY_REPORT",
+			Argument: "property-report",
+			Usage:    "Report unit state properties before and after the action",
+			Value:    &plugin.PropertyReport,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "propagation_report",
+			Env:      "SYSTEMD_PROPAGATION_REPORT",
+			Argument: "propagation-report",
+			Usage:    "Report units restarted or stopped along with the acted unit (PartOf=, BindsTo=)",
+			Value:    &plugin.PropagationReport,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_REPORT_FILE",
+			Argument: "report-file",
+			Usage:    "Write JSON record of the run to the file, or to <event id>.json if it is a directory",
+			Value:    &plugin.ReportFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "list_method",
+			Env:      "SYSTEMD_LIST_METHOD",
+			Argument: "list-method",
+			Usage:    "Unit listing method: auto (introspect), by-patterns, filtered, all",
+			Value:    &plugin.ListMethod,
+			Default:  "auto",
+			Allow:    service.ListMethods,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max_parallel",
+			Env:      "SYSTEMD_MAX_PARALLEL",
+			Argument: "max-parallel",
+			Usage:    "Maximum number of unit actions running at once per host",
+			Value:    &plugin.MaxParallel,
+			Default:  8,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "resume",
+			Env:      "SYSTEMD_RESUME",
+			Argument: "resume",
+			Usage:    "Record units acted on per event ID in the state file and skip them when the same event is handled again, e.g. on a retry after interruption",
+			Value:    &plugin.Resume,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "dedup_ttl",
+			Env:      "SYSTEMD_DEDUP_TTL",
+			Argument: "dedup-ttl",
+			Usage:    "Skip re-deliveries of an event handled successfully within this long, by event ID in the state file (e.g. 24h, empty disables)",
+			Value:    &plugin.DedupTTL,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "progress_interval",
+			Env:      "SYSTEMD_PROGRESS_INTERVAL",
+			Argument: "progress-interval",
+			Usage:    "Log done/failed unit actions and ETA of a host at this interval, 0 to disable",
+			Value:    &plugin.ProgressInterval,
+			Default:  "10s",
+		},
+		&sensu.PluginConfigOption[int]{
+			Env:      "SYSTEMD_MAX_TUNNELS",
+			Argument: "max-tunnels",
+			Usage:    "Maximum number of SSH tunnels open at once by the handler process, mux handles one host group at a time so it only bounds the hosts of one event there",
+			Value:    &plugin.MaxTunnels,
+			Default:  16,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "escape_instance",
+			Env:      "SYSTEMD_ESCAPE_INSTANCE",
+			Argument: "escape-instance",
+			Usage:    "Apply systemd-escape to template instance strings of unit names (e.g. getty@/dev/tty1.service)",
+			Value:    &plugin.EscapeInstance,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "min_systemd_version",
+			Env:      "SYSTEMD_MIN_SYSTEMD_VERSION",
+			Argument: "min-systemd-version",
+			Usage:    "Fail early if the remote systemd is older than this version, 0 to disable",
+			Value:    &plugin.MinSystemdVersion,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "skip_no_systemd",
+			Env:      "SYSTEMD_SKIP_NO_SYSTEMD",
+			Argument: "skip-no-systemd",
+			Usage:    "Succeed without action on targets where systemd is not PID 1 (e.g. containers)",
+			Value:    &plugin.SkipNoSystemd,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "system_state_gate",
+			Env:      "SYSTEMD_SYSTEM_STATE_GATE",
+			Argument: "system-state-gate",
+			Usage:    "While the target is starting, stopping or in maintenance: off (act anyway), skip, wait (up to --system-state-wait)",
+			Value:    &plugin.SystemStateGate,
+			Default:  "off",
+			Allow:    systemStateGates,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "system_state_wait",
+			Env:      "SYSTEMD_SYSTEM_STATE_WAIT",
+			Argument: "system-state-wait",
+			Usage:    "How long --system-state-gate wait waits for the target to finish booting or maintenance",
+			Value:    &plugin.SystemStateWait,
+			Default:  "5m",
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "pending_jobs",
+			Env:      "SYSTEMD_PENDING_JOBS",
+			Argument: "pending-jobs",
+			Usage:    "Units with a queued or running job: ignore (queue another), skip (in-progress result), wait (up to --pending-jobs-wait, then skip)",
+			Value:    &plugin.PendingJobs,
+			Default:  "ignore",
+			Allow:    pendingJobsModes,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "pending_jobs_wait",
+			Env:      "SYSTEMD_PENDING_JOBS_WAIT",
+			Argument: "pending-jobs-wait",
+			Usage:    "How long --pending-jobs wait waits for queued jobs of the units",
+			Value:    &plugin.PendingJobsWait,
+			Default:  "1m",
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_PRE_HOOK",
+			Argument: "pre-hook",
+			Usage:    "Shell command to run on the remote host before acting, remediation is aborted if it fails",
+			Value:    &plugin.PreHook,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_POST_HOOK",
+			Argument: "post-hook",
+			Usage:    "Shell command to run on the remote host after the action and verification, its failure is reported separately",
+			Value:    &plugin.PostHook,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_DRAIN_URL",
+			Argument: "drain-url",
+			Usage:    "URL template to call before acting, remediation is aborted if it fails",
+			Value:    &plugin.DrainURL,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "drain_body",
+			Env:      "SYSTEMD_DRAIN_BODY",
+			Argument: "drain-body",
+			Usage:    "JSON body template of the drain call",
+			Value:    &plugin.DrainBody,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "SYSTEMD_UNDRAIN_URL",
+			Argument: "undrain-url",
+			Usage:    "URL template to call after the action and verification",
+			Value:    &plugin.UndrainURL,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "undrain_body",
+			Env:      "SYSTEMD_UNDRAIN_BODY",
+			Argument: "undrain-body",
+			Usage:    "JSON body template of the undrain call",
+			Value:    &plugin.UndrainBody,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "drain_method",
+			Env:      "SYSTEMD_DRAIN_METHOD",
+			Argument: "drain-method",
+			Usage:    "HTTP method of the drain and undrain calls",
+			Value:    &plugin.DrainMethod,
+			Default:  http.MethodPost,
+			Allow:    []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet},
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "drain_header",
+			Env:      "SYSTEMD_DRAIN_HEADERS",
+			Argument: "drain-header",
+			Usage:    "Header of the drain and undrain calls as Name: value (e.g. Authorization: Bearer ...)",
+			Value:    &plugin.DrainHeaders,
+			Secret:   true,
+		},
 	}
 )
 
 func main() {
-	handler := sensu.NewGoHandler(&plugin.PluginConfig, options, checkArgs, executeHandler)
-	handler.Execute()
+	err := setupChaos()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+
+	switch subcommand() {
+	case "hook":
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkHookArgs, executeCheckMode, false)
+		check.Execute()
+
+	case "run":
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkRunArgs, executeCheckMode, false)
+		check.Execute()
+
+	case "mux":
+		plugin.Short = "Handles newline-delimited events from stdin, one host group at a time: groups never run concurrently"
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkMuxArgs, executeMux, false)
+		check.Execute()
+
+	case "probe":
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkProbeArgs, executeProbe, false)
+		check.Execute()
+
+	case "list-actions":
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkListArgs, executeListActions, false)
+		check.Execute()
+
+	case "list-modes":
+		check := sensu.NewCheck(&plugin.PluginConfig, options, checkListArgs, executeListModes, false)
+		check.Execute()
+
+	default:
+		handler := sensu.NewGoHandler(&plugin.PluginConfig, options, checkArgs, executeHandlerMode)
+		handler.Execute()
+	}
+}
+
+// subcommand detects an alternative run mode given as the first argument and removes it from os.Args
+func subcommand() string {
+	if len(os.Args) < 2 {
+		return ""
+	}
+
+	switch os.Args[1] {
+	case "hook", "run", "mux", "probe", "list-actions", "list-modes":
+		cmd := os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return cmd
+
+	default:
+		return ""
+	}
 }
 
 func stringsContains(sl []string, s string) bool {
@@ -130,8 +1057,8 @@ func stringsContains(sl []string, s string) bool {
 
 type actionFunc func(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
 
-func getActionFunc(conn *dbus.Conn) (actionFunc, error) {
-	switch plugin.Action {
+func getActionFunc(conn service.SystemdConnection, action string) (actionFunc, error) {
+	switch action {
 	case "start":
 		return conn.StartUnitContext, nil
 
@@ -153,106 +1080,741 @@ func getActionFunc(conn *dbus.Conn) (actionFunc, error) {
 	case "reload-or-try-restart":
 		return conn.ReloadOrTryRestartUnitContext, nil
 
+	case "mark-restart", "mark-reload":
+		marker := service.MarkerNeedsRestart
+		if action == "mark-reload" {
+			marker = service.MarkerNeedsReload
+		}
+
+		return func(ctx context.Context, name string, _ string, ch chan<- string) (int, error) {
+			err := service.Mark(ctx, conn, name, marker)
+			if err != nil {
+				return 0, err
+			}
+
+			go func() { ch <- "done" }()
+			return 0, nil
+		}, nil
+
+	case "reset-failed":
+		return func(ctx context.Context, name string, _ string, ch chan<- string) (int, error) {
+			err := conn.ResetFailedUnitContext(ctx, name)
+			if err != nil {
+				return 0, err
+			}
+
+			go func() { ch <- "done" }()
+			return 0, nil
+		}, nil
+
 	default:
-		return nil, fmt.Errorf("unsupported action: %s", plugin.Action)
+		return nil, fmt.Errorf("unsupported action: %s", action)
+	}
+}
+
+// daemonReloadIfNeeded reloads the manager once when any of the units has NeedDaemonReload set,
+// so the actions pick up edited unit files
+func daemonReloadIfNeeded(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection, units []string) error {
+	var stale []string
+	for _, unit := range units {
+		need, err := service.NeedDaemonReload(ctx, conn, unit)
+		if err != nil {
+			logger.Warn("NeedDaemonReload check error", "unit", unit, "error", err)
+			continue
+		}
+		if need {
+			stale = append(stale, unit)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	logger.Info("Unit files changed on disk, running daemon-reload", "units", stale)
+	err := conn.ReloadContext(ctx)
+	if err != nil {
+		return fmt.Errorf("daemon-reload error: %w", err)
+	}
+
+	return nil
+}
+
+// canonicalNames resolves unit aliases to unit Ids, dropping duplicates, so logs and state keys do not depend on the alias used
+func canonicalNames(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection, units []string) []string {
+	out := make([]string, 0, len(units))
+	seen := make(map[string]bool)
+	for _, unit := range units {
+		name, err := service.CanonicalName(ctx, conn, unit)
+		if err != nil {
+			logger.Warn("Unit alias resolution error", "unit", unit, "error", err)
+			name = unit
+		} else if name != unit {
+			logger.Info("Resolved unit alias", "alias", unit, "unit", name)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// filterFragmentPaths keeps units whose unit file is under any of the directories
+func filterFragmentPaths(ctx context.Context, conn service.SystemdConnection, units, dirs []string) ([]string, error) {
+	out := make([]string, 0, len(units))
+	for _, unit := range units {
+		path, err := service.FragmentPath(ctx, conn, unit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dir := range dirs {
+			if path != "" && strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+				out = append(out, unit)
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// expandTargets replaces targets in the unit list with their member units, keeping the order and dropping duplicates
+func expandTargets(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection, units []string) ([]string, error) {
+	out := make([]string, 0, len(units))
+	seen := make(map[string]bool)
+	add := func(unit string) {
+		if !seen[unit] {
+			seen[unit] = true
+			out = append(out, unit)
+		}
+	}
+
+	for _, unit := range units {
+		if !strings.HasSuffix(unit, ".target") {
+			add(unit)
+			continue
+		}
+
+		members, err := service.ExpandTarget(ctx, conn, unit)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Expanded target", "target", unit, "members", members)
+
+		for _, m := range members {
+			add(m)
+		}
+	}
+
+	return out, nil
+}
+
+// cancelJobsFunc makes the cancel-jobs action: cancel pending jobs of the unit, e.g. a never-finishing stop job
+func cancelJobsFunc(logger *slog.Logger, conn service.SystemdConnection, canceler service.JobCanceler) actionFunc {
+	return func(ctx context.Context, name string, _ string, ch chan<- string) (int, error) {
+		n, err := service.CancelUnitJobs(ctx, conn, canceler, name)
+		if err != nil {
+			return 0, err
+		}
+
+		logger.Info("Canceled jobs", "unit", name, "jobs", n)
+		go func() { ch <- "done" }()
+		return 0, nil
 	}
 }
 
-func checkArgs(_ *corev2.Event) error {
-	allowedActions := []string{"start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "reload-or-try-restart"}
-	allowedModes := []string{"replace", "fail", "isolate", "ignore-dependencies", "ignore-requirements"}
+// presetFunc makes the preset action: bring the unit file enablement back to the preset policy, e.g. on drift
+func presetFunc(logger *slog.Logger, conn service.SystemdConnection, presetter service.UnitFilePresetter) actionFunc {
+	return func(ctx context.Context, name string, _ string, ch chan<- string) (int, error) {
+		changes, err := presetter.PresetUnitFiles(ctx, []string{name})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, change := range changes {
+			logger.Info("Preset changed unit file", "unit", name, "type", change.Type, "file", change.Filename, "destination", change.Destination)
+		}
+		if len(changes) > 0 {
+			err = conn.ReloadContext(ctx)
+			if err != nil {
+				return 0, fmt.Errorf("daemon-reload error: %w", err)
+			}
+		}
 
-	if len(plugin.UnitPatterns) == 0 {
+		go func() { ch <- "done" }()
+		return 0, nil
+	}
+}
+
+// revertFunc makes the revert action: drop local overrides of the unit, then restart it with the vendor configuration
+func revertFunc(logger *slog.Logger, conn service.SystemdConnection, reverter service.UnitFileReverter) actionFunc {
+	return func(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+		changes, err := reverter.RevertUnitFiles(ctx, []string{name})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, change := range changes {
+			logger.Warn("Reverted unit file override", "unit", name, "type", change.Type, "file", change.Filename)
+		}
+		if len(changes) > 0 {
+			err = conn.ReloadContext(ctx)
+			if err != nil {
+				return 0, fmt.Errorf("daemon-reload error: %w", err)
+			}
+		}
+
+		return conn.RestartUnitContext(ctx, name, mode, ch)
+	}
+}
+
+// parseDuration parses duration option value
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("--%s parse error: %w", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("--%s must not be negative: %s", name, value)
+	}
+
+	return d, nil
+}
+
+func checkArgs(event *corev2.Event) error {
+	err := expandStructuredConfig(event)
+	if err != nil {
+		return err
+	}
+
+	err = setupLogger(plugin.LogLevel, plugin.LogFormat, plugin.LogSyslog)
+	if err != nil {
+		return err
+	}
+	if chaos != nil {
+		slog.Warn("Fault injection enabled, not for production", "chaos", chaos.spec)
+	}
+
+	service.CallTimeout, err = parseDuration("dbus-call-timeout", plugin.DBusCallTimeout)
+	if err != nil {
+		return err
+	}
+
+	if plugin.RequireLabel != "" {
+		if key, _, ok := strings.Cut(plugin.RequireLabel, "="); !ok || key == "" {
+			return fmt.Errorf("--require-label: expected key=value, got %q", plugin.RequireLabel)
+		}
+	}
+
+	for _, class := range plugin.EntityClasses {
+		if class != corev2.EntityAgentClass && class != corev2.EntityProxyClass {
+			return fmt.Errorf("--entity-class: unknown class %q, expected %s or %s", class, corev2.EntityAgentClass, corev2.EntityProxyClass)
+		}
+	}
+
+	skip(eventSkipReason(event))
+
+	if plugin.Keepalive && isKeepalive(event) {
+		plugin.UnitPatterns = []string{plugin.KeepaliveUnit}
+		plugin.MatchUnits = false
+		plugin.UnitStates = nil
+		plugin.Action = "restart"
+	}
+
+	matched, compat, err := remediationMatches(event)
+	if err != nil {
+		return err
+	}
+	if compat {
+		if len(plugin.UnitPatterns) == 0 {
+			return fmt.Errorf("--unit is required with %s annotation, its request names a Sensu check, not a unit", remediationActionsAnnotation)
+		}
+		if len(matched) == 0 && plugin.skipReason == "" {
+			skip("no_match", "no "+remediationActionsAnnotation+" action matches event occurrences, severity and subscriptions")
+		}
+	}
+
+	if len(plugin.UnitPatterns) == 0 && plugin.skipReason == "" {
 		return fmt.Errorf("--unit or SYSTEMD_UNIT environment variable is required")
 	}
+	if plugin.EscapeInstance {
+		escaped := make([]string, 0, len(plugin.UnitPatterns))
+		for _, name := range plugin.UnitPatterns {
+			escaped = append(escaped, service.EscapeInstance(name))
+		}
+		plugin.UnitPatterns = escaped
+	}
+	for _, name := range plugin.UnitPatterns {
+		err = service.ValidateUnitName(name, plugin.MatchUnits)
+		if err != nil {
+			return fmt.Errorf("--unit: %w", err)
+		}
+	}
+	if len(plugin.UnitStates) > 0 && !plugin.MatchUnits {
+		return fmt.Errorf("--unit-state requires --match")
+	}
 	if !stringsContains(allowedActions, plugin.Action) {
 		return fmt.Errorf("--action must be one of %v, but it is: %v", allowedActions, plugin.Action)
 	}
 	if !stringsContains(allowedModes, plugin.Mode) {
 		return fmt.Errorf("--mode must be one of %v, but it is: %v", allowedModes, plugin.Mode)
 	}
+	if !stringsContains(allowedResolveActions, plugin.OnResolve) {
+		return fmt.Errorf("--on-resolve must be one of %v, but it is: %v", allowedResolveActions, plugin.OnResolve)
+	}
+	if isResolve(event) {
+		if plugin.OnResolve == "none" && plugin.skipReason == "" {
+			skip("resolve", "resolve event")
+		}
+		plugin.Action = plugin.OnResolve
+		plugin.TwoPhase = false
+	}
+	if plugin.TwoPhase {
+		if !stringsContains(allowedFirstActions, plugin.FirstAction) {
+			return fmt.Errorf("--first-action must be one of %v, but it is: %v", allowedFirstActions, plugin.FirstAction)
+		}
 
-	return nil
-}
+		plugin.escalationWindow, err = parseDuration("escalation-window", plugin.EscalationWindow)
+		if err != nil {
+			return err
+		}
+	}
+	modeChecked := []string{plugin.Action}
+	if plugin.TwoPhase {
+		modeChecked = append(modeChecked, plugin.FirstAction)
+	}
+	plugin.unitModes = plugin.unitModes[:0]
+	for _, spec := range plugin.UnitModes {
+		um, err := parseUnitMode(spec)
+		if err != nil {
+			return fmt.Errorf("--unit-mode: %w", err)
+		}
+		plugin.unitModes = append(plugin.unitModes, um)
+	}
+	for _, mode := range configuredModes() {
+		err = checkMode(mode, modeChecked...)
+		if err != nil {
+			return err
+		}
+	}
 
-func executeHandler(event *corev2.Event) error {
-	ctx := context.Background()
+	plugin.jitter, err = parseDuration("jitter", plugin.Jitter)
+	if err != nil {
+		return err
+	}
+	plugin.minUptime, err = parseDuration("min-uptime", plugin.MinUptime)
+	if err != nil {
+		return err
+	}
+	plugin.maxEventAge, err = parseDuration("max-event-age", plugin.MaxEventAge)
+	if err != nil {
+		return err
+	}
+	if age := eventAge(event, time.Now()); plugin.maxEventAge > 0 && age > plugin.maxEventAge && plugin.skipReason == "" {
+		skip("stale", fmt.Sprintf("stale event: %s old, --max-event-age is %s", age.Round(time.Second), plugin.maxEventAge))
+	}
+	if plugin.MaxActionsPerHour < 0 {
+		return fmt.Errorf("--max-actions-per-hour must not be negative")
+	}
+	if plugin.VaultSSHKey != "" || plugin.VaultSSHIssue != "" {
+		switch {
+		case plugin.VaultSSHKey != "" && plugin.VaultSSHIssue != "":
+			return fmt.Errorf("--vault-ssh-key and --vault-ssh-issue are mutually exclusive")
+		case plugin.VaultAddr == "":
+			return fmt.Errorf("--vault-addr is required to read SSH credentials from vault")
+		case plugin.VaultToken == "" && (plugin.VaultRoleID == "" || plugin.VaultSecretID == ""):
+			return fmt.Errorf("vault token or AppRole role ID and secret ID are required")
+		}
+	}
+	if plugin.EpisodeLimit < 0 {
+		return fmt.Errorf("--episode-limit must not be negative")
+	}
+	plugin.dedupTTL, err = parseDuration("dedup-ttl", plugin.DedupTTL)
+	if err != nil {
+		return err
+	}
+	plugin.progressInterval, err = parseDuration("progress-interval", plugin.ProgressInterval)
+	if err != nil {
+		return err
+	}
+	if plugin.MaxParallel < 1 {
+		return fmt.Errorf("--max-parallel must be positive")
+	}
+	if plugin.MaxTunnels < 1 {
+		return fmt.Errorf("--max-tunnels must be positive")
+	}
+	if plugin.PendingJobs == "wait" {
+		plugin.pendingJobsWait, err = parseDuration("pending-jobs-wait", plugin.PendingJobsWait)
+		if err != nil {
+			return err
+		}
+	}
+	if plugin.SystemStateGate == "wait" {
+		plugin.systemStateWait, err = parseDuration("system-state-wait", plugin.SystemStateWait)
+		if err != nil {
+			return err
+		}
+	}
+	if plugin.Rolling {
+		if plugin.MaxUnavailable < 1 {
+			return fmt.Errorf("--max-unavailable must be positive")
+		}
+		plugin.rollingTimeout, err = parseDuration("rolling-timeout", plugin.RollingTimeout)
+		if err != nil {
+			return err
+		}
+		if plugin.rollingTimeout <= 0 {
+			return fmt.Errorf("--rolling-timeout must be positive")
+		}
+	}
+	if plugin.VerifyCheck {
+		if plugin.VerifyCommand == "" {
+			return fmt.Errorf("--verify-check needs --verify-command")
+		}
+		plugin.verifyTimeout, err = parseDuration("verify-timeout", plugin.VerifyTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	for _, dir := range plugin.FragmentPaths {
+		if !strings.HasPrefix(dir, "/") {
+			return fmt.Errorf("--fragment-path must be absolute, but it is: %s", dir)
+		}
+	}
+	if plugin.OfTarget != "" && !strings.HasSuffix(plugin.OfTarget, ".target") {
+		return fmt.Errorf("--of-target must be a .target unit, but it is: %s", plugin.OfTarget)
+	}
+	for _, kv := range plugin.SetEnv {
+		if !envAssignment.MatchString(kv) {
+			return fmt.Errorf("invalid --set-env %q, expected KEY=VALUE", kv)
+		}
+	}
+	for _, h := range plugin.DrainHeaders {
+		if !strings.Contains(h, ":") {
+			return fmt.Errorf("invalid --drain-header %q, expected Name: value", h)
+		}
+	}
+	plugin.policy = nil
+	if plugin.Policy != "" {
+		plugin.policy, err = parsePolicy([]byte(plugin.Policy))
+		if err != nil {
+			return err
+		}
+	} else if plugin.PolicyFile != "" {
+		plugin.policy, err = loadPolicy(plugin.PolicyFile)
+		if err != nil {
+			return err
+		}
+	}
+	plugin.protectedUnits, err = service.CompileMatcher(plugin.ProtectedUnits)
+	if err != nil {
+		return fmt.Errorf("--protected-unit: %w", err)
+	}
+	plugin.blackouts = plugin.blackouts[:0]
+	for _, spec := range plugin.Blackouts {
+		w, err := parseBlackout(spec)
+		if err != nil {
+			return err
+		}
+		plugin.blackouts = append(plugin.blackouts, w)
+	}
+	service.RegisterSecret(plugin.SensuAPIKey, plugin.VaultToken, plugin.VaultSecretID)
+	for _, h := range plugin.DrainHeaders {
+		_, value, _ := strings.Cut(h, ":")
+		service.RegisterSecret(value)
+	}
 
-	if plugin.Tun.SSHHost == "" {
-		plugin.Tun.SSHHost = event.Entity.System.Hostname
+	actions := []string{plugin.Action}
+	if plugin.TwoPhase {
+		actions = append(actions, plugin.FirstAction)
+	}
+	if plugin.EnqueueMarked {
+		actions = append(actions, "enqueue-marked")
+	}
+	err = checkAllowlist(actions...)
+	if err != nil {
+		return err
 	}
+	return checkDestructive(event, actions, configuredModes())
+}
+
+func executeHandler(event *corev2.Event) (err error) {
+	ctx, stop := signalContext(context.Background())
+	defer stop()
 
-	log.Printf("Connecting ssh tunnel to: %s:%d", plugin.Tun.SSHHost, plugin.Tun.SSHPort)
-	stun, err := service.NewDBusTunnel(ctx, plugin.Tun)
+	plugin.correlationID = newCorrelationID(event)
+
+	audit, err := newAuditLogger(plugin.AuditFile, plugin.AuditSyslog)
 	if err != nil {
-		return fmt.Errorf("SSH Tunnel error: %w", err)
+		return fmt.Errorf("audit log error: %w", err)
 	}
-	defer stun.Close()
+	defer audit.Close()
+
+	logger := eventLogger(event)
 
-	conn, err := stun.New()
+	shutdownTracing, err := setupTracing(ctx, plugin.OTLPEndpoint)
 	if err != nil {
-		return fmt.Errorf("D-BUS error: %w", err)
+		return fmt.Errorf("tracing setup error: %w", err)
 	}
+	defer func() {
+		if err2 := shutdownTracing(context.Background()); err2 != nil {
+			logger.Warn("Tracing shutdown error", "error", err2)
+		}
+	}()
 
-	unitNames := make([]string, 0)
+	ctx, span := startSpan(ctx, "handler",
+		attribute.String("event.id", eventID(event)),
+		attribute.String("correlation.id", plugin.correlationID),
+		attribute.String("entity", entityName(event)),
+		attribute.String("action", plugin.Action))
+	defer func() { endSpan(span, err) }()
 
-	if plugin.MatchUnits {
-		log.Printf("Matching unit patterns...")
+	var results []unitResult
+	var reports []*hostReport
+	var metrics *corev2.Metrics
+	acting := false
+	startTime := time.Now()
+	defer func() {
+		code := runExitCode(results, err)
+		if code == exitVerifyFailed {
+			err = &exitCodeError{code: code, err: fmt.Errorf("%d unit(s) not verified", countUnverified(results))}
+		} else if code > exitError {
+			err = &exitCodeError{code: code, err: err}
+		}
 
-		// NOTE(vermakov): use local systemd to introspect remote methods
-		unitFetcher, err := service.InstrospectForUnitMethods(nil)
-		if err != nil {
-			return fmt.Errorf("could not introspect systemd dbus: %w", err)
+		summary := newRunSummary(event, plugin.skipReason, results, time.Since(startTime), err)
+		summary.Hosts = reports
+		summary.Outcome = outcomeNames[code]
+		summary.StartedAt = startTime
+		err2 := writeRunOutput(os.Stdout, plugin.OutputFormat, summary, metrics)
+		if err2 != nil {
+			logger.Error("Write summary error", "error", err2)
 		}
+		if plugin.ReportFile != "" {
+			if err2 := writeReportFile(plugin.ReportFile, summary); err2 != nil {
+				logger.Error("Write report file error", "error", err2)
+			}
+		}
+		if plugin.SkipHeartbeat && !acting && plugin.skipReason != "" {
+			skipHeartbeat(ctx, logger, event)
+		}
+		if plugin.StatsdAddr != "" {
+			if err2 := sendStatsd(plugin.StatsdAddr, statsdLines(plugin.StatsdPrefix, results, time.Since(startTime), plugin.skipKind)); err2 != nil {
+				logger.Warn("StatsD error", "addr", plugin.StatsdAddr, "error", err2)
+			}
+		}
+	}()
+
+	if plugin.skipReason != "" {
+		logger.Info("Skipped", "reason", plugin.skipReason)
+		return nil
+	}
 
-		unitStats, err := unitFetcher(ctx, conn, nil, plugin.UnitPatterns)
+	if id := eventID(event); plugin.dedupTTL > 0 && id != "" {
+		var at time.Time
+		var handled bool
+		err = updateState(plugin.StateFile, func(st *handlerState) error {
+			at, handled = handledAt(st, id, plugin.dedupTTL, time.Now())
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("list units error: %w", err)
+			return fmt.Errorf("dedup state error: %w", err)
+		}
+		if handled {
+			skip("duplicate", fmt.Sprintf("event %s already handled at %s", id, at.UTC().Format(time.RFC3339)))
+			logger.Info("Skipped: event already handled", "handled_at", at)
+			return nil
 		}
+	}
+
+	if w, ok := activeBlackout(plugin.blackouts, "", time.Now()); ok {
+		skip("blackout", "blackout window: "+w.spec)
+		logger.Info("Remediation disabled by blackout window", "blackout", w.spec)
+		return nil
+	}
 
-		for _, unit := range unitStats {
-			unitNames = append(unitNames, unit.Name)
+	if plugin.EpisodeLimit > 0 && event != nil && event.Check != nil && !isResolve(event) {
+		var allowed bool
+		var done int
+		key := strings.Join([]string{eventNamespace(event), entityName(event), event.Check.Name}, "/")
+		err = updateState(plugin.StateFile, func(st *handlerState) error {
+			allowed, done = reserveEpisode(st, key, event.Check.Occurrences, event.Check.OccurrencesWatermark, plugin.EpisodeLimit, time.Now())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("episode state error: %w", err)
+		}
+		if !allowed {
+			skip("episode", fmt.Sprintf("already remediated %d time(s) in this failure episode", done))
+			logger.Info("Skipped: failure episode already remediated", "actions", done, "occurrences", event.Check.Occurrences)
+			return nil
 		}
-	} else {
-		log.Printf("Use unit names as-is")
-		unitNames = append(unitNames, plugin.UnitPatterns...)
 	}
 
-	var wg sync.WaitGroup
-	errors := make(chan error, len(unitNames))
-	for idx, unitName := range unitNames {
-		log.Printf("%s: Triggering %s action (%d/%d)", unitName, plugin.Action, idx+1, len(unitNames))
-		wg.Add(1)
-		go func(unitName string) {
-			defer wg.Done()
+	if plugin.jitter > 0 {
+		delay := rand.N(plugin.jitter)
+		logger.Info("Sleeping before acting (jitter)", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
-			af, err2 := getActionFunc(conn)
-			if err2 != nil {
-				errors <- err2
+	acting = true
+	defer func() {
+		if plugin.Metrics {
+			metrics = remediationMetrics(results, time.Since(startTime), time.Now())
+			if plugin.SkipHeartbeat && plugin.skipReason != "" {
+				metrics.Points = append(metrics.Points, skipMetrics(plugin.skipKind, time.Now()).Points...)
 			}
+		}
 
-			resultCh := make(chan string)
+		if plugin.ReportEvent != "none" {
+			api := newSensuAPI(plugin.SensuAPIURL, plugin.SensuAPIKey)
+			if plugin.ReportEvent == "agent" {
+				api = newSensuAPI(plugin.AgentAPIURL, "")
+			}
 
-			_, err2 = af(ctx, unitName, plugin.Mode, resultCh)
+			ev := remediationEvent(event, results, err, plugin.ReportHandlers)
+			ev.Metrics = metrics
+			err2 := api.PostEvent(context.WithoutCancel(ctx), ev, plugin.ReportEvent == "agent")
 			if err2 != nil {
-				log.Printf("%s: Action error: %v", unitName, err2)
-				errors <- err2
-				return
+				logger.Error("Report event error", "error", err2)
 			}
+		}
 
-			result := <-resultCh
-			close(resultCh)
+		if plugin.UnitResults {
+			api := newSensuAPI(plugin.AgentAPIURL, "")
+			for _, ev := range unitResultEvents(event, results, plugin.ReportHandlers) {
+				err2 := api.PostEvent(context.WithoutCancel(ctx), ev, true)
+				if err2 != nil {
+					logger.Error("Unit result event error", "check", ev.Check.Name, "entity", ev.Check.ProxyEntityName, "error", err2)
+				}
+			}
+		}
+	}()
 
-			log.Printf("%s: result: %s", unitName, result)
-		}(unitName)
+	err = loadVaultCredentials(ctx, logger)
+	if err != nil {
+		return err
 	}
 
-	wg.Wait()
-	close(errors)
+	hosts, err := targetHosts(event)
+	if err != nil {
+		return err
+	}
 
-	for err2 := range errors {
-		err = multierr.Append(err, err2)
+	followers, leaders, err := splitLeaders(ctx, event, hosts)
+	if err != nil {
+		return err
+	}
+
+	reports, err = runHosts(ctx, event, followers, audit)
+	if len(leaders) > 0 {
+		if err != nil {
+			for _, host := range leaders {
+				err = multierr.Append(err, fmt.Errorf("%s: leader not acted on, other members failed", host))
+			}
+		} else {
+			logger.Info("Acting on cluster leaders last", "leaders", leaders)
+			var leaderReports []*hostReport
+			leaderReports, err = runHosts(ctx, event, leaders, audit)
+			reports = append(reports, leaderReports...)
+		}
+	}
+	for _, report := range reports {
+		results = append(results, report.Results...)
+	}
+
+	if plugin.Annotate != "none" && len(results) > 0 {
+		api := newSensuAPI(plugin.SensuAPIURL, plugin.SensuAPIKey)
+		err2 := api.Annotate(context.WithoutCancel(ctx), event, plugin.Annotate, outcomeAnnotations(results, plugin.correlationID, time.Now()))
+		if err2 != nil {
+			logger.Error("Annotate error", "target", plugin.Annotate, "error", err2)
+		}
+	}
+
+	if id := eventID(event); plugin.dedupTTL > 0 && id != "" && err == nil {
+		err2 := updateState(plugin.StateFile, func(st *handlerState) error {
+			markHandled(st, id, time.Now())
+			return nil
+		})
+		if err2 != nil {
+			logger.Warn("Dedup state error", "error", err2)
+		}
 	}
 
 	return err
 }
+
+// runHosts acts on the hosts: rolling, one at a time or in parallel
+func runHosts(ctx context.Context, event *corev2.Event, hosts []string, audit *auditLogger) (reports []*hostReport, err error) {
+	if plugin.Rolling {
+		var mu sync.Mutex
+		err = runRolling(ctx, hosts, plugin.MaxUnavailable, func(host string) error {
+			report, err2 := runHost(ctx, event, host, audit)
+
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, report)
+			return err2
+		})
+	} else if len(hosts) == 1 || plugin.SerialMembers {
+		for _, host := range hosts {
+			if ctx.Err() != nil {
+				err = multierr.Append(err, fmt.Errorf("%s: %w", host, ctx.Err()))
+				continue
+			}
+
+			report, err2 := runHost(ctx, event, host, audit)
+			reports = append(reports, report)
+			err = multierr.Append(err, err2)
+		}
+	} else {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, host := range hosts {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+
+				report, err2 := runHost(ctx, event, host, audit)
+
+				mu.Lock()
+				defer mu.Unlock()
+				reports = append(reports, report)
+				err = multierr.Append(err, err2)
+			}(host)
+		}
+		wg.Wait()
+	}
+
+	return reports, err
+}
+
+// targetHosts returns SSH targets for the event: --ssh-host, cluster members or the entity host
+func targetHosts(event *corev2.Event) ([]string, error) {
+	if plugin.Tun.SSHHost != "" {
+		return []string{plugin.Tun.SSHHost}, nil
+	}
+
+	if members := clusterMembers(event); len(members) > 0 {
+		eventLogger(event).Info("Fan-out to cluster members", "members", strings.Join(members, ","))
+		return members, nil
+	}
+
+	host, err := resolveSSHHost(event, plugin.ProxyHostLabel, plugin.SSHHostAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{host}, nil
+}
@@ -1,8 +1,774 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/google/uuid"
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
 )
 
 func TestMain(t *testing.T) {
 }
+
+// defaultConfig resets plugin to the option defaults for the test, as if run without flags
+func defaultConfig(t *testing.T) {
+	t.Helper()
+
+	saved := plugin
+	t.Cleanup(func() { plugin = saved })
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case *sensu.PluginConfigOption[string]:
+			*o.Value = o.Default
+		case *sensu.PluginConfigOption[bool]:
+			*o.Value = o.Default
+		case *sensu.PluginConfigOption[int]:
+			*o.Value = o.Default
+		case *sensu.SlicePluginConfigOption[string]:
+			*o.Value = slices.Clone(o.Default)
+		}
+	}
+}
+
+// handlerConfig configures runs restarting the failed services of node1
+func handlerConfig(t *testing.T) {
+	t.Helper()
+
+	defaultConfig(t)
+	plugin.skipReason, plugin.skipKind = "", ""
+	plugin.Tun.SSHHost = "node1"
+	plugin.OutputFormat = "json"
+	plugin.MatchUnits = true
+	plugin.ListMethod = "by-patterns"
+	plugin.UnitPatterns = []string{"*.service"}
+	plugin.UnitStates = []string{"failed"}
+	plugin.StateFile = filepath.Join(t.TempDir(), "state.json")
+}
+
+// runHandler runs executeHandler with the hosts served by conn and decodes the printed summary
+func runHandler(t *testing.T, event *corev2.Event, conn *servicetest.Conn) (runSummary, []string, error) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var connected []string
+	savedConnect := connectHost
+	t.Cleanup(func() { connectHost = savedConnect })
+	connectHost = func(h *hostRun, _ context.Context) (func(), error) {
+		mu.Lock()
+		defer mu.Unlock()
+		connected = append(connected, h.host)
+		h.conn = conn
+		return func() {}, nil
+	}
+
+	var runErr error
+	out := captureStdout(t, func() { runErr = executeHandler(event) })
+
+	var summary runSummary
+	if err := json.Unmarshal(out, &summary); err != nil {
+		t.Fatalf("summary decode error: %v", err)
+	}
+
+	return summary, connected, runErr
+}
+
+// captureStdout returns what fn prints
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	savedStdout := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = savedStdout
+	w.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+// shellRunner runs the remote commands with the local shell and records them
+type shellRunner struct {
+	commands []string
+}
+
+func (r *shellRunner) RunCommand(ctx context.Context, command string) ([]byte, error) {
+	r.commands = append(r.commands, command)
+	return exec.CommandContext(ctx, "sh", "-c", command).Output()
+}
+
+func TestAnnotationPrecedence(t *testing.T) {
+	var sshUser sensu.ConfigOption
+	for _, opt := range options {
+		if o, ok := opt.(*sensu.PluginConfigOption[string]); ok && o.Path == "ssh_user" {
+			sshUser = o
+		}
+	}
+	if sshUser == nil {
+		t.Fatal("ssh_user option not found")
+	}
+	defer func(user string) { plugin.Tun.User = user }(plugin.Tun.User)
+
+	key := plugin.Keyspace + "/ssh_user"
+	event := corev2.FixtureEvent("entity1", "check1")
+
+	plugin.Tun.User = "flag"
+	event.Entity.Annotations = map[string]string{key: "entity"}
+	if _, err := sshUser.SetAnnotationValue(plugin.Keyspace, event); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Tun.User != "entity" {
+		t.Errorf("expected entity override, got %s", plugin.Tun.User)
+	}
+
+	event.Check.Annotations = map[string]string{key: "check"}
+	if _, err := sshUser.SetAnnotationValue(plugin.Keyspace, event); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Tun.User != "check" {
+		t.Errorf("expected check override, got %s", plugin.Tun.User)
+	}
+}
+
+func TestFlagOnlyOptions(t *testing.T) {
+	// options naming hosts, commands, files or security gates must not be settable from event annotations
+	flagOnly := []string{
+		"sensu_api_url", "agent_api_url", "drain_url", "undrain_url", "health_url", "pre_hook",
+		"post_hook", "verify_command", "leader_command", "policy_file", "policy",
+		"require_subscription", "require_label", "namespaces", "entity_classes", "protected_units",
+		"audit_file", "state_file", "report_file",
+	}
+	for _, opt := range options {
+		if p := optionPath(opt); slices.Contains(flagOnly, p) {
+			t.Errorf("option %s must not have an annotation path", p)
+		}
+	}
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Annotations = map[string]string{plugin.Keyspace + "/structured": `{"sensu_api_url": "http://evil"}`}
+	if err := expandStructuredConfig(event); err == nil {
+		t.Error("expected flag-only option to be refused in the keyspace object")
+	}
+}
+
+func TestDestructiveGate(t *testing.T) {
+	tests := []struct {
+		action   string
+		mode     string
+		unitMode string
+		check    string
+		entity   string
+		ok       bool
+	}{
+		{action: "restart", mode: "replace", ok: true},
+		{action: "reload", mode: "fail", ok: true},
+		{action: "start", mode: "ignore-dependencies", ok: true},
+		{action: "stop", mode: "replace"},
+		{action: "stop", mode: "replace", check: "true", ok: true},
+		{action: "stop", mode: "replace", entity: "true", ok: true},
+		{action: "stop", mode: "replace", check: "false", entity: "true"},
+		{action: "stop", mode: "replace", check: "yes"},
+		{action: "preset", mode: "replace"},
+		{action: "preset", mode: "replace", check: "true", ok: true},
+		{action: "revert", mode: "replace"},
+		{action: "revert", mode: "replace", entity: "true", ok: true},
+		{action: "start", mode: "isolate"},
+		{action: "start", mode: "isolate", entity: "true", ok: true},
+		{action: "restart", mode: "flush"},
+		{action: "restart", mode: "replace", unitMode: "db-*.service=flush"},
+		{action: "restart", mode: "replace", unitMode: "db-*.service=flush", check: "true", ok: true},
+		{action: "restart", mode: "replace", unitMode: "db-*.service=fail", ok: true},
+	}
+	for _, tc := range tests {
+		name := fmt.Sprintf("%s/%s unit-mode=%q check=%q entity=%q", tc.action, tc.mode, tc.unitMode, tc.check, tc.entity)
+		t.Run(name, func(t *testing.T) {
+			defaultConfig(t)
+			plugin.UnitPatterns = []string{"nginx.service"}
+			plugin.Action, plugin.Mode = tc.action, tc.mode
+			if tc.unitMode != "" {
+				plugin.UnitModes = []string{tc.unitMode}
+			}
+
+			event := corev2.FixtureEvent("entity1", "check1")
+			event.Check.Status = 2
+			if tc.check != "" {
+				event.Check.Annotations = map[string]string{allowDestructiveAnnotation: tc.check}
+			}
+			if tc.entity != "" {
+				event.Entity.Annotations = map[string]string{allowDestructiveAnnotation: tc.entity}
+			}
+
+			err := checkArgs(event)
+			if tc.ok && err != nil {
+				t.Fatalf("expected allowed, got %v", err)
+			}
+			if !tc.ok && (err == nil || !strings.Contains(err.Error(), "refusing destructive")) {
+				t.Fatalf("expected refusal, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config func()
+		event  func(*corev2.Event)
+		err    string
+		check  func(*testing.T)
+	}{
+		{
+			name:   "verify check needs a command",
+			config: func() { plugin.VerifyCheck = true },
+			event:  func(ev *corev2.Event) { ev.Check.Command = "check-http -u http://localhost" },
+			err:    "--verify-command",
+		},
+		{
+			name: "verify check with command",
+			config: func() {
+				plugin.VerifyCheck = true
+				plugin.VerifyCommand = "systemctl is-active nginx.service"
+			},
+		},
+		{
+			name:   "jitter",
+			config: func() { plugin.Jitter = "30s" },
+			check: func(t *testing.T) {
+				if plugin.jitter != 30*time.Second {
+					t.Errorf("expected 30s jitter, got %s", plugin.jitter)
+				}
+			},
+		},
+		{name: "negative jitter", config: func() { plugin.Jitter = "-30s" }, err: "must not be negative"},
+		{name: "jitter parse error", config: func() { plugin.Jitter = "soon" }, err: "--jitter parse error"},
+		{name: "of-target not a target", config: func() { plugin.OfTarget = "openstack" }, err: "--of-target must be a .target unit"},
+		{name: "set-env without value", config: func() { plugin.SetEnv = []string{"GOMAXPROCS=4", "NOVALUE"} }, err: "invalid --set-env"},
+		{name: "set-env bad name", config: func() { plugin.SetEnv = []string{"GOMAXPROCS=4", "1ST=x"} }, err: "invalid --set-env"},
+		{name: "set-env empty name", config: func() { plugin.SetEnv = []string{"GOMAXPROCS=4", "=x"} }, err: "invalid --set-env"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlerConfig(t)
+			if tc.config != nil {
+				tc.config()
+			}
+
+			event := corev2.FixtureEvent("node1", "check-nginx")
+			event.Check.Status = 2
+			if tc.event != nil {
+				tc.event(event)
+			}
+
+			err := checkArgs(event)
+			if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected %q, got %v", tc.err, err)
+			}
+			if tc.check != nil {
+				tc.check(t)
+			}
+		})
+	}
+}
+
+func TestExecuteHandler(t *testing.T) {
+	handlerConfig(t)
+	plugin.dedupTTL = time.Hour
+
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed", "mysql.service": "failed", "cron.service": "active"})
+	conn.JobResults["mysql.service"] = "failed"
+
+	event := corev2.FixtureEvent("node1", "check-nginx")
+	id := uuid.New()
+	event.ID = id[:]
+
+	summary, connected, err := runHandler(t, event, conn)
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != exitPartialFailure {
+		t.Errorf("expected partial failure, got %v", err)
+	}
+	if !slices.Equal(connected, []string{"node1"}) {
+		t.Errorf("unexpected hosts: %v", connected)
+	}
+	if summary.Outcome != "partial failure" || summary.Skipped != "" || summary.Check != "check-nginx" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if len(summary.Hosts) != 1 || summary.Hosts[0].SystemdVersion != "252" || len(summary.Hosts[0].Units) != 2 {
+		t.Errorf("unexpected host reports: %+v", summary.Hosts)
+	}
+
+	results := make(map[string]string)
+	for _, r := range summary.Results {
+		results[r.Unit] = r.Result
+	}
+	if len(results) != 2 || results["nginx.service"] != "done" || results["mysql.service"] != "failed" {
+		t.Errorf("unexpected results: %+v", summary.Results)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0], "mysql.service") {
+		t.Errorf("unexpected errors: %v", summary.Errors)
+	}
+
+	t.Run("failed run is retried", func(t *testing.T) {
+		conn.JobResults["mysql.service"] = "done"
+
+		summary, connected, err := runHandler(t, event, conn)
+		if err != nil || len(connected) != 1 || summary.Outcome != "success" || len(summary.Results) != 1 {
+			t.Errorf("expected the failed unit retried, got %+v: %v", summary, err)
+		}
+	})
+
+	t.Run("handled event is skipped", func(t *testing.T) {
+		summary, connected, err := runHandler(t, event, conn)
+		if err != nil || len(connected) != 0 {
+			t.Errorf("expected no host acted on, got %v: %v", connected, err)
+		}
+		if !strings.HasPrefix(summary.Skipped, "event "+id.String()+" already handled") || len(summary.Results) != 0 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+	})
+
+	t.Run("checkArgs skip", func(t *testing.T) {
+		skip("no_match", "no remediation action matches")
+		t.Cleanup(func() { plugin.skipReason, plugin.skipKind = "", "" })
+
+		other := corev2.FixtureEvent("node1", "check-nginx")
+		summary, connected, err := runHandler(t, other, conn)
+		if err != nil || len(connected) != 0 || summary.Skipped != "no remediation action matches" || summary.Outcome != "success" {
+			t.Errorf("unexpected skipped run %+v: %v", summary, err)
+		}
+	})
+}
+
+// TestHandlerRun passes a failing check-nginx event of node1 through checkArgs and the handler run
+func TestHandlerRun(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config func()
+		event  func(*corev2.Event)
+		units  map[string]string
+		conn   func(*servicetest.Conn)
+
+		err       string
+		skipped   string
+		connected []string
+		// acted lists "host action unit" of the results, sorted
+		acted  []string
+		within time.Duration
+		check  func(*testing.T, runSummary, *servicetest.Conn)
+	}{
+		{
+			name:      "keepalive option leaves other checks alone",
+			config:    func() { plugin.Keepalive, plugin.Action = true, "reload" },
+			units:     map[string]string{"sensu-agent.service": "failed", "nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 reload nginx.service", "node1 reload sensu-agent.service"},
+		},
+		{
+			name:      "keepalive restarts the agent",
+			config:    func() { plugin.Keepalive, plugin.Action = true, "reload" },
+			event:     func(ev *corev2.Event) { ev.Check.Name = corev2.KeepaliveCheckName },
+			units:     map[string]string{"sensu-agent.service": "failed", "nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart sensu-agent.service"},
+		},
+		{
+			name:    "resolve skipped by default",
+			event:   func(ev *corev2.Event) { ev.Check.Status = 0 },
+			units:   map[string]string{"nginx.service": "failed"},
+			skipped: "resolve event",
+		},
+		{
+			name: "resolve resets failed units in a single phase",
+			config: func() {
+				plugin.Action, plugin.OnResolve, plugin.TwoPhase = "restart", "reset-failed", true
+			},
+			event:     func(ev *corev2.Event) { ev.Check.Status = 0 },
+			units:     map[string]string{"nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 reset-failed nginx.service"},
+			check: func(t *testing.T, _ runSummary, conn *servicetest.Conn) {
+				if calls := conn.Calls(); plugin.TwoPhase || len(calls) != 1 || calls[0].Method != "ResetFailedUnit" {
+					t.Errorf("unexpected calls, two-phase=%v: %v", plugin.TwoPhase, calls)
+				}
+			},
+		},
+		{
+			name:      "fresh event",
+			config:    func() { plugin.MaxEventAge = "10m" },
+			event:     func(ev *corev2.Event) { ev.Timestamp = time.Now().Add(-time.Minute).Unix() },
+			units:     map[string]string{"nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nginx.service"},
+		},
+		{
+			name:    "stale event",
+			config:  func() { plugin.MaxEventAge = "10m" },
+			event:   func(ev *corev2.Event) { ev.Timestamp = time.Now().Add(-time.Hour).Unix() },
+			units:   map[string]string{"nginx.service": "failed"},
+			skipped: "old, --max-event-age is 10m0s",
+		},
+		{
+			// the run acts after a random delay below the maximum
+			name:      "jitter",
+			config:    func() { plugin.Jitter = "100ms" },
+			units:     map[string]string{"nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nginx.service"},
+			within:    time.Second,
+		},
+		{
+			// check annotation overrides the entity one, every member is acted on
+			name: "cluster fan-out",
+			config: func() {
+				plugin.Tun.SSHHost = ""
+				plugin.MatchUnits, plugin.UnitStates = false, nil
+				plugin.UnitPatterns = []string{"mysql.service"}
+			},
+			event: func(ev *corev2.Event) {
+				ev.Entity.Annotations = map[string]string{membersAnnotation: "db1"}
+				ev.Check.Annotations = map[string]string{membersAnnotation: " db1, db2 ,,db3"}
+			},
+			units:     map[string]string{"mysql.service": "failed"},
+			connected: []string{"db1", "db2", "db3"},
+			acted:     []string{"db1 restart mysql.service", "db2 restart mysql.service", "db3 restart mysql.service"},
+			check: func(t *testing.T, summary runSummary, _ *servicetest.Conn) {
+				if len(summary.Hosts) != 3 {
+					t.Errorf("expected a report per member, got %+v", summary.Hosts)
+				}
+			},
+		},
+		{
+			name: "proxy entity",
+			config: func() {
+				plugin.Tun.SSHHost = ""
+			},
+			event: func(ev *corev2.Event) {
+				ev.Entity.EntityClass = corev2.EntityProxyClass
+				ev.Entity.System.Hostname = "sensu-backend"
+				ev.Entity.Labels = map[string]string{"ssh_host": "10.0.0.1"}
+			},
+			units:     map[string]string{"snmpd.service": "failed"},
+			connected: []string{"10.0.0.1"},
+			acted:     []string{"10.0.0.1 restart snmpd.service"},
+		},
+		{
+			// configured method skips introspection and still filters states and patterns
+			name: "configured list method",
+			config: func() {
+				plugin.ListMethod = "all"
+				plugin.UnitPatterns = []string{"nginx.*"}
+			},
+			units:     map[string]string{"nginx.service": "failed", "nginx.socket": "active", "mysql.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nginx.service"},
+			check: func(t *testing.T, summary runSummary, _ *servicetest.Conn) {
+				h := summary.Hosts[0]
+				if h.ListMethod != "all" || h.ListCall != "ListUnits" || h.ListSelection != "configured" || h.IntrospectSeconds != 0 {
+					t.Errorf("unexpected list report: %+v", h)
+				}
+			},
+		},
+		{
+			// failed units outside the target tree are left alone
+			name:   "of-target",
+			config: func() { plugin.OfTarget = "openstack.target" },
+			units: map[string]string{
+				"openstack.target":     "active",
+				"nova-api.service":     "failed",
+				"nova-compute.service": "failed",
+				"mysql.service":        "failed",
+			},
+			conn: func(conn *servicetest.Conn) {
+				conn.Properties["openstack.target"] = map[string]any{"Requires": []string{"nova-api.service"}, "Wants": []string{"nova-compute.service"}}
+			},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nova-api.service", "node1 restart nova-compute.service"},
+		},
+		{
+			name:      "systemd older than --min-systemd-version",
+			config:    func() { plugin.MinSystemdVersion = 253 },
+			units:     map[string]string{"nginx.service": "failed"},
+			conn:      func(conn *servicetest.Conn) { conn.Manager["Version"] = "252.5-2ubuntu3" },
+			err:       "node1: systemd 252.5-2ubuntu3 is older than 253 required for",
+			connected: []string{"node1"},
+			check: func(t *testing.T, _ runSummary, conn *servicetest.Conn) {
+				if calls := conn.Calls(); len(calls) != 0 {
+					t.Errorf("expected no action on old systemd, got %v", calls)
+				}
+			},
+		},
+		{
+			// per-action minimum applies over a lower --min-systemd-version
+			name:      "systemd older than the action needs",
+			config:    func() { plugin.MinSystemdVersion, plugin.Action = 219, "mark-restart" },
+			units:     map[string]string{"nginx.service": "failed"},
+			conn:      func(conn *servicetest.Conn) { conn.Manager["Version"] = "systemd 247" },
+			err:       "older than 248 required for mark-restart",
+			connected: []string{"node1"},
+		},
+		{
+			name:      "systemd new enough",
+			config:    func() { plugin.MinSystemdVersion = 219 },
+			units:     map[string]string{"nginx.service": "failed"},
+			conn:      func(conn *servicetest.Conn) { conn.Manager["Version"] = "systemd 247" },
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nginx.service"},
+			check: func(t *testing.T, summary runSummary, _ *servicetest.Conn) {
+				if v := summary.Hosts[0].SystemdVersion; v != "systemd 247" {
+					t.Errorf("unexpected systemd version: %s", v)
+				}
+			},
+		},
+		{
+			// environment is set at runtime before the restart picks it up
+			name:      "set-env",
+			config:    func() { plugin.SetEnv = []string{"GOMAXPROCS=4", "OPTS=--verbose --color=never"} },
+			units:     map[string]string{"nginx.service": "failed"},
+			connected: []string{"node1"},
+			acted:     []string{"node1 restart nginx.service"},
+			check: func(t *testing.T, _ runSummary, conn *servicetest.Conn) {
+				var methods []string
+				for _, c := range conn.Calls() {
+					methods = append(methods, c.Method)
+				}
+				if !slices.Equal(methods, []string{"SetUnitProperties", "RestartUnit"}) {
+					t.Errorf("unexpected calls: %v", methods)
+				}
+				if env, _ := conn.Properties["nginx.service"]["Environment"].([]string); !slices.Equal(env, plugin.SetEnv) {
+					t.Errorf("unexpected unit environment: %v", conn.Properties["nginx.service"])
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlerConfig(t)
+			if tc.config != nil {
+				tc.config()
+			}
+
+			event := corev2.FixtureEvent("node1", "check-nginx")
+			event.Check.Status = 2
+			if tc.event != nil {
+				tc.event(event)
+			}
+			if err := checkArgs(event); err != nil {
+				t.Fatal(err)
+			}
+
+			conn := servicetest.NewConn(tc.units)
+			if tc.conn != nil {
+				tc.conn(conn)
+			}
+
+			start := time.Now()
+			summary, connected, err := runHandler(t, event, conn)
+			if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+			if tc.within > 0 && time.Since(start) > tc.within {
+				t.Errorf("expected the run within %s, took %s", tc.within, time.Since(start))
+			}
+			if tc.skipped != "" && !strings.HasSuffix(summary.Skipped, tc.skipped) || tc.skipped == "" && summary.Skipped != "" {
+				t.Errorf("expected skip %q, got %q", tc.skipped, summary.Skipped)
+			}
+
+			slices.Sort(connected)
+			if !slices.Equal(connected, tc.connected) {
+				t.Errorf("expected hosts %v connected, got %v", tc.connected, connected)
+			}
+			var acted []string
+			for _, r := range summary.Results {
+				acted = append(acted, r.Host+" "+r.Action+" "+r.Unit)
+			}
+			slices.Sort(acted)
+			if !slices.Equal(acted, tc.acted) {
+				t.Errorf("expected %v, got %+v", tc.acted, summary.Results)
+			}
+
+			if tc.check != nil {
+				tc.check(t, summary, conn)
+			}
+		})
+	}
+}
+
+func TestMockConnAction(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{
+		"nginx.service": "failed",
+		"mysql.service": "active",
+	})
+
+	fetcher, err := service.UnitFetcherFor("by-patterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	units, err := fetcher(ctx, conn, nil, []string{"ngin*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].Name != "nginx.service" {
+		t.Fatalf("unexpected units: %v", units)
+	}
+
+	af, err := getActionFunc(conn, "restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan string, 1)
+	_, err = af(ctx, "nginx.service", "replace", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("expected done, got %s", result)
+	}
+
+	state, err := service.UnitState(ctx, conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "active (running)" {
+		t.Errorf("unexpected state: %s", state)
+	}
+
+	calls := conn.Calls()
+	if len(calls) != 1 || calls[0].Method != "RestartUnit" || calls[0].Mode != "replace" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestMarkAction(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "active"})
+
+	af, err := getActionFunc(conn, "mark-restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan string, 1)
+	if _, err := af(ctx, "nginx.service", "replace", ch); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("expected done, got %s", result)
+	}
+
+	markers, _ := conn.Properties["nginx.service"]["Markers"].([]string)
+	if len(markers) != 1 || markers[0] != service.MarkerNeedsRestart {
+		t.Errorf("unexpected markers: %v", markers)
+	}
+}
+
+func TestCancelJobsAction(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nfs.mount": "deactivating", "nginx.service": "active"})
+	conn.Jobs = []dbus.JobStatus{
+		{Id: 10, Unit: "nfs.mount", JobType: "stop", Status: "running"},
+		{Id: 11, Unit: "nginx.service", JobType: "reload", Status: "waiting"},
+	}
+
+	af := cancelJobsFunc(slog.New(slog.NewTextHandler(io.Discard, nil)), conn, conn)
+	ch := make(chan string, 1)
+	if _, err := af(ctx, "nfs.mount", "replace", ch); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("expected done, got %s", result)
+	}
+
+	if len(conn.Jobs) != 1 || conn.Jobs[0].Id != 11 {
+		t.Errorf("unexpected jobs left: %v", conn.Jobs)
+	}
+}
+
+func TestPresetAction(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "active", "cups.service": "inactive"})
+	conn.Presets = map[string]string{"nginx.service": "enabled", "cups.service": "disabled"}
+	conn.Properties["nginx.service"] = map[string]any{"UnitFileState": "disabled"}
+	conn.Properties["cups.service"] = map[string]any{"UnitFileState": "disabled"}
+
+	af := presetFunc(slog.New(slog.NewTextHandler(io.Discard, nil)), conn, conn)
+	for _, unit := range []string{"nginx.service", "cups.service"} {
+		ch := make(chan string, 1)
+		if _, err := af(ctx, unit, "replace", ch); err != nil {
+			t.Fatal(err)
+		}
+		if result := <-ch; result != "done" {
+			t.Errorf("%s: expected done, got %s", unit, result)
+		}
+	}
+
+	if state := conn.Properties["nginx.service"]["UnitFileState"]; state != "enabled" {
+		t.Errorf("nginx.service not enabled: %v", state)
+	}
+	// daemon-reload only after the drifted unit changed
+	calls := conn.Calls()
+	if len(calls) != 3 || calls[1].Method != "Reload" || calls[2].Unit != "cups.service" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestRevertAction(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed"})
+	conn.Properties["nginx.service"] = map[string]any{"DropInPaths": []string{"/etc/systemd/system/nginx.service.d/override.conf"}}
+
+	af := revertFunc(slog.New(slog.NewTextHandler(io.Discard, nil)), conn, conn)
+	ch := make(chan string, 1)
+	if _, err := af(ctx, "nginx.service", "replace", ch); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("expected done, got %s", result)
+	}
+
+	calls := conn.Calls()
+	if len(calls) != 3 || calls[0].Method != "RevertUnitFiles" || calls[1].Method != "Reload" || calls[2].Method != "RestartUnit" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestFilterFragmentPaths(t *testing.T) {
+	conn := servicetest.NewConn(map[string]string{"api.service": "failed", "worker.service": "failed", "run-1.scope": "active"})
+	conn.Properties["api.service"] = map[string]any{"FragmentPath": "/etc/systemd/system/myapp/api.service"}
+	conn.Properties["worker.service"] = map[string]any{"FragmentPath": "/etc/systemd/system/myapp-old/worker.service"}
+	conn.Properties["run-1.scope"] = map[string]any{"FragmentPath": ""}
+
+	units, err := filterFragmentPaths(context.Background(), conn, []string{"api.service", "worker.service", "run-1.scope"}, []string{"/etc/systemd/system/myapp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0] != "api.service" {
+		t.Errorf("unexpected units: %v", units)
+	}
+}
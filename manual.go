@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// checkModeEvent is the event of the modes which do not read it from stdin (hook, run)
+var checkModeEvent *corev2.Event
+
+// syntheticEvent makes a minimal event for runs without a real Sensu event
+func syntheticEvent(namespace, entityName, hostname, checkName string, status uint32) *corev2.Event {
+	entity := &corev2.Entity{
+		ObjectMeta:  corev2.ObjectMeta{Name: entityName, Namespace: namespace},
+		EntityClass: corev2.EntityAgentClass,
+		System:      corev2.System{Hostname: hostname},
+	}
+
+	check := &corev2.Check{
+		ObjectMeta: corev2.ObjectMeta{Name: checkName, Namespace: namespace},
+		Status:     status,
+	}
+
+	return &corev2.Event{
+		ObjectMeta: corev2.ObjectMeta{Namespace: namespace},
+		Timestamp:  time.Now().Unix(),
+		Entity:     entity,
+		Check:      check,
+	}
+}
+
+// readEventFile loads event JSON and applies annotation overrides like the handler does for stdin events
+func readEventFile(path string) (*corev2.Event, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read event file error: %w", err)
+	}
+
+	event, err := parseEvent(buf)
+	if err != nil {
+		return nil, fmt.Errorf("event file: %w", err)
+	}
+
+	return event, nil
+}
+
+// parseEvent decodes and validates event JSON, then applies its annotation overrides to the plugin config
+func parseEvent(buf []byte) (*corev2.Event, error) {
+	event := &corev2.Event{}
+	err := json.Unmarshal(buf, event)
+	if err != nil {
+		return nil, fmt.Errorf("parse event error: %w", err)
+	}
+
+	err = event.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range options {
+		_, err = opt.SetAnnotationValue(plugin.Keyspace, event)
+		if err != nil {
+			return nil, fmt.Errorf("annotation override error: %w", err)
+		}
+	}
+
+	return event, nil
+}
+
+// checkRunArgs prepares the event of the manual run: from --event-file or for --ssh-host alone
+func checkRunArgs(_ *corev2.Event) (int, error) {
+	var err error
+
+	switch {
+	case plugin.EventFile != "":
+		checkModeEvent, err = readEventFile(plugin.EventFile)
+		if err != nil {
+			return sensu.CheckStateUnknown, err
+		}
+
+	case plugin.Tun.SSHHost != "":
+		checkModeEvent = syntheticEvent("default", plugin.Tun.SSHHost, plugin.Tun.SSHHost, "manual", sensu.CheckStateCritical)
+
+	default:
+		return sensu.CheckStateUnknown, fmt.Errorf("--event-file or --ssh-host is required")
+	}
+
+	err = checkArgs(checkModeEvent)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	return sensu.CheckStateOK, nil
+}
+
+func executeCheckMode(_ *corev2.Event) (int, error) {
+	err := executeHandler(checkModeEvent)
+	if err != nil {
+		return exitCode(err), redactError(err)
+	}
+
+	return sensu.CheckStateOK, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestCheckRunArgs(t *testing.T) {
+	handlerConfig(t)
+	plugin.Tun.SSHHost = ""
+	t.Cleanup(func() { checkModeEvent = nil })
+
+	if _, err := checkRunArgs(nil); err == nil || !strings.Contains(err.Error(), "--event-file or --ssh-host is required") {
+		t.Fatalf("expected a target to be required, got %v", err)
+	}
+
+	// replayed event applies its annotation overrides like a piped one
+	event := corev2.FixtureEvent("node1", "check-nginx")
+	event.Check.Status = 2
+	event.Check.Annotations = map[string]string{plugin.Keyspace + "/action": "reload"}
+	buf, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.EventFile = filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(plugin.EventFile, buf, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, err := checkRunArgs(nil); err != nil || code != sensu.CheckStateOK {
+		t.Fatalf("unexpected result %d: %v", code, err)
+	}
+	if checkModeEvent.Check.Name != "check-nginx" || plugin.Action != "reload" {
+		t.Errorf("expected the event file with its overrides, got %s %s", checkModeEvent.Check.Name, plugin.Action)
+	}
+
+	if err := os.WriteFile(plugin.EventFile, []byte(`{"check":{}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRunArgs(nil); err == nil || !strings.HasPrefix(err.Error(), "event file: ") {
+		t.Errorf("expected an invalid event file rejected, got %v", err)
+	}
+
+	plugin.EventFile = ""
+	plugin.Tun.SSHHost = "10.0.0.1"
+	if _, err := checkRunArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	ev := checkModeEvent
+	if ev.Entity.Name != "10.0.0.1" || ev.Check.Name != "manual" || ev.Check.Status != 2 || plugin.skipReason != "" {
+		t.Errorf("expected a failing manual event for the host, got %+v %+v skip %q", ev.Entity.ObjectMeta, ev.Check, plugin.skipReason)
+	}
+
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed"})
+	summary, connected, err := runHandler(t, ev, conn)
+	if err != nil || !slices.Equal(connected, []string{"10.0.0.1"}) || len(summary.Results) != 1 {
+		t.Errorf("expected the manual run to act on the host, got %+v: %v", summary, err)
+	}
+}
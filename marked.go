@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+const (
+	// markedJobsTimeout bounds waiting for the jobs enqueued for marked units
+	markedJobsTimeout = 5 * time.Minute
+	markedJobsPoll    = time.Second
+)
+
+// flushMarked enqueues jobs for all marked units of the host and waits for them to finish
+func flushMarked(ctx context.Context, logger *slog.Logger, stun *service.DBusTunnel, conn service.SystemdConnection, report *hostReport) error {
+	jobs, err := stun.EnqueueMarkedJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", report.Host, err)
+	}
+
+	report.MarkedJobs = len(jobs)
+	logger.Info("Enqueued jobs for marked units", "jobs", len(jobs))
+
+	ctx, cancel := context.WithTimeout(ctx, markedJobsTimeout)
+	defer cancel()
+
+	err = service.WaitJobs(ctx, conn, jobs, markedJobsPoll)
+	if err != nil {
+		return fmt.Errorf("%s: %w", report.Host, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+const metricPrefix = "systemd_handler"
+
+// remediationMetrics makes Sensu metric points describing the run
+func remediationMetrics(results []unitResult, duration time.Duration, now time.Time) *corev2.Metrics {
+	ts := now.Unix()
+
+	failures := 0
+	points := make([]*corev2.MetricPoint, 0, len(results)+3)
+	for _, r := range results {
+		if r.Failed() {
+			failures++
+		}
+
+		points = append(points, &corev2.MetricPoint{
+			Name:      metricPrefix + ".action_duration_seconds",
+			Value:     r.Duration.Seconds(),
+			Timestamp: ts,
+			Tags: []*corev2.MetricTag{
+				{Name: "host", Value: r.Host},
+				{Name: "unit", Value: r.Unit},
+				{Name: "action", Value: r.Action},
+				{Name: "result", Value: r.Result},
+			},
+		})
+	}
+
+	points = append(points,
+		&corev2.MetricPoint{Name: metricPrefix + ".units_acted", Value: float64(len(results)), Timestamp: ts},
+		&corev2.MetricPoint{Name: metricPrefix + ".failures", Value: float64(failures), Timestamp: ts},
+		&corev2.MetricPoint{Name: metricPrefix + ".duration_seconds", Value: duration.Seconds(), Timestamp: ts},
+	)
+
+	return &corev2.Metrics{Points: points}
+}
+
+// writeMetrics prints metric points in graphite plaintext format
+func writeMetrics(w io.Writer, metrics *corev2.Metrics) {
+	for _, p := range metrics.Points {
+		name := p.Name
+		for _, tag := range p.Tags {
+			name += fmt.Sprintf(";%s=%s", tag.Name, tag.Value)
+		}
+		fmt.Fprintf(w, "%s %v %d\n", name, p.Value, p.Timestamp)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// jobActions queue systemd jobs and honour --mode, the other actions ignore it
+var jobActions = []string{"start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "reload-or-try-restart", "revert"}
+
+// modeActions lists the only job types systemd accepts the mode for
+var modeActions = map[string][]string{
+	"isolate":              {"start"},
+	"triggering":           {"stop"},
+	"restart-dependencies": {"start"},
+}
+
+// checkMode rejects mode and action combinations systemd would refuse with a bare "Invalid argument"
+func checkMode(mode string, actions ...string) error {
+	allowed, ok := modeActions[mode]
+	if !ok {
+		return nil
+	}
+
+	for _, action := range actions {
+		if stringsContains(jobActions, action) && !stringsContains(allowed, action) {
+			return fmt.Errorf("--mode %s is only valid with --action %s, systemd refuses it for %s",
+				mode, strings.Join(allowed, " or "), action)
+		}
+	}
+
+	return nil
+}
+
+// checkIsolate fails for units without AllowIsolate=yes, systemd refuses to isolate them
+func checkIsolate(ctx context.Context, conn service.SystemdConnection, units []string) error {
+	for _, unit := range units {
+		allow, err := service.AllowIsolate(ctx, conn, unit)
+		if err != nil {
+			return err
+		}
+		if !allow {
+			return fmt.Errorf("--mode isolate: %s does not set AllowIsolate=yes, only targets meant to be isolated (e.g. rescue.target) can be", unit)
+		}
+	}
+
+	return nil
+}
+
+// unitMode overrides --mode for units matching the pattern, --unit-mode <pattern>=<mode>
+type unitMode struct {
+	spec    string
+	mode    string
+	matcher *service.Matcher
+}
+
+func parseUnitMode(spec string) (unitMode, error) {
+	pattern, mode, ok := strings.Cut(spec, "=")
+	pattern, mode = strings.TrimSpace(pattern), strings.TrimSpace(mode)
+	if !ok || pattern == "" {
+		return unitMode{}, fmt.Errorf("unit mode %q: expected <unit pattern>=<mode>", spec)
+	}
+	if !stringsContains(allowedModes, mode) {
+		return unitMode{}, fmt.Errorf("unit mode %q: mode must be one of %v", spec, allowedModes)
+	}
+
+	matcher, err := service.CompileMatcher([]string{pattern})
+	if err != nil {
+		return unitMode{}, fmt.Errorf("unit mode %q: %w", spec, err)
+	}
+
+	return unitMode{spec: spec, mode: mode, matcher: matcher}, nil
+}
+
+// modeFor returns the job mode for the unit, the first matching --unit-mode wins over --mode
+func modeFor(unit string) string {
+	for _, um := range plugin.unitModes {
+		if um.matcher.Match(unit) {
+			return um.mode
+		}
+	}
+
+	return plugin.Mode
+}
+
+// configuredModes returns --mode and the --unit-mode modes, without duplicates
+func configuredModes() []string {
+	modes := []string{plugin.Mode}
+	for _, um := range plugin.unitModes {
+		if !stringsContains(modes, um.mode) {
+			modes = append(modes, um.mode)
+		}
+	}
+
+	return modes
+}
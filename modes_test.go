@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		actions []string
+		err     string
+	}{
+		{"replace", []string{"restart"}, ""},
+		{"isolate", []string{"start"}, ""},
+		{"isolate", []string{"restart"}, "--mode isolate is only valid with --action start, systemd refuses it for restart"},
+		{"isolate", []string{"start", "reload"}, "refuses it for reload"},
+		{"triggering", []string{"stop"}, ""},
+		{"triggering", []string{"start"}, "--mode triggering is only valid with --action stop"},
+		{"restart-dependencies", []string{"reset-failed", "start"}, ""},
+	} {
+		err := checkMode(tc.mode, tc.actions...)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s %v: expected %q, got %v", tc.mode, tc.actions, tc.err, err)
+		}
+	}
+
+	if err := checkVersion("node1", 249, "249", "triggering"); err == nil {
+		t.Error("triggering mode must require systemd 250")
+	}
+}
+
+func TestUnitMode(t *testing.T) {
+	defer func(mode string, modes []unitMode) { plugin.Mode, plugin.unitModes = mode, modes }(plugin.Mode, plugin.unitModes)
+	plugin.Mode = "replace"
+	plugin.unitModes = nil
+	for _, spec := range []string{"postgresql*.service=fail", "mysql.service = ignore-dependencies", "*sql*=flush"} {
+		um, err := parseUnitMode(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugin.unitModes = append(plugin.unitModes, um)
+	}
+
+	for unit, mode := range map[string]string{
+		"postgresql@14-main.service": "fail",
+		"mysql.service":              "ignore-dependencies",
+		"sqlite-backup.timer":        "flush",
+		"nginx.service":              "replace",
+	} {
+		if got := modeFor(unit); got != mode {
+			t.Errorf("%s: expected %s, got %s", unit, mode, got)
+		}
+	}
+	if modes := configuredModes(); !slices.Equal(modes, []string{"replace", "fail", "ignore-dependencies", "flush"}) {
+		t.Errorf("unexpected configured modes: %v", modes)
+	}
+
+	for _, spec := range []string{"nginx.service", "=fail", "nginx.service=bogus", "[=fail"} {
+		if _, err := parseUnitMode(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"go.uber.org/multierr"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// maxMuxEventSize limits one event line on the mux stdin
+const maxMuxEventSize = 16 << 20
+
+// muxTunnels keeps tunnels open between events of the mux run, nil in the other modes
+var muxTunnels *tunnelPool
+
+// tunnelPool shares one tunnel per SSH target. Tunnels are keyed by the whole config, so events
+// with other credentials, D-Bus socket or run-as user never get a tunnel opened for another one.
+type tunnelPool struct {
+	mu      sync.Mutex
+	sched   *tunnelScheduler
+	tunnels map[service.DBusTunnelConfig]*pooledTunnel
+}
+
+// pooledTunnel is a tunnel of the pool with the number of its current users
+type pooledTunnel struct {
+	stun *service.DBusTunnel
+	refs int
+}
+
+func newTunnelPool(sched *tunnelScheduler) *tunnelPool {
+	return &tunnelPool{sched: sched, tunnels: make(map[service.DBusTunnelConfig]*pooledTunnel)}
+}
+
+// Get returns the open tunnel for the target or connects a new one. The release function must be called when done.
+func (p *tunnelPool) Get(ctx context.Context, cfg service.DBusTunnelConfig) (*service.DBusTunnel, func(), error) {
+	key := cfg
+
+	p.mu.Lock()
+	if pt, ok := p.tunnels[key]; ok {
+		pt.refs++
+		p.mu.Unlock()
+		return pt.stun, p.releaseFunc(key, pt), nil
+	}
+	p.mu.Unlock()
+
+	err := p.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stun, err := service.NewDBusTunnel(ctx, cfg)
+	if err != nil {
+		p.sched.Release()
+		return nil, nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// connected concurrently for the same target
+	if pt, ok := p.tunnels[key]; ok {
+		stun.Close()
+		p.sched.Release()
+		pt.refs++
+		return pt.stun, p.releaseFunc(key, pt), nil
+	}
+
+	pt := &pooledTunnel{stun: stun, refs: 1}
+	p.tunnels[key] = pt
+	return stun, p.releaseFunc(key, pt), nil
+}
+
+// acquire takes a tunnel slot, closing idle tunnels of the pool rather than waiting for them
+func (p *tunnelPool) acquire(ctx context.Context) error {
+	for !p.sched.TryAcquire() {
+		if p.closeIdle() {
+			continue
+		}
+
+		err := p.sched.Acquire(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for tunnel slot: %w", err)
+		}
+		break
+	}
+	return nil
+}
+
+// closeIdle closes one tunnel nobody uses, returning its slot
+func (p *tunnelPool) closeIdle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, pt := range p.tunnels {
+		if pt.refs == 0 {
+			delete(p.tunnels, key)
+			pt.stun.Close()
+			p.sched.Release()
+			return true
+		}
+	}
+	return false
+}
+
+// releaseFunc drops the use of the tunnel, an idle tunnel is closed when others wait for a slot
+func (p *tunnelPool) releaseFunc(key service.DBusTunnelConfig, pt *pooledTunnel) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			pt.refs--
+			if pt.refs == 0 && p.tunnels[key] == pt && p.sched.Waiting() {
+				delete(p.tunnels, key)
+				pt.stun.Close()
+				p.sched.Release()
+			}
+		})
+	}
+}
+
+// Close tears down all tunnels of the pool
+func (p *tunnelPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, pt := range p.tunnels {
+		pt.stun.Close()
+		p.sched.Release()
+		delete(p.tunnels, key)
+	}
+}
+
+// staleTunnelAge is the age after which tunnel dirs without a live tunnel are removed
+const staleTunnelAge = time.Hour
+
+// sweepOnce cleans up stale tunnel dirs before the first tunnel of the process
+var sweepOnce sync.Once
+
+// openTunnel connects the tunnel, or takes it from the mux pool. The release function must be called when done.
+// At most --max-tunnels tunnels are open at once, the others wait for a slot in request order.
+func openTunnel(ctx context.Context, cfg service.DBusTunnelConfig) (*service.DBusTunnel, func(), error) {
+	sweepOnce.Do(func() { service.SweepTunnelDirs(staleTunnelAge, cfg.RunAs) })
+
+	err := chaos.tunnelError()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if muxTunnels != nil {
+		return muxTunnels.Get(ctx, cfg)
+	}
+
+	sched := tunnelSchedule()
+	err = sched.Acquire(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("waiting for tunnel slot: %w", err)
+	}
+
+	stun, err := service.NewDBusTunnel(ctx, cfg)
+	if err != nil {
+		sched.Release()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return stun, func() {
+		once.Do(func() {
+			stun.Close()
+			sched.Release()
+		})
+	}, nil
+}
+
+// muxItem is an event of the mux batch with the plugin config resolved for it
+type muxItem struct {
+	line  int
+	event *corev2.Event
+	cfg   Config
+}
+
+// readMuxEvents reads newline-delimited events and groups them by the target host, keeping the input order within the group
+func readMuxEvents(r io.Reader, base Config) (groups [][]muxItem, err error) {
+	index := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMuxEventSize)
+	for line := 1; scanner.Scan(); line++ {
+		buf := scanner.Bytes()
+		if len(strings.TrimSpace(string(buf))) == 0 {
+			continue
+		}
+
+		plugin = base.clone()
+		event, err2 := parseEvent(buf)
+		if err2 == nil {
+			err2 = checkArgs(event)
+		}
+		var hosts []string
+		if err2 == nil && plugin.skipReason == "" {
+			hosts, err2 = targetHosts(event)
+		}
+		if err2 != nil {
+			err = multierr.Append(err, fmt.Errorf("line %d: %w", line, err2))
+			continue
+		}
+
+		key := strings.Join(hosts, ",")
+		idx, ok := index[key]
+		if !ok {
+			idx = len(groups)
+			index[key] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], muxItem{line: line, event: event, cfg: plugin})
+	}
+
+	if err2 := scanner.Err(); err2 != nil {
+		err = multierr.Append(err, fmt.Errorf("read events error: %w", err2))
+	}
+
+	return groups, err
+}
+
+func checkMuxArgs(_ *corev2.Event) (int, error) {
+	return sensu.CheckStateOK, nil
+}
+
+// executeMux handles the stream of events from stdin, reusing one tunnel per host for all events of the host.
+// Groups run one after another, as events are handled with the global plugin config, so --max-tunnels
+// only bounds the hosts of a single event and the tunnels of a group are closed before the next one.
+func executeMux(_ *corev2.Event) (int, error) {
+	base := plugin
+	defer func() { plugin = base }()
+
+	groups, err := readMuxEvents(os.Stdin, base)
+	code := exitCode(err)
+
+	muxTunnels = newTunnelPool(tunnelSchedule())
+	defer func() { muxTunnels = nil }()
+
+	for _, group := range groups {
+		for _, item := range group {
+			plugin = item.cfg
+			err2 := executeHandler(item.event)
+			if err2 != nil {
+				err = multierr.Append(err, fmt.Errorf("line %d: %w", item.line, err2))
+				code = max(code, exitCode(err2))
+			}
+		}
+
+		muxTunnels.Close()
+	}
+
+	if err != nil {
+		return max(code, exitError), redactError(err)
+	}
+
+	return sensu.CheckStateOK, nil
+}
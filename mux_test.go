@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestReadMuxEvents(t *testing.T) {
+	defaultConfig(t)
+	plugin.Tun.SSHHost = "node1"
+	plugin.UnitPatterns = []string{"a.service", "b.service"}
+	plugin.UnitModes = []string{"a.service=fail", "b.service=fail"}
+	base := plugin
+
+	// array overrides are decoded into the slice option, each event must get its own
+	var in bytes.Buffer
+	for _, tc := range []struct{ unit, mode string }{
+		{`["x.service"]`, `["x.service=ignore-dependencies"]`},
+		{`["y.service"]`, `["y.service=fail"]`},
+	} {
+		event := corev2.FixtureEvent("node1", "check-nginx")
+		event.Check.Status = 2
+		event.Check.Annotations = map[string]string{
+			plugin.Keyspace + "/unit":      tc.unit,
+			plugin.Keyspace + "/unit_mode": tc.mode,
+		}
+		buf, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Write(append(buf, '\n'))
+	}
+
+	groups, err := readMuxEvents(&in, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0]) != 2 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	first, second := groups[0][0].cfg, groups[0][1].cfg
+	if !slices.Equal(first.UnitPatterns, []string{"x.service"}) || first.unitModes[0].mode != "ignore-dependencies" {
+		t.Errorf("first event got the config of another: %v %+v", first.UnitPatterns, first.unitModes)
+	}
+	if !slices.Equal(second.UnitPatterns, []string{"y.service"}) || second.unitModes[0].mode != "fail" {
+		t.Errorf("unexpected second event config: %v %+v", second.UnitPatterns, second.unitModes)
+	}
+	if !slices.Equal(base.UnitPatterns, []string{"a.service", "b.service"}) || !slices.Equal(base.UnitModes, []string{"a.service=fail", "b.service=fail"}) {
+		t.Errorf("base config changed: %v %v", base.UnitPatterns, base.UnitModes)
+	}
+}
+
+func TestTunnelPoolKey(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	cfg := service.DBusTunnelConfig{User: "root", SSHHost: "192.0.2.1", SSHPort: 22, RemoteSocket: "/run/dbus/system_bus_socket", Password: "hunter22"}
+	pooled := &service.DBusTunnel{}
+	pool := newTunnelPool(newTunnelScheduler(4))
+	pool.tunnels[cfg] = &pooledTunnel{stun: pooled, refs: 1}
+
+	stun, release, err := pool.Get(context.Background(), cfg)
+	if err != nil || stun != pooled {
+		t.Fatalf("expected pooled tunnel, got %v %v", stun, err)
+	}
+	release()
+
+	// a cancelled context fails the new connection fast instead of reusing the pooled tunnel
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, change := range map[string]func(*service.DBusTunnelConfig){
+		"password":      func(c *service.DBusTunnelConfig) { c.Password = "hunter23" },
+		"identity file": func(c *service.DBusTunnelConfig) { c.IdentityFile = "/etc/sensu/id_ed25519" },
+		"socket":        func(c *service.DBusTunnelConfig) { c.RemoteSocket = "/run/user/0/bus" },
+	} {
+		other := cfg
+		change(&other)
+		if stun, _, err := pool.Get(ctx, other); err == nil || stun == pooled {
+			t.Errorf("%s: tunnel of other credentials reused", name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// resultInProgress marks units skipped because systemd already runs a job for them
+const resultInProgress = "in-progress"
+
+var (
+	pendingJobsModes = []string{"ignore", "skip", "wait"}
+
+	// pendingJobsPollInterval is how often --pending-jobs wait polls the job queue
+	pendingJobsPollInterval = 2 * time.Second
+)
+
+// checkPendingJobs splits the units into those free to act on and those with a queued or running job,
+// with --pending-jobs wait it first waits up to --pending-jobs-wait for the jobs to finish.
+// cancel-jobs and actions queueing no job are never held back.
+func checkPendingJobs(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection, host string, units []string, unitActions map[string]string) ([]string, []unitResult, error) {
+	checked := make([]string, 0, len(units))
+	for _, unit := range units {
+		if stringsContains(jobActions, unitActions[unit]) {
+			checked = append(checked, unit)
+		}
+	}
+	if len(checked) == 0 {
+		return units, nil, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, plugin.pendingJobsWait)
+	defer cancel()
+
+	ticker := time.NewTicker(pendingJobsPollInterval)
+	defer ticker.Stop()
+
+	for logged := false; ; logged = true {
+		jobs, err := service.UnitJobs(ctx, conn, checked)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(jobs) == 0 {
+			return units, nil, nil
+		}
+		if plugin.PendingJobs != "wait" || waitCtx.Err() != nil {
+			ready, busy := splitBusy(logger, host, units, unitActions, jobs)
+			return ready, busy, nil
+		}
+		if !logged {
+			logger.Info("Waiting for queued jobs of the units", "jobs", len(jobs), "timeout", plugin.pendingJobsWait)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-waitCtx.Done():
+		}
+	}
+}
+
+// splitBusy separates units with jobs, they get the in-progress result
+func splitBusy(logger *slog.Logger, host string, units []string, unitActions map[string]string, jobs map[string]string) ([]string, []unitResult) {
+	ready := make([]string, 0, len(units))
+	var busy []unitResult
+	for _, unit := range units {
+		job, ok := jobs[unit]
+		if !ok {
+			ready = append(ready, unit)
+			continue
+		}
+
+		logger.Info("Skipped: job already in progress", "unit", unit, "job", job)
+		busy = append(busy, unitResult{Host: host, Unit: unit, Action: unitActions[unit], Mode: modeFor(unit), Result: resultInProgress})
+	}
+
+	return ready, busy
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestCheckPendingJobs(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "activating", "mysql.service": "failed", "nfs.mount": "deactivating"})
+	conn.Jobs = []dbus.JobStatus{
+		{Id: 10, Unit: "nginx.service", JobType: "start", Status: "running"},
+		{Id: 11, Unit: "nfs.mount", JobType: "stop", Status: "running"},
+	}
+	units := []string{"nginx.service", "mysql.service", "nfs.mount"}
+	actions := map[string]string{"nginx.service": "restart", "mysql.service": "restart", "nfs.mount": "cancel-jobs"}
+
+	defer func(mode string, wait, interval time.Duration) {
+		plugin.PendingJobs, plugin.pendingJobsWait, pendingJobsPollInterval = mode, wait, interval
+	}(plugin.PendingJobs, plugin.pendingJobsWait, pendingJobsPollInterval)
+	plugin.PendingJobs = "skip"
+
+	ready, busy, err := checkPendingJobs(ctx, logger, conn, "node1", units, actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ready, []string{"mysql.service", "nfs.mount"}) {
+		t.Errorf("unexpected ready units: %v", ready)
+	}
+	if len(busy) != 1 || busy[0].Unit != "nginx.service" || busy[0].Result != resultInProgress || busy[0].Failed() {
+		t.Errorf("unexpected busy units: %+v", busy)
+	}
+
+	plugin.PendingJobs = "wait"
+	plugin.pendingJobsWait = time.Second
+	pendingJobsPollInterval = 10 * time.Millisecond
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.CancelJob(ctx, 10) //nolint:errcheck
+	}()
+
+	ready, busy, err = checkPendingJobs(ctx, logger, conn, "node1", units, actions)
+	if err != nil || len(ready) != 3 || len(busy) != 0 {
+		t.Errorf("expected all units ready after the job finished: %v, %v, %v", ready, busy, err)
+	}
+}
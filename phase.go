@@ -0,0 +1,36 @@
+package main
+
+import (
+	"time"
+)
+
+var allowedFirstActions = []string{"reload", "try-restart", "reload-or-try-restart"}
+
+// phaseActions selects the action for each unit in two-phase mode.
+// First event for a unit gets the gentle first action and is recorded,
+// recurrence within the window escalates to the configured action.
+func phaseActions(st *handlerState, host string, units []string, firstAction, action string, window time.Duration, now time.Time) map[string]string {
+	if st.Escalations == nil {
+		st.Escalations = make(map[string]time.Time)
+	}
+
+	for key, ts := range st.Escalations {
+		if now.Sub(ts) > window {
+			delete(st.Escalations, key)
+		}
+	}
+
+	actions := make(map[string]string, len(units))
+	for _, unit := range units {
+		key := stateKey(host, unit)
+		if _, ok := st.Escalations[key]; ok {
+			actions[unit] = action
+			delete(st.Escalations, key)
+		} else {
+			actions[unit] = firstAction
+			st.Escalations[key] = now
+		}
+	}
+
+	return actions
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseActions(t *testing.T) {
+	st := &handlerState{}
+	now := time.Now()
+
+	actions := phaseActions(st, "host", []string{"a.service"}, "reload", "restart", time.Hour, now)
+	if actions["a.service"] != "reload" {
+		t.Errorf("first event: expected reload, got %s", actions["a.service"])
+	}
+
+	actions = phaseActions(st, "host", []string{"a.service"}, "reload", "restart", time.Hour, now.Add(time.Minute))
+	if actions["a.service"] != "restart" {
+		t.Errorf("recurrence: expected restart, got %s", actions["a.service"])
+	}
+
+	phaseActions(st, "host", []string{"a.service"}, "reload", "restart", time.Hour, now)
+	actions = phaseActions(st, "host", []string{"a.service"}, "reload", "restart", time.Hour, now.Add(2*time.Hour))
+	if actions["a.service"] != "reload" {
+		t.Errorf("expired window: expected reload, got %s", actions["a.service"])
+	}
+}
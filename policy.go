@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev2 "github.com/sensu/core/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// policyRule permits actions and modes for matching checks, subscriptions and units.
+// Empty selector list matches anything.
+type policyRule struct {
+	Checks        []string `yaml:"checks"`
+	Subscriptions []string `yaml:"subscriptions"`
+	Units         []string `yaml:"units"`
+	Actions       []string `yaml:"actions"`
+	Modes         []string `yaml:"modes"`
+
+	// units is Units compiled by parsePolicy, rules are evaluated for every acted unit
+	units *service.Matcher
+}
+
+// policy is a list of allow rules, anything not allowed is refused
+type policy struct {
+	Rules []policyRule `yaml:"rules"`
+}
+
+func loadPolicy(path string) (*policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file error: %w", err)
+	}
+
+	return parsePolicy(buf)
+}
+
+// parsePolicy parses YAML (or JSON) policy and validates its rules
+func parsePolicy(buf []byte) (*policy, error) {
+	p := &policy{}
+	err := yaml.Unmarshal(buf, p)
+	if err != nil {
+		return nil, fmt.Errorf("parse policy error: %w", err)
+	}
+
+	for idx := range p.Rules {
+		rule := &p.Rules[idx]
+		if len(rule.Actions) == 0 {
+			return nil, fmt.Errorf("policy rule %d: actions list is required", idx)
+		}
+		patterns := make([]string, 0, len(rule.Checks)+len(rule.Units)+len(rule.Subscriptions))
+		patterns = append(patterns, rule.Checks...)
+		patterns = append(patterns, rule.Units...)
+		patterns = append(patterns, rule.Subscriptions...)
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("policy rule %d: bad pattern %q: %w", idx, pattern, err)
+			}
+		}
+		rule.units, _ = service.CompileMatcher(rule.Units)
+	}
+
+	return p, nil
+}
+
+// Allowed checks that action with mode on unit is permitted for the event
+func (p *policy) Allowed(event *corev2.Event, unit, action, mode string) error {
+	if p == nil {
+		return nil
+	}
+
+	var checkName string
+	var subscriptions []string
+	if event != nil && event.Check != nil {
+		checkName = event.Check.Name
+	}
+	if event != nil && event.Entity != nil {
+		subscriptions = event.Entity.Subscriptions
+	}
+
+	for _, rule := range p.Rules {
+		if !globAny(rule.Checks, checkName) || (len(rule.Units) > 0 && !rule.units.Match(unit)) {
+			continue
+		}
+		if len(rule.Subscriptions) > 0 && !globAnyOf(rule.Subscriptions, subscriptions) {
+			continue
+		}
+		if !stringsContains(rule.Actions, action) {
+			continue
+		}
+		if len(rule.Modes) > 0 && !stringsContains(rule.Modes, mode) {
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("policy does not allow %s action (mode: %s) on %s for check %q", action, mode, unit, checkName)
+}
+
+// globAny reports whether s matches any of patterns, empty patterns match anything
+func globAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globAnyOf reports whether any of values matches any of patterns
+func globAnyOf(patterns []string, values []string) bool {
+	for _, v := range values {
+		if globAny(patterns, v) {
+			return true
+		}
+	}
+
+	return false
+}
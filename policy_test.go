@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no actions":  `rules: [{units: ["nginx.service"]}]`,
+		"bad pattern": `rules: [{checks: ["check-["], actions: ["restart"]}]`,
+		"bad yaml":    `rules: {`,
+	} {
+		if _, err := parsePolicy([]byte(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	p, err := parsePolicy([]byte(`{"rules": [{"units": ["nginx.service"], "actions": ["restart"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 1 || p.Rules[0].units == nil {
+		t.Errorf("expected one compiled rule, got %+v", p.Rules)
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := parsePolicy([]byte(`
+rules:
+  - checks: ["check-nginx*"]
+    subscriptions: ["web"]
+    units: ["nginx.service"]
+    actions: ["reload"]
+  - checks: ["check-nginx*"]
+    units: ["nginx.service"]
+    actions: ["restart"]
+    modes: ["replace"]
+  - units: ["php-fpm*.service"]
+    actions: ["restart"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := corev2.FixtureEvent("web01", "check-nginx-http")
+	web.Entity.Subscriptions = []string{"linux", "web"}
+	db := corev2.FixtureEvent("db01", "check-nginx-http")
+	db.Entity.Subscriptions = []string{"linux"}
+	other := corev2.FixtureEvent("web01", "check-disk")
+
+	tests := []struct {
+		name   string
+		event  *corev2.Event
+		unit   string
+		action string
+		mode   string
+		ok     bool
+	}{
+		{"subscription matches", web, "nginx.service", "reload", "replace", true},
+		{"subscription gate", db, "nginx.service", "reload", "replace", false},
+		{"later rule allows", db, "nginx.service", "restart", "replace", true},
+		{"mode not listed", db, "nginx.service", "restart", "isolate", false},
+		{"empty modes allow any", web, "php-fpm8.service", "restart", "isolate", true},
+		{"empty checks allow any", other, "php-fpm.service", "restart", "replace", true},
+		{"check not matched", other, "nginx.service", "restart", "replace", false},
+		{"unit not matched", web, "mysql.service", "restart", "replace", false},
+		{"action not listed", web, "php-fpm.service", "stop", "replace", false},
+	}
+	for _, tc := range tests {
+		err := p.Allowed(tc.event, tc.unit, tc.action, tc.mode)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected allowed=%v, got %v", tc.name, tc.ok, err)
+		}
+	}
+
+	err = p.Allowed(db, "nginx.service", "stop", "replace")
+	if want := `policy does not allow stop action (mode: replace) on nginx.service for check "check-nginx-http"`; err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+
+	var none *policy
+	if err := none.Allowed(db, "nginx.service", "stop", "isolate"); err != nil {
+		t.Errorf("no policy must allow anything, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func checkProbeArgs(_ *corev2.Event) (int, error) {
+	err := setupLogger(plugin.LogLevel, plugin.LogFormat, plugin.LogSyslog)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	if plugin.Tun.SSHHost == "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("--ssh-host is required")
+	}
+
+	service.CallTimeout, err = parseDuration("dbus-call-timeout", plugin.DBusCallTimeout)
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	return sensu.CheckStateOK, nil
+}
+
+// executeProbe only connects to the host and reports what the remote systemd offers
+func executeProbe(_ *corev2.Event) (int, error) {
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+
+	err := loadVaultCredentials(ctx, slog.Default())
+	if err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	stun, release, err := openTunnel(ctx, plugin.Tun)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("%s: SSH Tunnel error: %w", plugin.Tun.SSHHost, err)
+	}
+	defer release()
+
+	res, err := stun.Probe()
+	if err != nil {
+		if err2 := stun.DiagnoseSystemd(ctx); err2 != nil {
+			err = err2
+		}
+		return sensu.CheckStateCritical, fmt.Errorf("%s: D-BUS error: %w", plugin.Tun.SSHHost, err)
+	}
+
+	switch plugin.OutputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(res)
+		if err != nil {
+			return sensu.CheckStateUnknown, err
+		}
+
+	default:
+		fmt.Printf("%s: OK\n", plugin.Tun.SSHHost)
+		fmt.Printf("systemd version: %s\n", res.Version)
+		if res.Virtualization != "" {
+			fmt.Printf("virtualization: %s\n", res.Virtualization)
+		}
+		fmt.Printf("list methods: %s\n", strings.Join(res.ListMethods, ", "))
+	}
+
+	return sensu.CheckStateOK, nil
+}
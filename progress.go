@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// actionProgress counts finished unit actions of a host for periodic progress lines
+type actionProgress struct {
+	total  int
+	start  time.Time
+	done   atomic.Int64
+	failed atomic.Int64
+}
+
+func newActionProgress(total int) *actionProgress {
+	return &actionProgress{total: total, start: time.Now()}
+}
+
+// finish records a finished unit action
+func (p *actionProgress) finish(failed bool) {
+	p.done.Add(1)
+	if failed {
+		p.failed.Add(1)
+	}
+}
+
+// eta extrapolates the time left from the average action time so far, zero until an action finishes
+func (p *actionProgress) eta(now time.Time) time.Duration {
+	done := p.done.Load()
+	if done == 0 {
+		return 0
+	}
+
+	left := int64(p.total) - done
+	return (now.Sub(p.start) / time.Duration(done) * time.Duration(left)).Round(time.Second)
+}
+
+// report logs progress every interval until the context is done
+func (p *actionProgress) report(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			logger.Info("Action progress", "done", p.done.Load(), "total", p.total, "failed", p.failed.Load(), "eta", p.eta(now))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
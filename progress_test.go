@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionProgress(t *testing.T) {
+	p := newActionProgress(180)
+	if eta := p.eta(time.Now()); eta != 0 {
+		t.Errorf("expected no ETA before the first action finished, got %s", eta)
+	}
+
+	p.start = time.Now().Add(-42 * time.Second)
+	for i := 0; i < 42; i++ {
+		p.finish(i < 3)
+	}
+	if eta := p.eta(p.start.Add(42 * time.Second)); eta != 138*time.Second {
+		t.Errorf("expected ETA 2m18s, got %s", eta)
+	}
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p.report(ctx, slog.New(slog.NewTextHandler(&buf, nil)), 10*time.Millisecond)
+	if !strings.Contains(buf.String(), "done=42 total=180 failed=3") {
+		t.Errorf("unexpected progress log: %s", buf.String())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+)
+
+const rateLimitWindow = time.Hour
+
+// reserveActions records up to limit actions for key within the rate limit window.
+// Returns units which may proceed and units which were rate limited.
+func reserveActions(st *handlerState, key string, units []string, limit int, now time.Time) (allowed, limited []string) {
+	if st.Actions == nil {
+		st.Actions = make(map[string][]time.Time)
+	}
+
+	for k, times := range st.Actions {
+		recent := times[:0]
+		for _, ts := range times {
+			if now.Sub(ts) < rateLimitWindow {
+				recent = append(recent, ts)
+			}
+		}
+		if len(recent) == 0 {
+			delete(st.Actions, k)
+		} else {
+			st.Actions[k] = recent
+		}
+	}
+
+	free := limit - len(st.Actions[key])
+	if free < 0 {
+		free = 0
+	}
+	if free > len(units) {
+		free = len(units)
+	}
+
+	for range units[:free] {
+		st.Actions[key] = append(st.Actions[key], now)
+	}
+
+	return units[:free], units[free:]
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveActions(t *testing.T) {
+	st := &handlerState{}
+	now := time.Now()
+
+	allowed, limited := reserveActions(st, "host", []string{"a", "b", "c"}, 2, now)
+	if len(allowed) != 2 || len(limited) != 1 {
+		t.Errorf("expected 2 allowed and 1 limited, got %v and %v", allowed, limited)
+	}
+
+	allowed, _ = reserveActions(st, "host", []string{"d"}, 2, now.Add(time.Minute))
+	if len(allowed) != 0 {
+		t.Errorf("expected no allowed actions, got %v", allowed)
+	}
+
+	allowed, _ = reserveActions(st, "host", []string{"d"}, 2, now.Add(2*time.Hour))
+	if len(allowed) != 1 {
+		t.Errorf("expected action allowed after window, got %v", allowed)
+	}
+}
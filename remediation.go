@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// remediationActionsAnnotation is the sensu-remediation-handler configuration annotation
+const remediationActionsAnnotation = "io.sensu.remediation.config.actions"
+
+// remediationAction is a sensu-remediation-handler action definition. Request names the Sensu check
+// that handler would schedule, so here the action only gates remediation of --unit; Subscriptions
+// limit it to entities with any of them, as upstream only schedules the request on those agents.
+type remediationAction struct {
+	Request       string   `json:"request"`
+	Occurrences   []int64  `json:"occurrences"`
+	Severities    []uint32 `json:"severities"`
+	Subscriptions []string `json:"subscriptions"`
+}
+
+// remediationMatches returns the sensu-remediation-handler actions matching the event occurrences,
+// severity and entity subscriptions. Configured is false when the event has no such annotation.
+func remediationMatches(event *corev2.Event) (matched []remediationAction, configured bool, err error) {
+	if event == nil || event.Check == nil {
+		return nil, false, nil
+	}
+
+	value, ok := event.Check.Annotations[remediationActionsAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var actions []remediationAction
+	err = json.Unmarshal([]byte(value), &actions)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s annotation parse error: %w", remediationActionsAnnotation, err)
+	}
+
+	var subscriptions []string
+	if event.Entity != nil {
+		subscriptions = event.Entity.Subscriptions
+	}
+	for _, action := range actions {
+		if !containsInt(action.Occurrences, event.Check.Occurrences) {
+			continue
+		}
+		if !containsUint(action.Severities, event.Check.Status) {
+			continue
+		}
+		if len(action.Subscriptions) > 0 && !slices.ContainsFunc(action.Subscriptions, func(s string) bool {
+			return stringsContains(subscriptions, s)
+		}) {
+			continue
+		}
+
+		matched = append(matched, action)
+	}
+
+	return matched, true, nil
+}
+
+func containsInt(sl []int64, v int64) bool {
+	for _, s := range sl {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsUint(sl []uint32, v uint32) bool {
+	for _, s := range sl {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestRemediationMatches(t *testing.T) {
+	// annotation from the sensu-remediation-handler README
+	upstream := `[
+  {
+    "description": "Perform this action once after Nginx has been down for 30 seconds.",
+    "request": "systemd-start-nginx",
+    "occurrences": [ 3 ],
+    "severities": [ 2 ],
+    "subscriptions": [ "entity:i-424242" ]
+  },
+  {
+    "description": "Perform this action once after Nginx has been down for 10 minutes.",
+    "request": "systemd-restart-nginx",
+    "occurrences": [ 60 ],
+    "severities": [ 2 ],
+    "subscriptions": [ "entity:i-424242" ]
+  }
+]`
+	event := corev2.FixtureEvent("i-424242", "check-nginx")
+	event.Entity.Subscriptions = []string{"linux", "entity:i-424242"}
+	event.Check.Annotations = map[string]string{remediationActionsAnnotation: upstream}
+	event.Check.Status, event.Check.Occurrences = 2, 60
+
+	matched, configured, err := remediationMatches(event)
+	if err != nil || !configured {
+		t.Fatalf("expected configured, got %v %v", configured, err)
+	}
+	if len(matched) != 1 || matched[0].Request != "systemd-restart-nginx" {
+		t.Errorf("expected 10 minutes action, got %+v", matched)
+	}
+
+	event.Check.Occurrences = 4
+	if matched, _, _ := remediationMatches(event); len(matched) != 0 {
+		t.Errorf("occurrences: expected no match, got %+v", matched)
+	}
+
+	event.Check.Occurrences = 3
+	event.Entity.Subscriptions = []string{"linux", "entity:i-434343"}
+	if matched, _, _ := remediationMatches(event); len(matched) != 0 {
+		t.Errorf("subscriptions: expected no match, got %+v", matched)
+	}
+
+	defaultConfig(t)
+	if err := checkArgs(event); err == nil || !strings.Contains(err.Error(), "--unit is required") {
+		t.Errorf("expected request not to be used as unit, got %v", err)
+	}
+
+	plugin.UnitPatterns = []string{"nginx.service"}
+	if err := checkArgs(event); err != nil || plugin.skipKind != "no_match" {
+		t.Errorf("expected no_match skip, got %q %v", plugin.skipKind, err)
+	}
+}
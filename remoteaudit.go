@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// remoteAuditLines describes who acted on what and why, one line per unit result
+func remoteAuditLines(event *corev2.Event, results []unitResult) []string {
+	why := "manual run"
+	if event != nil && event.Check != nil {
+		why = fmt.Sprintf("check %s/%s status %d", entityName(event), event.Check.Name, event.Check.Status)
+	}
+
+	if id := correlationID(event); id != "" {
+		why += " correlation " + id
+	}
+
+	who := plugin.Name
+	if hostname, err := os.Hostname(); err == nil {
+		who += " on " + hostname
+	}
+
+	lines := make([]string, 0, len(results))
+	for _, r := range results {
+		line := fmt.Sprintf("%s %s: %s, by %s, %s", r.Action, r.Unit, r.Result, who, why)
+		if r.Error != "" {
+			line += ", error: " + r.Error
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// remoteAuditCommand pipes the lines to logger(1), which writes each of them to the host journal/syslog
+func remoteAuditCommand(tag string, lines []string) string {
+	quoted := make([]string, 0, len(lines))
+	for _, l := range lines {
+		quoted = append(quoted, service.ShellQuote(l))
+	}
+
+	return fmt.Sprintf("printf '%%s\\n' %s | logger -t %s -p daemon.notice", strings.Join(quoted, " "), service.ShellQuote(tag))
+}
+
+// remoteAudit records the results on the target host itself
+func remoteAudit(ctx context.Context, stun *service.DBusTunnel, event *corev2.Event, results []unitResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	_, err := stun.RunCommand(ctx, remoteAuditCommand(plugin.RemoteAuditTag, remoteAuditLines(event, results)))
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestRemoteAuditCommand(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	stub := "#!/bin/sh\necho \"$@\" > " + out + "\ncat >> " + out + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "logger"), []byte(stub), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	lines := remoteAuditLines(event, []unitResult{
+		{Unit: "nginx.service", Action: "restart", Result: "done"},
+		{Unit: "it's.service", Action: "restart", Result: "failed", Error: "job failed"},
+	})
+
+	cmd := exec.Command("sh", "-c", remoteAuditCommand("sensu-remediation", lines))
+	if buf, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, buf)
+	}
+
+	buf, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if len(got) != 3 || got[0] != "-t sensu-remediation -p daemon.notice" {
+		t.Fatalf("unexpected logger invocation: %q", got)
+	}
+	if got[1] != lines[0] || got[2] != lines[1] {
+		t.Errorf("unexpected messages: %q", got[1:])
+	}
+	if !strings.Contains(got[2], "check entity1/check1") || !strings.Contains(got[2], "error: job failed") {
+		t.Errorf("message does not say why: %s", got[2])
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeReportFile atomically writes the run summary as JSON to path,
+// when path is a directory the file is named after the event id
+func writeReportFile(path string, s runSummary) error {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, s.EventID+".json")
+	}
+
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create report file error: %w", err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+
+	_, err = f.Write(append(buf, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("write report file error: %w", err)
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"go.uber.org/multierr"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// unitResult is the outcome of the action on a single unit
+type unitResult struct {
+	Host     string        `json:"host"`
+	Unit     string        `json:"unit"`
+	Action   string        `json:"action"`
+	Mode     string        `json:"mode,omitempty"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	State    string        `json:"state,omitempty"`
+	Verify   string        `json:"verify_error,omitempty"`
+	Journal  []string      `json:"journal,omitempty"`
+
+	StartLimitReset bool     `json:"start_limit_reset,omitempty"`
+	Affected        []string `json:"affected,omitempty"`
+
+	Before service.UnitSnapshot `json:"before,omitempty"`
+	After  service.UnitSnapshot `json:"after,omitempty"`
+}
+
+// Failed reports whether the action did not complete successfully, a job already in progress is not a failure
+func (r unitResult) Failed() bool {
+	return r.Error != "" || r.Result != "done" && r.Result != resultInProgress
+}
+
+// unitError returns the result error or nil
+func unitError(r unitResult) error {
+	if !r.Failed() {
+		return nil
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+
+	return fmt.Errorf("job result: %s", r.Result)
+}
+
+// ActionError attributes a failed unit action to its host and unit
+type ActionError struct {
+	Host   string
+	Unit   string
+	Action string
+	Result string
+	Err    error
+}
+
+func (e *ActionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s %s: %v", e.Host, e.Action, e.Unit, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s %s: job result: %s", e.Host, e.Action, e.Unit, e.Result)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// HookError is a failed remote hook, reported apart from the unit actions
+type HookError struct {
+	Host string
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s: %s hook failed: %v", e.Host, e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hookCause returns the reason of a failed hook for the report, the host and hook name are reported apart
+func hookCause(err error) string {
+	var herr *HookError
+	if errors.As(err, &herr) && herr.Err != nil {
+		return herr.Err.Error()
+	}
+	return err.Error()
+}
+
+// countUnverified returns number of successful actions which failed verification
+func countUnverified(results []unitResult) int {
+	n := 0
+	for _, r := range results {
+		if !r.Failed() && r.Verify != "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// outcomeAnnotations makes remediation annotations describing the results
+func outcomeAnnotations(results []unitResult, correlation string, now time.Time) map[string]string {
+	actions := make(map[string][]string)
+	outcome := "success"
+	for _, r := range results {
+		actions[r.Action] = append(actions[r.Action], r.Unit)
+		if r.Failed() {
+			outcome = "failure"
+		}
+	}
+
+	lastAction := make([]string, 0, len(actions))
+	for action, units := range actions {
+		lastAction = append(lastAction, fmt.Sprintf("%s %s", action, strings.Join(units, ",")))
+	}
+	sort.Strings(lastAction)
+
+	return map[string]string{
+		"remediation/last-action":    strings.Join(lastAction, "; "),
+		"remediation/result":         outcome,
+		"remediation/timestamp":      now.UTC().Format(time.RFC3339),
+		"remediation/correlation-id": correlation,
+	}
+}
+
+// MarshalJSON encodes duration in seconds
+func (r unitResult) MarshalJSON() ([]byte, error) {
+	type alias unitResult
+	return json.Marshal(struct {
+		alias
+		Duration float64 `json:"duration_seconds"`
+	}{alias(r), r.Duration.Seconds()})
+}
+
+// phaseTimings is time spent in the phases of the host run
+type phaseTimings struct {
+	Tunnel time.Duration
+	DBus   time.Duration
+	List   time.Duration
+	Action time.Duration
+}
+
+// MarshalJSON encodes durations in seconds
+func (p phaseTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]float64{
+		"tunnel_seconds": p.Tunnel.Seconds(),
+		"dbus_seconds":   p.DBus.Seconds(),
+		"list_seconds":   p.List.Seconds(),
+		"action_seconds": p.Action.Seconds(),
+	})
+}
+
+// phaseDuration returns time since start and resets start to now
+func phaseDuration(start *time.Time) time.Duration {
+	now := time.Now()
+	d := now.Sub(*start)
+	*start = now
+	return d
+}
+
+// hostReport is the outcome of the run on a single host
+type hostReport struct {
+	Host    string       `json:"host"`
+	Phases  phaseTimings `json:"phases"`
+	Units   []string     `json:"units"`
+	Results []unitResult `json:"-"`
+
+	SystemdVersion   string `json:"systemd_version,omitempty"`
+	Virtualization   string `json:"virtualization,omitempty"`
+	SystemState      string `json:"system_state,omitempty"`
+	PostHookError    string `json:"post_hook_error,omitempty"`
+	UndrainError     string `json:"undrain_error,omitempty"`
+	HealthError      string `json:"health_error,omitempty"`
+	CheckVerifyError string `json:"check_verify_error,omitempty"`
+	MarkedJobs       int    `json:"marked_jobs,omitempty"`
+
+	// Hostname is the name the remote host reports, Host is the SSH target
+	Hostname string `json:"hostname,omitempty"`
+	// Tunnel is ssh for an own tunnel, ssh-shared for a live tunnel of another handler
+	Tunnel string `json:"tunnel,omitempty"`
+
+	// ListMethod is the list method used by --match and ListCall its Manager method,
+	// ListSelection tells whether it was introspected or configured
+	ListMethod        string  `json:"list_method,omitempty"`
+	ListCall          string  `json:"list_call,omitempty"`
+	ListSelection     string  `json:"list_selection,omitempty"`
+	IntrospectSeconds float64 `json:"introspect_seconds,omitempty"`
+	ListCallSeconds   float64 `json:"list_call_seconds,omitempty"`
+
+	// Transport is the D-Bus traffic over the tunnel, to tell slow links from slow jobs
+	Transport *service.TransportStats `json:"transport,omitempty"`
+
+	systemdMajor int
+}
+
+// facts describes the remote host for the text summary
+func (h *hostReport) facts() string {
+	var facts []string
+	if h.Hostname != "" && h.Hostname != h.Host {
+		facts = append(facts, "hostname "+h.Hostname)
+	}
+	if h.SystemdVersion != "" {
+		facts = append(facts, "systemd "+h.SystemdVersion)
+	}
+	if h.Virtualization != "" {
+		facts = append(facts, "virtualization "+h.Virtualization)
+	}
+	if h.SystemState != "" {
+		facts = append(facts, "state "+h.SystemState)
+	}
+	if h.ListCall != "" {
+		facts = append(facts, fmt.Sprintf("%s (%s) %s", h.ListCall, h.ListSelection, seconds(h.ListCallSeconds)))
+	}
+	if h.Tunnel != "" {
+		facts = append(facts, "tunnel "+h.Tunnel)
+	}
+
+	return strings.Join(facts, ", ")
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+// runSummary is a machine-readable description of the handler run
+type runSummary struct {
+	EventID string `json:"event_id,omitempty"`
+	// CorrelationID is the same in logs, audit records and follow-up events of the run
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	Entity        string        `json:"entity,omitempty"`
+	Check         string        `json:"check,omitempty"`
+	Skipped       string        `json:"skipped,omitempty"`
+	Outcome       string        `json:"outcome"`
+	Action        string        `json:"action"`
+	Mode          string        `json:"mode"`
+	Hosts         []*hostReport `json:"hosts,omitempty"`
+	Results       []unitResult  `json:"results"`
+	Duration      float64       `json:"duration_seconds"`
+	Errors        []string      `json:"errors,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+}
+
+func newRunSummary(event *corev2.Event, skipped string, results []unitResult, duration time.Duration, err error) runSummary {
+	s := runSummary{
+		EventID:       eventID(event),
+		CorrelationID: correlationID(event),
+		Entity:        entityName(event),
+		Skipped:       skipped,
+		Action:        plugin.Action,
+		Mode:          plugin.Mode,
+		Results:       results,
+		Duration:      duration.Seconds(),
+	}
+	if s.Results == nil {
+		s.Results = []unitResult{}
+	}
+	if event != nil && event.Check != nil {
+		s.Check = event.Check.Name
+	}
+	for _, e := range multierr.Errors(err) {
+		s.Errors = append(s.Errors, e.Error())
+	}
+
+	return s
+}
+
+// writeSummary prints the summary in the requested output format
+func writeSummary(w io.Writer, format string, s runSummary) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		return enc.Encode(s)
+
+	default:
+		writeTable(w, s)
+		if s.Skipped == "" {
+			fmt.Fprintf(w, "Outcome: %s\n", s.Outcome)
+		}
+
+		for _, h := range s.Hosts {
+			fmt.Fprintf(w, "%s: tunnel %s, dbus %s, list %s, action %s\n", h.Host,
+				h.Phases.Tunnel.Round(time.Millisecond), h.Phases.DBus.Round(time.Millisecond),
+				h.Phases.List.Round(time.Millisecond), h.Phases.Action.Round(time.Millisecond))
+			if facts := h.facts(); facts != "" {
+				fmt.Fprintf(w, "%s: %s\n", h.Host, facts)
+			}
+			if h.PostHookError != "" {
+				fmt.Fprintf(w, "%s: post-hook failed: %s\n", h.Host, h.PostHookError)
+			}
+			if h.CheckVerifyError != "" {
+				fmt.Fprintf(w, "%s: recovery not confirmed: %s\n", h.Host, h.CheckVerifyError)
+			}
+			if h.HealthError != "" {
+				fmt.Fprintf(w, "%s: health gate failed: %s\n", h.Host, h.HealthError)
+			}
+			if h.UndrainError != "" {
+				fmt.Fprintf(w, "%s: undrain failed: %s\n", h.Host, h.UndrainError)
+			}
+		}
+
+		for _, r := range s.Results {
+			if r.Before == nil || r.After == nil {
+				continue
+			}
+
+			diff := r.Before.Diff(r.After)
+			names := make([]string, 0, len(diff))
+			for name := range diff {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Fprintf(w, "-- %s: %s changes --\n", r.Host, r.Unit)
+			for _, name := range names {
+				fmt.Fprintf(w, "%s: %s\n", name, diff[name])
+			}
+		}
+
+		for _, r := range s.Results {
+			if len(r.Affected) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(w, "-- %s: %s affected units --\n", r.Host, r.Unit)
+			for _, line := range r.Affected {
+				fmt.Fprintln(w, line)
+			}
+		}
+
+		for _, r := range s.Results {
+			if len(r.Journal) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(w, "-- %s: %s journal --\n", r.Host, r.Unit)
+			for _, line := range r.Journal {
+				fmt.Fprintln(w, line)
+			}
+		}
+		return nil
+	}
+}
+
+// writeRunOutput prints the summary followed, in text format, by the metrics, all through the redacting writer
+func writeRunOutput(w io.Writer, format string, s runSummary, metrics *corev2.Metrics) error {
+	out := service.NewRedactingWriter(w)
+	err := writeSummary(out, format, s)
+	if err != nil {
+		return err
+	}
+	if metrics != nil && format != "json" {
+		writeMetrics(out, metrics)
+	}
+
+	return out.Flush()
+}
+
+// writeTable prints aligned table of unit results
+func writeTable(w io.Writer, s runSummary) {
+	if len(s.Results) == 0 {
+		return
+	}
+
+	multiHost := len(s.Hosts) > 1
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if multiHost {
+		fmt.Fprint(tw, "HOST\t")
+	}
+	fmt.Fprintln(tw, "UNIT\tACTION\tJOB RESULT\tFINAL STATE\tDURATION")
+
+	for _, r := range s.Results {
+		result := r.Result
+		if r.Error != "" {
+			result = "error: " + r.Error
+		}
+		if multiHost {
+			fmt.Fprintf(tw, "%s\t", r.Host)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Unit, r.Action, result, r.State, r.Duration.Round(time.Millisecond))
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"go.uber.org/multierr"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestHookCause(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&HookError{Host: "node1", Hook: "post", Err: errors.New("exit status 3")}, "exit status 3"},
+		{fmt.Errorf("wrapped: %w", &HookError{Host: "node1", Hook: "undrain", Err: errors.New("409 Conflict")}), "409 Conflict"},
+		// no cause to report, must not panic
+		{&HookError{Host: "node1", Hook: "post"}, "node1: post hook failed: <nil>"},
+		{errors.New("context canceled"), "context canceled"},
+	} {
+		if got := hookCause(tc.err); got != tc.want {
+			t.Errorf("hookCause(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestJSONSummary(t *testing.T) {
+	defaultConfig(t)
+	event := corev2.FixtureEvent("node1", "check-nginx")
+	runErr := multierr.Combine(errors.New("node1: SSH Tunnel error: timeout"), errors.New("node2: D-BUS error: EOF"))
+
+	var buf bytes.Buffer
+	if err := writeSummary(&buf, "json", newRunSummary(event, "", nil, 1500*time.Millisecond, runErr)); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); len(lines) != 1 {
+		t.Errorf("expected a single JSON line for log shippers, got %d", len(lines))
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["entity"] != "node1" || got["check"] != "check-nginx" || got["action"] != "restart" || got["duration_seconds"] != 1.5 {
+		t.Errorf("unexpected summary: %v", got)
+	}
+	if results, ok := got["results"].([]any); !ok || len(results) != 0 {
+		t.Errorf("expected an empty results list, got %v", got["results"])
+	}
+	if errs, _ := got["errors"].([]any); len(errs) != 2 || errs[1] != "node2: D-BUS error: EOF" {
+		t.Errorf("expected an entry per error, got %v", got["errors"])
+	}
+}
+
+func TestPhaseTimings(t *testing.T) {
+	start := time.Now().Add(-2 * time.Second)
+	if d := phaseDuration(&start); d < 2*time.Second || time.Since(start) > time.Second {
+		t.Errorf("expected the 2s phase measured and the start moved to now, got %s", d)
+	}
+
+	s := runSummary{Hosts: []*hostReport{{
+		Host:   "10.0.0.1",
+		Phases: phaseTimings{Tunnel: 1200400 * time.Microsecond, DBus: 15 * time.Millisecond, List: 3 * time.Millisecond, Action: 2500 * time.Millisecond},
+	}}}
+
+	var buf strings.Builder
+	if err := writeSummary(&buf, "text", s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "10.0.0.1: tunnel 1.2s, dbus 15ms, list 3ms, action 2.5s\n") {
+		t.Errorf("missing phase timings:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeSummary(&buf, "json", s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"phases":{"action_seconds":2.5,"dbus_seconds":0.015,"list_seconds":0.003,"tunnel_seconds":1.2004}`) {
+		t.Errorf("unexpected phases: %s", buf.String())
+	}
+}
+
+func TestSummaryTable(t *testing.T) {
+	results := []unitResult{
+		{Host: "db1", Unit: "mysql.service", Action: "restart", Result: "done", State: "active (running)", Duration: 1234 * time.Millisecond},
+		{Host: "db2", Unit: "mysql.service", Action: "restart", Result: "failed", Error: "job failed", State: "failed (failed)", Duration: 90 * time.Millisecond},
+	}
+
+	var buf strings.Builder
+	writeTable(&buf, runSummary{Hosts: []*hostReport{{Host: "db1"}}, Results: results[:1]})
+	want := "UNIT           ACTION   JOB RESULT  FINAL STATE       DURATION\n" +
+		"mysql.service  restart  done        active (running)  1.234s\n"
+	if buf.String() != want {
+		t.Errorf("unexpected table:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	writeTable(&buf, runSummary{Hosts: []*hostReport{{Host: "db1"}, {Host: "db2"}}, Results: results})
+	want = "HOST  UNIT           ACTION   JOB RESULT         FINAL STATE       DURATION\n" +
+		"db1   mysql.service  restart  done               active (running)  1.234s\n" +
+		"db2   mysql.service  restart  error: job failed  failed (failed)   90ms\n"
+	if buf.String() != want {
+		t.Errorf("unexpected multi-host table:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	writeTable(&buf, runSummary{})
+	if buf.Len() != 0 {
+		t.Errorf("expected no table without results, got:\n%s", buf.String())
+	}
+}
+
+func TestSummaryHostFacts(t *testing.T) {
+	s := runSummary{
+		Outcome: "success",
+		Hosts: []*hostReport{{
+			Host:            "10.0.0.1",
+			Hostname:        "web1",
+			SystemdVersion:  "252.5",
+			Virtualization:  "kvm",
+			ListMethod:      "by-patterns",
+			ListCall:        "ListUnitsByPatterns",
+			ListSelection:   "introspected",
+			ListCallSeconds: 0.012,
+			Tunnel:          "ssh-shared",
+		}},
+		Results: []unitResult{{Host: "10.0.0.1", Unit: "nginx.service", Action: "restart", Mode: "fail", Result: "done"}},
+	}
+
+	var buf strings.Builder
+	if err := writeSummary(&buf, "text", s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "10.0.0.1: hostname web1, systemd 252.5, virtualization kvm, ListUnitsByPatterns (introspected) 12ms, tunnel ssh-shared\n") {
+		t.Errorf("missing host facts:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeSummary(&buf, "json", s); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"hostname":"web1"`, `"list_method":"by-patterns"`, `"list_call":"ListUnitsByPatterns"`, `"list_selection":"introspected"`, `"tunnel":"ssh-shared"`, `"mode":"fail"`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("missing %s in %s", field, buf.String())
+		}
+	}
+}
+
+func TestWriteRunOutput(t *testing.T) {
+	service.RegisterSecret("s3cr3t-token")
+	results := []unitResult{{Host: "10.0.0.1", Unit: "nginx.service", Action: "restart", Result: "done", Error: "auth s3cr3t-token rejected"}}
+	s := newRunSummary(corev2.FixtureEvent("entity1", "check1"), "", results, time.Second, nil)
+	metrics := remediationMetrics(results, time.Second, time.Unix(1700000000, 0))
+
+	var buf bytes.Buffer
+	if err := writeRunOutput(&buf, "json", s, metrics); err != nil {
+		t.Fatal(err)
+	}
+	var decoded runSummary
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json output must stay a single document: %v\n%s", err, buf.String())
+	}
+	if strings.Contains(buf.String(), "s3cr3t-token") {
+		t.Errorf("secret not redacted: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeRunOutput(&buf, "text", s, metrics); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if i, j := strings.Index(out, "nginx.service"), strings.Index(out, "systemd_handler.units_acted 1 1700000000\n"); i < 0 || j < i {
+		t.Errorf("expected metrics after the summary:\n%s", out)
+	}
+	if strings.Contains(out, "s3cr3t-token") {
+		t.Errorf("secret not redacted:\n%s", out)
+	}
+}
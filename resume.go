@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// resumeExpiry drops progress of runs not updated for that long from the state
+const resumeExpiry = 24 * time.Hour
+
+// runProgress lists host/unit keys acted on successfully by earlier attempts of an event
+type runProgress struct {
+	Completed []string  `json:"completed"`
+	Updated   time.Time `json:"updated"`
+}
+
+// completedUnits returns the units of the host already done for the event and drops expired runs
+func completedUnits(st *handlerState, event, host string, units []string, now time.Time) []string {
+	for k, run := range st.Runs {
+		if now.Sub(run.Updated) > resumeExpiry {
+			delete(st.Runs, k)
+		}
+	}
+
+	run, ok := st.Runs[event]
+	if !ok {
+		return nil
+	}
+
+	var done []string
+	for _, unit := range units {
+		if slices.Contains(run.Completed, stateKey(host, unit)) {
+			done = append(done, unit)
+		}
+	}
+
+	return done
+}
+
+// markCompleted records the unit as done for the event, so a retried delivery skips it
+func markCompleted(st *handlerState, event, host, unit string, now time.Time) {
+	if st.Runs == nil {
+		st.Runs = make(map[string]runProgress)
+	}
+
+	run := st.Runs[event]
+	if key := stateKey(host, unit); !slices.Contains(run.Completed, key) {
+		run.Completed = append(run.Completed, key)
+	}
+	run.Updated = now
+	st.Runs[event] = run
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResumeProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+	units := []string{"ceph-osd@1.service", "ceph-osd@2.service", "ceph-osd@3.service"}
+
+	for _, unit := range units[:2] {
+		err := updateState(path, func(st *handlerState) error {
+			markCompleted(st, "event1", "node1", unit, now)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var done, otherHost, otherEvent []string
+	err := updateState(path, func(st *handlerState) error {
+		done = completedUnits(st, "event1", "node1", units, now)
+		otherHost = completedUnits(st, "event1", "node2", units, now)
+		otherEvent = completedUnits(st, "event2", "node1", units, now)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(done, units[:2]) || otherHost != nil || otherEvent != nil {
+		t.Errorf("unexpected completed units: %v, %v, %v", done, otherHost, otherEvent)
+	}
+
+	st := &handlerState{}
+	markCompleted(st, "event1", "node1", units[0], now.Add(-resumeExpiry-time.Minute))
+	if done := completedUnits(st, "event1", "node1", units, now); done != nil || len(st.Runs) != 0 {
+		t.Errorf("expired run must be dropped: %v, %v", done, st.Runs)
+	}
+}
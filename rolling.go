@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/templates"
+	"go.uber.org/multierr"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// healthPollInterval is the period of the rolling health gate checks
+const healthPollInterval = 2 * time.Second
+
+// runRolling acts on at most maxUnavailable hosts at once and halts the rollout on the first failed host.
+// Hosts not started after the halt are reported as not acted on.
+func runRolling(ctx context.Context, hosts []string, maxUnavailable int, run func(host string) error) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var err error
+	halted := false
+
+	sem := make(chan struct{}, maxUnavailable)
+	for _, host := range hosts {
+		acquired := false
+		select {
+		case sem <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+
+		mu.Lock()
+		stop := halted || ctx.Err() != nil
+		if stop {
+			err = multierr.Append(err, fmt.Errorf("%s: rollout halted, not acted on", host))
+		}
+		mu.Unlock()
+		if stop {
+			if acquired {
+				<-sem
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err2 := run(host)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err2 != nil {
+				halted = true
+				err = multierr.Append(err, err2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return err
+}
+
+// waitHealthy waits until the units are active and the health URL, if set, answers with 2xx
+func waitHealthy(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection, units []string, data drainData) error {
+	ctx, cancel := context.WithTimeout(ctx, plugin.rollingTimeout)
+	defer cancel()
+
+	var healthURL string
+	if plugin.HealthURL != "" {
+		var err error
+		healthURL, err = templates.EvalTemplate("health-url", plugin.HealthURL, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Waiting for host to become healthy", "units", len(units), "url", healthURL, "timeout", plugin.rollingTimeout)
+
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		lastErr := checkHealthy(ctx, conn, units, healthURL)
+		if lastErr == nil {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%s: not healthy within %s: %w", data.Host, plugin.rollingTimeout, lastErr)
+		}
+	}
+}
+
+func checkHealthy(ctx context.Context, conn service.SystemdConnection, units []string, healthURL string) error {
+	for _, unit := range units {
+		state, err := service.UnitState(ctx, conn, unit)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(state, "active ") {
+			return fmt.Errorf("unit %s is %s", unit, state)
+		}
+	}
+
+	if healthURL != "" {
+		return doDrain(ctx, http.MethodGet, healthURL, "", plugin.DrainHeaders)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/multierr"
+)
+
+func TestRunRolling(t *testing.T) {
+	var acted []string
+	err := runRolling(context.Background(), []string{"a", "b", "c", "d"}, 1, func(host string) error {
+		acted = append(acted, host)
+		if host == "b" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected rollout error")
+	}
+	if len(acted) != 2 || acted[1] != "b" {
+		t.Fatalf("rollout did not halt after the failed host: %v", acted)
+	}
+	if n := len(multierr.Errors(err)); n != 3 {
+		t.Fatalf("expected failure and two halted hosts, got %d errors: %v", n, err)
+	}
+}
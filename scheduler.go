@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// tunnelScheduler bounds the number of SSH tunnels open at once.
+// Slots are granted in request order, so a host waiting for a slot is not starved by later ones.
+type tunnelScheduler struct {
+	mu      sync.Mutex
+	limit   int
+	used    int
+	waiters []chan struct{}
+}
+
+func newTunnelScheduler(limit int) *tunnelScheduler {
+	return &tunnelScheduler{limit: limit}
+}
+
+var (
+	tunnelSlots     *tunnelScheduler
+	tunnelSlotsOnce sync.Once
+)
+
+// tunnelSchedule returns the process wide scheduler, sized by --max-tunnels of the first run
+func tunnelSchedule() *tunnelScheduler {
+	tunnelSlotsOnce.Do(func() { tunnelSlots = newTunnelScheduler(plugin.MaxTunnels) })
+	return tunnelSlots
+}
+
+// TryAcquire takes a slot if one is free and nobody waits for it
+func (s *tunnelScheduler) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.used < s.limit && len(s.waiters) == 0 {
+		s.used++
+		return true
+	}
+	return false
+}
+
+// Acquire waits for a slot in request order
+func (s *tunnelScheduler) Acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.used < s.limit && len(s.waiters) == 0 {
+		s.used++
+		s.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	s.waiters = append(s.waiters, ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx, w := range s.waiters {
+		if w == ready {
+			s.waiters = append(s.waiters[:idx], s.waiters[idx+1:]...)
+			return ctx.Err()
+		}
+	}
+
+	// the slot was handed over while cancelling, pass it on
+	s.releaseLocked()
+	return ctx.Err()
+}
+
+// Release returns the slot, handing it to the longest waiting request
+func (s *tunnelScheduler) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseLocked()
+}
+
+func (s *tunnelScheduler) releaseLocked() {
+	if len(s.waiters) > 0 {
+		close(s.waiters[0])
+		s.waiters = s.waiters[1:]
+		return
+	}
+	s.used--
+}
+
+// Waiting reports whether requests wait for a slot
+func (s *tunnelScheduler) Waiting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.waiters) > 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTunnelScheduler(t *testing.T) {
+	sched := newTunnelScheduler(2)
+	ctx := context.Background()
+
+	if !sched.TryAcquire() || !sched.TryAcquire() {
+		t.Fatal("expected two free slots")
+	}
+	if sched.TryAcquire() {
+		t.Fatal("expected no free slot")
+	}
+
+	// waiters are queued one by one, so the grant order is known
+	granted := make(chan int, 3)
+	for idx := 0; idx < 3; idx++ {
+		go func() {
+			if err := sched.Acquire(ctx); err == nil {
+				granted <- idx
+			}
+		}()
+		for {
+			sched.mu.Lock()
+			n := len(sched.waiters)
+			sched.mu.Unlock()
+			if n == idx+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// a free slot is not taken past the waiters
+	sched.Release()
+	if got := <-granted; got != 0 {
+		t.Errorf("expected first waiter, got %d", got)
+	}
+	if sched.TryAcquire() {
+		t.Error("TryAcquire must not overtake waiters")
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := sched.Acquire(cctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+
+	for want := 1; want < 3; want++ {
+		sched.Release()
+		if got := <-granted; got != want {
+			t.Errorf("expected waiter %d, got %d", want, got)
+		}
+	}
+	if sched.Waiting() {
+		t.Error("expected no waiters")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// sensuAPI is a minimal Sensu backend API client
+type sensuAPI struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newSensuAPI(apiURL, apiKey string) *sensuAPI {
+	return &sensuAPI{
+		url:    strings.TrimRight(apiURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// do sends JSON body to the API path
+func (a *sensuAPI) do(ctx context.Context, method, path, contentType string, body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.url+path, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Key "+a.apiKey)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Annotate merges annotations into the event's entity or the event itself
+func (a *sensuAPI) Annotate(ctx context.Context, event *corev2.Event, target string, annotations map[string]string) error {
+	if event == nil || event.Entity == nil {
+		return fmt.Errorf("event has no entity")
+	}
+
+	ns := url.PathEscape(event.Entity.Namespace)
+	entity := url.PathEscape(event.Entity.Name)
+
+	var path string
+	var patch any
+	switch target {
+	case "entity":
+		path = fmt.Sprintf("/api/core/v2/namespaces/%s/entities/%s", ns, entity)
+		patch = map[string]any{"metadata": map[string]any{"annotations": annotations}}
+
+	case "event":
+		if event.Check == nil {
+			return fmt.Errorf("event has no check")
+		}
+		path = fmt.Sprintf("/api/core/v2/namespaces/%s/events/%s/%s", ns, entity, url.PathEscape(event.Check.Name))
+		patch = map[string]any{"check": map[string]any{"metadata": map[string]any{"annotations": annotations}}}
+
+	default:
+		return fmt.Errorf("unsupported annotate target: %s", target)
+	}
+
+	return a.do(ctx, http.MethodPatch, path, "application/merge-patch+json", patch)
+}
+
+// PostEvent creates the event via backend API or, when agent is set, via the agent events API
+func (a *sensuAPI) PostEvent(ctx context.Context, ev *corev2.Event, agent bool) error {
+	if agent {
+		return a.do(ctx, http.MethodPost, "/events", "application/json", ev)
+	}
+
+	path := fmt.Sprintf("/api/core/v2/namespaces/%s/events", url.PathEscape(ev.Entity.Namespace))
+	return a.do(ctx, http.MethodPost, path, "application/json", ev)
+}
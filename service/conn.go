@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// SystemdConnection is the subset of the systemd D-Bus connection used by the handler,
+// servicetest.Conn implements it for tests
+type SystemdConnection interface {
+	StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	TryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadOrRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadOrTryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ResetFailedUnitContext(ctx context.Context, name string) error
+	SetUnitPropertiesContext(ctx context.Context, name string, runtime bool, properties ...dbus.Property) error
+	ListJobsContext(ctx context.Context) ([]dbus.JobStatus, error)
+	ReloadContext(ctx context.Context) error
+	UnmaskUnitFilesContext(ctx context.Context, files []string, runtime bool) ([]dbus.UnmaskUnitFileChange, error)
+
+	ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error)
+	ListUnitsFilteredContext(ctx context.Context, states []string) ([]dbus.UnitStatus, error)
+	ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]dbus.UnitStatus, error)
+
+	GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error)
+	GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error)
+	GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error)
+	GetManagerProperty(prop string) (string, error)
+}
+
+var _ SystemdConnection = (*dbus.Conn)(nil)
@@ -25,7 +25,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -34,39 +33,61 @@ import (
 )
 
 // UnitFetcher a unit retrieval method
-type UnitFetcher func(ctx context.Context, conn *dbus.Conn, states, patterns []string) ([]dbus.UnitStatus, error)
+type UnitFetcher func(ctx context.Context, conn SystemdConnection, states, patterns []string) ([]dbus.UnitStatus, error)
 
 // InstrospectForUnitMethods determines what methods are available via dbus for listing systemd units.
 // We have a number of functions, some better than others, for getting and filtering unit lists.
 // This will attempt to find the most optimal method, and move down to methods that require more work.
 func InstrospectForUnitMethods(conn *dbusRaw.Conn) (UnitFetcher, error) {
+	method, err := IntrospectListMethod(conn)
+	if err != nil {
+		return nil, err
+	}
+	return UnitFetcherFor(method)
+}
+
+// IntrospectListMethod returns the name of the most optimal list method, see ListMethodFor
+func IntrospectListMethod(conn *dbusRaw.Conn) (string, error) {
 	var err error
 
 	if conn == nil {
 		//setup a dbus connection
 		conn, err = dbusRaw.SystemBusPrivate()
 		if err != nil {
-			return nil, fmt.Errorf("error getting connection to system bus: %w", err)
+			return "", fmt.Errorf("error getting connection to system bus: %w", err)
 		}
+		defer conn.Close()
 	}
 
 	auth := dbusRaw.AuthExternal(strconv.Itoa(os.Getuid()))
 	//auth := dbusRaw.AuthExternal("0")
 	err = conn.Auth([]dbusRaw.Auth{auth})
 	if err != nil {
-		return nil, fmt.Errorf("authentication error: %w", err)
+		return "", fmt.Errorf("authentication error: %w", err)
 	}
 
 	err = conn.Hello()
 	if err != nil {
-		return nil, fmt.Errorf("error in Hello: %w", err)
+		return "", fmt.Errorf("error in Hello: %w", err)
+	}
+
+	unitMap, err := IntrospectListMethods(conn)
+	if err != nil {
+		return "", err
 	}
 
+	return ListMethodFor(unitMap)
+}
+
+// IntrospectListMethods returns ListUnit* methods available on the authenticated connection
+func IntrospectListMethods(conn *dbusRaw.Conn) (map[string]bool, error) {
 	var props string
 
 	//call "introspect" on the systemd1 path to see what ListUnit* methods are available
 	obj := conn.Object("org.freedesktop.systemd1", dbusRaw.ObjectPath("/org/freedesktop/systemd1"))
-	err = obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&props)
+	ctx, cancel := callContext(context.Background())
+	defer cancel()
+	err := callError(ctx, "Introspect", obj.CallWithContext(ctx, "org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&props))
 	if err != nil {
 		return nil, fmt.Errorf("dbus call error: %w", err)
 	}
@@ -76,15 +97,7 @@ func InstrospectForUnitMethods(conn *dbusRaw.Conn) (UnitFetcher, error) {
 		return nil, fmt.Errorf("error handling XML: %w", err)
 	}
 
-	//return a function callback ordered by desirability
-	if _, ok := unitMap["ListUnitsByPatterns"]; ok {
-		return listUnitsByPatternWrapper, nil
-	} else if _, ok := unitMap["ListUnitsFiltered"]; ok {
-		return listUnitsFilteredWrapper, nil
-	} else if _, ok := unitMap["ListUnits"]; ok {
-		return listUnitsWrapper, nil
-	}
-	return nil, fmt.Errorf("no supported list Units function: %v", unitMap)
+	return unitMap, nil
 }
 
 func parseXMLAndReturnMethods(str string) (map[string]bool, error) {
@@ -126,63 +139,76 @@ func parseXMLAndReturnMethods(str string) (map[string]bool, error) {
 }
 
 // listUnitsByPatternWrapper is a bare wrapper for the unitFetcher type
-func listUnitsByPatternWrapper(ctx context.Context, conn *dbus.Conn, states, patterns []string) ([]dbus.UnitStatus, error) {
+func listUnitsByPatternWrapper(ctx context.Context, conn SystemdConnection, states, patterns []string) ([]dbus.UnitStatus, error) {
 	return conn.ListUnitsByPatternsContext(ctx, states, patterns)
 }
 
 //listUnitsFilteredWrapper wraps the dbus ListUnitsFiltered method
-func listUnitsFilteredWrapper(ctx context.Context, conn *dbus.Conn, states, patterns []string) ([]dbus.UnitStatus, error) {
+func listUnitsFilteredWrapper(ctx context.Context, conn SystemdConnection, states, patterns []string) ([]dbus.UnitStatus, error) {
 	units, err := conn.ListUnitsFilteredContext(ctx, states)
 	if err != nil {
 		return nil, fmt.Errorf("ListUnitsFiltered error: %w", err)
 	}
 
-	return MatchUnitPatterns(patterns, units)
+	// states are already filtered remotely
+	return filterUnits(units, patterns, nil)
 }
 
 // listUnitsWrapper wraps the dbus ListUnits method
-func listUnitsWrapper(ctx context.Context, conn *dbus.Conn, states, patterns []string) ([]dbus.UnitStatus, error) {
+func listUnitsWrapper(ctx context.Context, conn SystemdConnection, states, patterns []string) ([]dbus.UnitStatus, error) {
 	units, err := conn.ListUnitsContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("ListUnits error: %w", err)
 	}
-	if len(patterns) > 0 {
-		units, err = MatchUnitPatterns(patterns, units)
-		if err != nil {
-			return nil, fmt.Errorf("matching unit patterns error: %w", err)
-		}
+
+	return filterUnits(units, patterns, states)
+}
+
+// filterUnits keeps units matching any pattern and any state in a single pass.
+// The units slice is reused for the result, so big lists (thousands of units) are not copied.
+func filterUnits(units []dbus.UnitStatus, patterns, states []string) ([]dbus.UnitStatus, error) {
+	matcher, err := CompileMatcher(patterns)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(states) > 0 {
-		var finalUnits []dbus.UnitStatus
-		for _, unit := range units {
-			for _, state := range states {
-				if unit.LoadState == state || unit.ActiveState == state || unit.SubState == state {
-					finalUnits = append(finalUnits, unit)
-					break
-				}
-			}
+	out := units[:0]
+	for _, unit := range units {
+		if len(patterns) > 0 && !matcher.Match(unit.Name) {
+			continue
 		}
-		return finalUnits, nil
+		if len(states) > 0 && !matchState(states, unit) {
+			continue
+		}
+		out = append(out, unit)
 	}
 
-	return units, nil
+	// drop references to filtered out units
+	clear(units[len(out):])
+	return out, nil
+}
+
+func matchState(states []string, unit dbus.UnitStatus) bool {
+	for _, state := range states {
+		if unit.LoadState == state || unit.ActiveState == state || unit.SubState == state {
+			return true
+		}
+	}
+	return false
 }
 
 // MatchUnitPatterns returns a list of units that match the pattern list.
 // This algo, including filepath.Match, is designed to (somewhat) emulate the behavior of ListUnitsByPatterns, which uses `fnmatch`.
 func MatchUnitPatterns(patterns []string, units []dbus.UnitStatus) ([]dbus.UnitStatus, error) {
+	matcher, err := CompileMatcher(patterns)
+	if err != nil {
+		return nil, err
+	}
+
 	var matchUnits []dbus.UnitStatus
 	for _, unit := range units {
-		for _, pattern := range patterns {
-			match, err := filepath.Match(pattern, unit.Name)
-			if err != nil {
-				return nil, fmt.Errorf("matching with pattern %s error: %w", pattern, err)
-			}
-			if match {
-				matchUnits = append(matchUnits, unit)
-				break
-			}
+		if matcher.Match(unit.Name) {
+			matchUnits = append(matchUnits, unit)
 		}
 	}
 	return matchUnits, nil
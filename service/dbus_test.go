@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func newServer(t *testing.T, hidden ...string) *servicetest.Server {
+	t.Helper()
+
+	srv, err := servicetest.NewServer(servicetest.NewConn(map[string]string{
+		"nginx.service": "failed",
+		"nginx.socket":  "active",
+		"mysql.service": "active",
+	}), hidden...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	return srv
+}
+
+func TestInstrospectForUnitMethods(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		hidden []string
+	}{
+		{"by-patterns", nil},
+		{"filtered", []string{"ListUnitsByPatterns"}},
+		{"all", []string{"ListUnitsByPatterns", "ListUnitsFiltered"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(t, tc.hidden...)
+
+			raw, err := godbus.Dial("unix:path=" + srv.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer raw.Close()
+
+			fetcher, err := service.InstrospectForUnitMethods(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := srv.NewSystemdConn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			units, err := fetcher(context.Background(), conn, []string{"failed"}, []string{"nginx.*"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(units) != 1 || units[0].Name != "nginx.service" {
+				t.Errorf("unexpected units: %v", units)
+			}
+		})
+	}
+}
+
+func TestFakeServerStartUnit(t *testing.T) {
+	srv := newServer(t)
+
+	conn, err := srv.NewSystemdConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ch := make(chan string, 1)
+	_, err = conn.RestartUnitContext(context.Background(), "nginx.service", "replace", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("expected done, got %s", result)
+	}
+
+	units, err := conn.ListUnitsByPatternsContext(context.Background(), []string{"active"}, []string{"nginx.service"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 {
+		t.Errorf("expected nginx.service to be active: %v", units)
+	}
+
+	_, err = conn.StartUnitContext(context.Background(), "missing.service", "replace", nil)
+	if err == nil {
+		t.Errorf("expected error for missing unit")
+	}
+}
+
+// listRecorder records which list method was called with what states
+type listRecorder struct {
+	*servicetest.Conn
+	calls []string
+}
+
+func (c *listRecorder) ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error) {
+	c.calls = append(c.calls, "ListUnits")
+	return c.Conn.ListUnitsContext(ctx)
+}
+
+func (c *listRecorder) ListUnitsFilteredContext(ctx context.Context, states []string) ([]dbus.UnitStatus, error) {
+	c.calls = append(c.calls, "ListUnitsFiltered "+strings.Join(states, ","))
+	return c.Conn.ListUnitsFilteredContext(ctx, states)
+}
+
+func TestListMethodStates(t *testing.T) {
+	for _, tc := range []struct {
+		methods map[string]bool
+		method  string
+		remote  bool
+		call    string
+	}{
+		{map[string]bool{"ListUnits": true, "ListUnitsFiltered": true, "ListUnitsByPatterns": true}, "by-patterns", true, ""},
+		{map[string]bool{"ListUnits": true, "ListUnitsFiltered": true}, "filtered", true, "ListUnitsFiltered failed"},
+		{map[string]bool{"ListUnits": true}, "all", false, "ListUnits"},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			method, err := service.ListMethodFor(tc.methods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method != tc.method {
+				t.Fatalf("expected %s, got %s", tc.method, method)
+			}
+			if service.RemoteStateFilter(method) != tc.remote {
+				t.Errorf("unexpected remote state filter for %s", method)
+			}
+
+			conn := &listRecorder{Conn: servicetest.NewConn(map[string]string{
+				"nginx.service": "failed",
+				"nginx.socket":  "active",
+				"mysql.service": "failed",
+			})}
+			fetcher, err := service.UnitFetcherFor(method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			units, err := fetcher(context.Background(), conn, []string{"failed"}, []string{"nginx.*"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(units) != 1 || units[0].Name != "nginx.service" {
+				t.Errorf("unexpected units: %v", units)
+			}
+			if tc.call != "" && (len(conn.calls) != 1 || conn.calls[0] != tc.call) {
+				t.Errorf("expected %q call, got %v", tc.call, conn.calls)
+			}
+		})
+	}
+
+	if _, err := service.ListMethodFor(map[string]bool{}); err == nil {
+		t.Error("expected error without list methods")
+	}
+	if _, err := service.UnitFetcherFor("auto"); err == nil {
+		t.Error("expected auto to need introspection")
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// secretAssignment matches KEY=value strings with sensitive keys
+var secretAssignment = regexp.MustCompile(`(?i)^([^=]*(pass|secret|token|key|credential)[^=]*=).*$`)
+
+// debugInterceptor logs D-Bus messages passing the connection
+func debugInterceptor(direction string) dbus.Interceptor {
+	return func(msg *dbus.Message) {
+		attrs := []any{"direction", direction, "type", msg.Type.String(), "serial", msg.Serial()}
+		for field, v := range msg.Headers {
+			attrs = append(attrs, headerName(field), v.String())
+		}
+		attrs = append(attrs, "body", fmt.Sprintf("%v", redactBody(msg.Body)))
+
+		slog.Info("D-Bus message", attrs...)
+	}
+}
+
+func headerName(field dbus.HeaderField) string {
+	switch field {
+	case dbus.FieldPath:
+		return "path"
+	case dbus.FieldInterface:
+		return "interface"
+	case dbus.FieldMember:
+		return "member"
+	case dbus.FieldErrorName:
+		return "error_name"
+	case dbus.FieldReplySerial:
+		return "reply_serial"
+	case dbus.FieldDestination:
+		return "destination"
+	case dbus.FieldSender:
+		return "sender"
+	case dbus.FieldSignature:
+		return "signature"
+	default:
+		return fmt.Sprintf("field_%d", field)
+	}
+}
+
+// redactBody hides values of sensitive KEY=value strings
+func redactBody(body []interface{}) []interface{} {
+	out := make([]interface{}, len(body))
+	for i, v := range body {
+		out[i] = redactValue(v)
+	}
+
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		return secretAssignment.ReplaceAllString(value, "${1}***")
+
+	case []string:
+		out := make([]string, len(value))
+		for i, s := range value {
+			out[i] = secretAssignment.ReplaceAllString(s, "${1}***")
+		}
+		return out
+
+	case []interface{}:
+		return redactBody(value)
+
+	case dbus.Variant:
+		return redactValue(value.Value())
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestDebugInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	saved := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	msg := &dbus.Message{
+		Type: dbus.TypeMethodCall,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:   dbus.MakeVariant(dbus.ObjectPath("/org/freedesktop/systemd1")),
+			dbus.FieldMember: dbus.MakeVariant("SetUnitProperties"),
+		},
+		Body: []interface{}{
+			"nginx.service",
+			[]string{"DB_PASSWORD=hunter2", "PORT=8080"},
+			dbus.MakeVariant("API_TOKEN=abc123"),
+		},
+	}
+	debugInterceptor("send")(msg)
+
+	out := buf.String()
+	for _, want := range []string{"direction=send", `type="method call"`, "SetUnitProperties", "/org/freedesktop/systemd1", `body="[nginx.service [DB_PASSWORD=*** PORT=8080] API_TOKEN=***]"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+	for _, secret := range []string{"hunter2", "abc123"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %q logged: %s", secret, out)
+		}
+	}
+}
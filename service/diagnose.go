@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrNoSystemd is returned when the target has no usable systemd, e.g. a container with another init
+var ErrNoSystemd = errors.New("no usable systemd")
+
+// DiagnoseSystemd explains a failed D-Bus connection by checking the remote PID 1.
+// It returns nil when nothing suspicious is found.
+func (t *DBusTunnel) DiagnoseSystemd(ctx context.Context) error {
+	out, err := t.RunCommand(ctx, "cat /proc/1/comm; systemd-detect-virt --container 2>/dev/null || true")
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	pid1 := strings.TrimSpace(lines[0])
+	virt := "none"
+	if len(lines) > 1 {
+		virt = strings.TrimSpace(lines[1])
+	}
+
+	if pid1 != "systemd" {
+		return fmt.Errorf("%w: PID 1 is %q (container: %s)", ErrNoSystemd, pid1, virt)
+	}
+
+	return nil
+}
+
+// Virtualization returns the virtualization technology systemd detected, empty on bare metal
+func Virtualization(conn SystemdConnection) (string, error) {
+	prop, err := conn.GetManagerProperty("Virtualization")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Trim(prop, `"`), nil
+}
+
+// SystemState returns the manager state as systemctl is-system-running reports it, e.g. "running" or "starting"
+func SystemState(conn SystemdConnection) (string, error) {
+	prop, err := conn.GetManagerProperty("SystemState")
+	if err != nil {
+		return "", fmt.Errorf("get system state error: %w", err)
+	}
+
+	return strings.Trim(prop, `"`), nil
+}
+
+// Hostname returns the remote host name from systemd-hostnamed, it is activated on demand
+func (t *DBusTunnel) Hostname() (string, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	obj := conn.Object("org.freedesktop.hostname1", dbus.ObjectPath("/org/freedesktop/hostname1"))
+	v, err := getProperty(obj, "org.freedesktop.hostname1.Hostname")
+	if err != nil {
+		return "", fmt.Errorf("get hostname error: %w", err)
+	}
+
+	name, _ := v.Value().(string)
+	return name, nil
+}
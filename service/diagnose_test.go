@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseSystemd(t *testing.T) {
+	// ssh stub replies with the canned remote output and records the command
+	dir := t.TempDir()
+	reply := filepath.Join(dir, "reply")
+	stub := "#!/bin/sh\nfor a; do :; done\necho \"$a\" > " + filepath.Join(dir, "command") + "\ncat " + reply + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(stub), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	tun := &DBusTunnel{ctlsock: filepath.Join(dir, "ctl"), cfg: DBusTunnelConfig{SSHHost: "node1", User: "root", SSHPort: 22}}
+	for _, tc := range []struct {
+		reply string
+		err   string
+	}{
+		{"systemd\nnone\n", ""},
+		{"bash\ndocker\n", `no usable systemd: PID 1 is "bash" (container: docker)`},
+		{"tini\n", `no usable systemd: PID 1 is "tini" (container: none)`},
+	} {
+		if err := os.WriteFile(reply, []byte(tc.reply), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := tun.DiagnoseSystemd(context.Background())
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err || !errors.Is(err, ErrNoSystemd)) {
+			t.Errorf("%q: expected %q, got %v", tc.reply, tc.err, err)
+		}
+	}
+
+	buf, err := os.ReadFile(filepath.Join(dir, "command"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf), "cat /proc/1/comm;") {
+		t.Errorf("unexpected remote command: %s", buf)
+	}
+
+	// diagnosis is best effort, a failed command hides nothing from the caller's D-Bus error
+	os.Remove(reply)
+	if err := tun.DiagnoseSystemd(context.Background()); err != nil {
+		t.Errorf("expected no diagnosis when the command fails, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	systemdDBus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// SetEnvironment adds KEY=VALUE assignments to the service environment until reboot (runtime drop-in).
+// They take effect on the next start of the service.
+func SetEnvironment(ctx context.Context, conn SystemdConnection, unit string, env []string) error {
+	prop := systemdDBus.Property{Name: "Environment", Value: dbus.MakeVariant(env)}
+
+	err := conn.SetUnitPropertiesContext(ctx, unit, true, prop)
+	if err != nil {
+		return fmt.Errorf("set %s environment error: %w", unit, err)
+	}
+
+	return nil
+}
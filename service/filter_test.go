@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+func TestFilterUnits(t *testing.T) {
+	units := []dbus.UnitStatus{
+		{Name: "nginx.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+		{Name: "nginx.socket", LoadState: "loaded", ActiveState: "active", SubState: "listening"},
+		{Name: "mysql.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+		{Name: "php8.1-fpm.service", LoadState: "loaded", ActiveState: "activating", SubState: "auto-restart"},
+		{Name: "cron.service", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+	}
+
+	out, err := filterUnits(units, []string{"nginx.*", "php*-fpm.service", "cron.service"}, []string{"failed", "auto-restart"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Name != "nginx.service" || out[1].Name != "php8.1-fpm.service" {
+		t.Fatalf("unexpected units: %v", out)
+	}
+
+	// filtered in place, the tail does not keep dropped units alive
+	if &out[0] != &units[0] {
+		t.Error("expected the units slice reused")
+	}
+	for _, u := range units[len(out):] {
+		if u.Name != "" {
+			t.Errorf("expected the tail cleared, got %v", u)
+		}
+	}
+
+	if _, err := filterUnits(nil, []string{"bad["}, nil); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+func FuzzMatchUnitPatterns(f *testing.F) {
+	f.Add("nginx*.service", "nginx.service")
+	f.Add("ceph-osd@[0-9]*.service", "ceph-osd@12.service")
+	f.Add("[", "a.service")
+	f.Add("\\", "\\")
+	f.Add("*", "")
+
+	f.Fuzz(func(t *testing.T, pattern, name string) {
+		units := []dbus.UnitStatus{{Name: name}}
+
+		matched, err := MatchUnitPatterns([]string{pattern}, units)
+		want, wantErr := filepath.Match(pattern, name)
+		if wantErr != nil {
+			// malformed pattern is reported only when it is evaluated up to the bad part
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", pattern, err)
+		}
+		if (len(matched) == 1) != want {
+			t.Fatalf("pattern %q, name %q: matched %v, want %v", pattern, name, matched, want)
+		}
+
+		filtered, err := filterUnits(units, []string{pattern}, nil)
+		if err != nil {
+			if _, badErr := filepath.Match(pattern, ""); badErr == nil {
+				t.Fatalf("filterUnits unexpected error for %q: %v", pattern, err)
+			}
+			return
+		}
+		if (len(filtered) == 1) != want {
+			t.Fatalf("filterUnits pattern %q, name %q: got %v, want %v", pattern, name, filtered, want)
+		}
+	})
+}
+
+func FuzzParseXMLAndReturnMethods(f *testing.F) {
+	f.Add(`<node><interface name="org.freedesktop.systemd1.Manager"><method name="ListUnits"/><method name="ListUnitsByPatterns"/><method name="StartUnit"/></interface></node>`)
+	f.Add(`<node/>`)
+	f.Add(`<node><interface><method/></interface></node>`)
+	f.Add(`<!DOCTYPE node><node><interface name="x"><method name="ListUnitsFiltered"></method></interface><node name="unit"/></node>`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, data string) {
+		methods, err := parseXMLAndReturnMethods(data)
+		if err != nil {
+			return
+		}
+
+		for name := range methods {
+			if !strings.Contains(name, "ListUnits") {
+				t.Fatalf("unexpected method %q", name)
+			}
+		}
+	})
+}
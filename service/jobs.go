@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// JobCanceler cancels systemd jobs, go-systemd does not wrap Manager.CancelJob
+type JobCanceler interface {
+	CancelJob(ctx context.Context, id uint32) error
+}
+
+var _ JobCanceler = (*DBusTunnel)(nil)
+
+// CancelJob cancels the queued or running job over own raw connection
+func (t *DBusTunnel) CancelJob(ctx context.Context, id uint32) error {
+	conn, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	obj := conn.Object("org.freedesktop.systemd1", dbus.ObjectPath("/org/freedesktop/systemd1"))
+	err = callError(ctx, "CancelJob", obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.CancelJob", 0, id).Err)
+	if err != nil {
+		return fmt.Errorf("CancelJob %d error: %w", id, err)
+	}
+
+	return nil
+}
+
+// CancelUnitJobs cancels all pending jobs of the unit and returns their number
+func CancelUnitJobs(ctx context.Context, conn SystemdConnection, canceler JobCanceler, unit string) (int, error) {
+	jobs, err := conn.ListJobsContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ListJobs error: %w", err)
+	}
+
+	n := 0
+	for _, job := range jobs {
+		if job.Unit != unit {
+			continue
+		}
+
+		err = canceler.CancelJob(ctx, job.Id)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// UnitJobs returns queued or running jobs of the units, e.g. "restart running", by unit name
+func UnitJobs(ctx context.Context, conn SystemdConnection, units []string) (map[string]string, error) {
+	jobs, err := conn.ListJobsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ListJobs error: %w", err)
+	}
+
+	out := make(map[string]string)
+	for _, job := range jobs {
+		if slices.Contains(units, job.Unit) {
+			out[job.Unit] = job.JobType + " " + job.Status
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+)
+
+// ListMethods are names accepted by UnitFetcherFor, "auto" means introspection
+var ListMethods = []string{"auto", "by-patterns", "filtered", "all"}
+
+// UnitFetcherFor returns the unit retrieval method by name without introspection
+func UnitFetcherFor(method string) (UnitFetcher, error) {
+	switch method {
+	case "by-patterns":
+		return listUnitsByPatternWrapper, nil
+	case "filtered":
+		return listUnitsFilteredWrapper, nil
+	case "all":
+		return listUnitsWrapper, nil
+	default:
+		return nil, fmt.Errorf("unsupported list method: %s", method)
+	}
+}
+
+// ListMethodCall returns the systemd Manager method behind the list method name
+func ListMethodCall(method string) string {
+	switch method {
+	case "by-patterns":
+		return "ListUnitsByPatterns"
+	case "filtered":
+		return "ListUnitsFiltered"
+	case "all":
+		return "ListUnits"
+	default:
+		return ""
+	}
+}
+
+// ListMethodFor picks the best method among the introspected ListUnit* methods.
+// ListUnitsByPatterns filters both patterns and states remotely, ListUnitsFiltered only states,
+// so with states requested it still avoids transferring the full unit list.
+func ListMethodFor(methods map[string]bool) (string, error) {
+	switch {
+	case methods["ListUnitsByPatterns"]:
+		return "by-patterns", nil
+	case methods["ListUnitsFiltered"]:
+		return "filtered", nil
+	case methods["ListUnits"]:
+		return "all", nil
+	default:
+		return "", fmt.Errorf("no supported list Units function: %v", methods)
+	}
+}
+
+// RemoteStateFilter reports whether the method filters unit states on the remote systemd
+func RemoteStateFilter(method string) bool {
+	return method == "by-patterns" || method == "filtered"
+}
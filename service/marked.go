@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	systemdDBus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// Unit markers, systemd 248+
+const (
+	MarkerNeedsRestart = "needs-restart"
+	MarkerNeedsReload  = "needs-reload"
+)
+
+// Mark sets the marker on the unit for EnqueueMarkedJobs
+func Mark(ctx context.Context, conn SystemdConnection, unit, marker string) error {
+	prop := systemdDBus.Property{Name: "Markers", Value: dbus.MakeVariant([]string{marker})}
+
+	err := conn.SetUnitPropertiesContext(ctx, unit, true, prop)
+	if err != nil {
+		return fmt.Errorf("mark %s %s error: %w", unit, marker, err)
+	}
+
+	return nil
+}
+
+// EnqueueMarkedJobs asks systemd to restart or reload all marked units, systemd 248+.
+// go-systemd does not wrap the call, so it goes over own raw connection.
+func (t *DBusTunnel) EnqueueMarkedJobs(ctx context.Context) ([]dbus.ObjectPath, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	var jobs []dbus.ObjectPath
+	obj := conn.Object("org.freedesktop.systemd1", dbus.ObjectPath("/org/freedesktop/systemd1"))
+	err = callError(ctx, "EnqueueMarkedJobs", obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.EnqueueMarkedJobs", 0).Store(&jobs))
+	if err != nil {
+		return nil, fmt.Errorf("EnqueueMarkedJobs error: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// WaitJobs polls the job list until none of the jobs is queued or running
+func WaitJobs(ctx context.Context, conn SystemdConnection, jobs []dbus.ObjectPath, interval time.Duration) error {
+	pending := make(map[dbus.ObjectPath]bool, len(jobs))
+	for _, job := range jobs {
+		pending[job] = true
+	}
+
+	for {
+		list, err := conn.ListJobsContext(ctx)
+		if err != nil {
+			return fmt.Errorf("ListJobs error: %w", err)
+		}
+
+		left := 0
+		for _, job := range list {
+			if pending[job.JobPath] {
+				left++
+			}
+		}
+		if left == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("%d marked job(s) still running: %w", left, ctx.Err())
+		}
+	}
+}
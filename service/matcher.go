@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// globMeta are the filepath.Match special characters
+const globMeta = `*?[\`
+
+// Matcher matches names against glob patterns compiled once.
+// Literal names are looked up in a set, "prefix*" and "*suffix" patterns are compared as strings,
+// only the remaining patterns go through filepath.Match. Results are the same as with filepath.Match.
+type Matcher struct {
+	exact    map[string]struct{}
+	prefixes []string
+	suffixes []string
+	globs    []string
+}
+
+// CompileMatcher validates the patterns and prepares the matcher
+func CompileMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{exact: make(map[string]struct{})}
+
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("matching with pattern %s error: %w", pattern, err)
+		}
+
+		switch {
+		case !strings.ContainsAny(pattern, globMeta):
+			m.exact[pattern] = struct{}{}
+		case strings.HasSuffix(pattern, "*") && !strings.ContainsAny(pattern[:len(pattern)-1], globMeta):
+			m.prefixes = append(m.prefixes, pattern[:len(pattern)-1])
+		case strings.HasPrefix(pattern, "*") && !strings.ContainsAny(pattern[1:], globMeta):
+			m.suffixes = append(m.suffixes, pattern[1:])
+		default:
+			m.globs = append(m.globs, pattern)
+		}
+	}
+
+	return m, nil
+}
+
+// Match reports whether name matches any of the patterns, nil matcher matches nothing
+func (m *Matcher) Match(name string) bool {
+	if m == nil {
+		return false
+	}
+	if _, ok := m.exact[name]; ok {
+		return true
+	}
+
+	// * does not match the separator in filepath.Match
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			return true
+		}
+	}
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(name, suffix) && !strings.Contains(name[:len(name)-len(suffix)], "/") {
+			return true
+		}
+	}
+
+	for _, pattern := range m.globs {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package service_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestMatcher(t *testing.T) {
+	patterns := []string{"nginx.service", "php*-fpm.service", "getty@*", "*.timer", "sys-*", "a?c.service", "[xy]z.*"}
+	names := []string{
+		"nginx.service", "nginx.socket", "php8.1-fpm.service", "getty@tty1.service", "getty@",
+		"fstrim.timer", "dir/fstrim.timer", "sys-kernel.mount", "sys-/x", "abc.service", "ac.service",
+		"xz.slice", "zz.slice", "", "*.timer",
+	}
+
+	m, err := service.CompileMatcher(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		want := false
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				want = true
+			}
+		}
+		if got := m.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, filepath.Match gives %v", name, got, want)
+		}
+	}
+
+	if _, err := service.CompileMatcher([]string{"bad["}); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+
+	var nilMatcher *service.Matcher
+	if nilMatcher.Match("nginx.service") {
+		t.Error("nil matcher must match nothing")
+	}
+}
+
+func benchmarkData() (patterns, units []string) {
+	for i := 0; i < 40; i++ {
+		switch i % 4 {
+		case 0:
+			patterns = append(patterns, fmt.Sprintf("app%d.service", i))
+		case 1:
+			patterns = append(patterns, fmt.Sprintf("worker%d@*", i))
+		case 2:
+			patterns = append(patterns, fmt.Sprintf("*-%d.timer", i))
+		default:
+			patterns = append(patterns, fmt.Sprintf("job%d-*.scope", i))
+		}
+	}
+	for i := 0; i < 5000; i++ {
+		units = append(units, fmt.Sprintf("unit%d-%d.service", i, i%40))
+	}
+	return patterns, units
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	patterns, units := benchmarkData()
+	m, err := service.CompileMatcher(patterns)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, unit := range units {
+			m.Match(unit)
+		}
+	}
+}
+
+func BenchmarkFilepathMatch(b *testing.B) {
+	patterns, units := benchmarkData()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, unit := range units {
+			for _, pattern := range patterns {
+				if ok, _ := filepath.Match(pattern, unit); ok {
+					break
+				}
+			}
+		}
+	}
+}
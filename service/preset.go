@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	systemdDBus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// UnitFilePresetter applies preset policy to unit files, go-systemd does not wrap Manager.PresetUnitFiles
+type UnitFilePresetter interface {
+	PresetUnitFiles(ctx context.Context, files []string) ([]systemdDBus.EnableUnitFileChange, error)
+}
+
+// UnitFileReverter drops local unit file overrides, go-systemd does not wrap Manager.RevertUnitFiles
+type UnitFileReverter interface {
+	RevertUnitFiles(ctx context.Context, files []string) ([]systemdDBus.EnableUnitFileChange, error)
+}
+
+var (
+	_ UnitFilePresetter = (*DBusTunnel)(nil)
+	_ UnitFileReverter  = (*DBusTunnel)(nil)
+)
+
+// PresetUnitFiles enables or disables the unit files as the preset policy says, as systemctl preset does.
+// It changes the persistent configuration only, the manager still needs a daemon-reload.
+func (t *DBusTunnel) PresetUnitFiles(ctx context.Context, files []string) ([]systemdDBus.EnableUnitFileChange, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	var carriesInstallInfo bool
+	var changes []systemdDBus.EnableUnitFileChange
+	obj := conn.Object("org.freedesktop.systemd1", dbus.ObjectPath("/org/freedesktop/systemd1"))
+	err = callError(ctx, "PresetUnitFiles", obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.PresetUnitFiles", 0, files, false, false).Store(&carriesInstallInfo, &changes))
+	if err != nil {
+		return nil, fmt.Errorf("PresetUnitFiles error: %w", err)
+	}
+
+	return changes, nil
+}
+
+// RevertUnitFiles removes unit file copies in /etc and /run, drop-ins and runtime property overrides,
+// as systemctl revert does, systemd 230+. The manager still needs a daemon-reload.
+func (t *DBusTunnel) RevertUnitFiles(ctx context.Context, files []string) ([]systemdDBus.EnableUnitFileChange, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	var changes []systemdDBus.EnableUnitFileChange
+	obj := conn.Object("org.freedesktop.systemd1", dbus.ObjectPath("/org/freedesktop/systemd1"))
+	err = callError(ctx, "RevertUnitFiles", obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.RevertUnitFiles", 0, files).Store(&changes))
+	if err != nil {
+		return nil, fmt.Errorf("RevertUnitFiles error: %w", err)
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ProbeResult describes the remote systemd reachable over the tunnel
+type ProbeResult struct {
+	Version        string   `json:"version"`
+	Virtualization string   `json:"virtualization,omitempty"`
+	ListMethods    []string `json:"list_methods"`
+}
+
+// Probe authenticates to the remote systemd and reads its version and available ListUnit* methods
+func (t *DBusTunnel) Probe() (*ProbeResult, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	methods, err := IntrospectListMethods(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProbeResult{}
+	for m := range methods {
+		res.ListMethods = append(res.ListMethods, m)
+	}
+	sort.Strings(res.ListMethods)
+
+	obj := conn.Object("org.freedesktop.systemd1", dbus.ObjectPath("/org/freedesktop/systemd1"))
+	v, err := getProperty(obj, "org.freedesktop.systemd1.Manager.Version")
+	if err != nil {
+		return res, err
+	}
+	res.Version = strings.Trim(v.String(), `"`)
+
+	// not available on old systemd
+	if v, err := getProperty(obj, "org.freedesktop.systemd1.Manager.Virtualization"); err == nil {
+		res.Virtualization = strings.Trim(v.String(), `"`)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,34 @@
+package service_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestProbe(t *testing.T) {
+	srv := newServer(t, "ListUnitsByPatterns")
+	srv.Conn.Manager["Virtualization"] = "kvm"
+
+	tun := service.TunnelForSocket(srv.Addr())
+	defer tun.Close()
+
+	res, err := tun.Probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != "252" || res.Virtualization != "kvm" {
+		t.Errorf("unexpected probe result: %+v", res)
+	}
+	if !slices.Equal(res.ListMethods, []string{"ListUnits", "ListUnitsFiltered"}) {
+		t.Errorf("expected introspected list methods, got %v", res.ListMethods)
+	}
+
+	// old systemd without the Virtualization property still probes
+	delete(srv.Conn.Manager, "Virtualization")
+	res, err = tun.Probe()
+	if err != nil || res.Version != "252" || res.Virtualization != "" {
+		t.Errorf("unexpected probe result: %+v: %v", res, err)
+	}
+}
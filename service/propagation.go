@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// dependentProperties list units that systemd stops or restarts along with the unit (reverse PartOf= and BindsTo=)
+var dependentProperties = []string{"ConsistsOf", "BoundBy"}
+
+// DependentStates is a state snapshot of units bound to the acted unit
+type DependentStates map[string]string
+
+// SnapshotDependents reads states of units which are PartOf or BindsTo the unit
+func SnapshotDependents(ctx context.Context, conn SystemdConnection, unit string) (DependentStates, error) {
+	props, err := conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return nil, fmt.Errorf("get unit properties error: %w", err)
+	}
+
+	states := make(DependentStates)
+	for _, name := range dependentProperties {
+		deps, _ := props[name].([]string)
+		for _, dep := range deps {
+			if _, ok := states[dep]; ok {
+				continue
+			}
+
+			dprops, err := conn.GetUnitPropertiesContext(ctx, dep)
+			if err != nil {
+				return nil, fmt.Errorf("get %s properties error: %w", dep, err)
+			}
+
+			// restart keeps the state, the activation timestamp tells it
+			state := fmt.Sprintf("%v (%v)", dprops["ActiveState"], dprops["SubState"])
+			if ts := formatProperty("ActiveEnterTimestamp", dprops["ActiveEnterTimestamp"]); ts != "" {
+				state += " since " + ts
+			}
+			states[dep] = state
+		}
+	}
+
+	return states, nil
+}
+
+// Changed lists dependents whose state changed, including restarts keeping the same state, as "unit: before -> after"
+func (s DependentStates) Changed(after DependentStates) []string {
+	var out []string
+	for unit, state := range after {
+		if before, ok := s[unit]; ok && before != state {
+			out = append(out, fmt.Sprintf("%s: %s -> %s", unit, before, state))
+		}
+	}
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,44 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestDependentsChanged(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{
+		"libvirtd.service":  "active",
+		"virtlogd.service":  "active",
+		"virtlockd.service": "active",
+		"unrelated.service": "active",
+	})
+	conn.Properties["libvirtd.service"] = map[string]any{"ConsistsOf": []string{"virtlogd.service"}, "BoundBy": []string{"virtlockd.service"}}
+
+	before, err := service.SnapshotDependents(ctx, conn, "libvirtd.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 {
+		t.Fatalf("unexpected dependents: %v", before)
+	}
+
+	ch := make(chan string, 1)
+	if _, err := conn.StopUnitContext(ctx, "virtlockd.service", "replace", ch); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+
+	after, err := service.SnapshotDependents(ctx, conn, "libvirtd.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := before.Changed(after)
+	if len(changed) != 1 || changed[0] != "virtlockd.service: active (running) -> inactive (dead)" {
+		t.Errorf("unexpected changes: %q", changed)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	snapshotUnitProperties    = []string{"ActiveState", "SubState"}
+	snapshotServiceProperties = []string{"NRestarts", "ExecMainStartTimestamp", "MemoryCurrent"}
+)
+
+// UnitSnapshot is a set of formatted unit properties at some moment
+type UnitSnapshot map[string]string
+
+// Snapshot reads unit state properties for the before/after report
+func Snapshot(ctx context.Context, conn SystemdConnection, unit string) (UnitSnapshot, error) {
+	snap := make(UnitSnapshot)
+
+	props, err := conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return nil, fmt.Errorf("get unit properties error: %w", err)
+	}
+	for _, name := range snapshotUnitProperties {
+		snap[name] = formatProperty(name, props[name])
+	}
+
+	if !strings.HasSuffix(unit, ".service") {
+		return snap, nil
+	}
+
+	props, err = conn.GetUnitTypePropertiesContext(ctx, unit, "Service")
+	if err != nil {
+		return nil, fmt.Errorf("get service properties error: %w", err)
+	}
+	for _, name := range snapshotServiceProperties {
+		snap[name] = formatProperty(name, props[name])
+	}
+
+	return snap, nil
+}
+
+// Diff returns changed properties as "before -> after"
+func (s UnitSnapshot) Diff(after UnitSnapshot) map[string]string {
+	diff := make(map[string]string)
+	for name, v := range after {
+		if s[name] != v {
+			diff[name] = fmt.Sprintf("%s -> %s", s[name], v)
+		}
+	}
+
+	return diff
+}
+
+func formatProperty(name string, v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+
+	case uint64:
+		if value == math.MaxUint64 {
+			return "[not set]"
+		}
+		if strings.HasSuffix(name, "Timestamp") {
+			if value == 0 {
+				return "n/a"
+			}
+			return time.UnixMicro(int64(value)).UTC().Format(time.RFC3339)
+		}
+		return fmt.Sprintf("%d", value)
+
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// UnitState returns "ActiveState (SubState)" of the unit
+func UnitState(ctx context.Context, conn SystemdConnection, unit string) (string, error) {
+	active, err := conn.GetUnitPropertyContext(ctx, unit, "ActiveState")
+	if err != nil {
+		return "", err
+	}
+
+	sub, err := conn.GetUnitPropertyContext(ctx, unit, "SubState")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s (%s)", active.Value.Value(), sub.Value.Value()), nil
+}
+
+// unitTypeInterfaces maps unit suffixes to the D-Bus interface having the Result property
+var unitTypeInterfaces = map[string]string{
+	".service":   "Service",
+	".socket":    "Socket",
+	".mount":     "Mount",
+	".automount": "Automount",
+	".swap":      "Swap",
+	".timer":     "Timer",
+	".path":      "Path",
+}
+
+// StartLimitHit reports whether the unit is down because its start rate limit was hit
+func StartLimitHit(ctx context.Context, conn SystemdConnection, unit string) (bool, error) {
+	idx := strings.LastIndex(unit, ".")
+	if idx < 0 {
+		return false, nil
+	}
+	typ, ok := unitTypeInterfaces[unit[idx:]]
+	if !ok {
+		return false, nil
+	}
+
+	props, err := conn.GetUnitTypePropertiesContext(ctx, unit, typ)
+	if err != nil {
+		return false, fmt.Errorf("get %s properties error: %w", strings.ToLower(typ), err)
+	}
+
+	return props["Result"] == "start-limit-hit", nil
+}
+
+// CanonicalName resolves the unit alias to the unit Id
+func CanonicalName(ctx context.Context, conn SystemdConnection, unit string) (string, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "Id")
+	if err != nil {
+		return "", fmt.Errorf("get %s id error: %w", unit, err)
+	}
+
+	id, ok := prop.Value.Value().(string)
+	if !ok || id == "" {
+		return unit, nil
+	}
+
+	return id, nil
+}
+
+// NeedDaemonReload reports whether the unit file changed on disk since it was loaded
+func NeedDaemonReload(ctx context.Context, conn SystemdConnection, unit string) (bool, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "NeedDaemonReload")
+	if err != nil {
+		return false, fmt.Errorf("get %s NeedDaemonReload error: %w", unit, err)
+	}
+
+	need, _ := prop.Value.Value().(bool)
+	return need, nil
+}
+
+// ActiveSince returns when the unit last entered active state, zero time if never
+func ActiveSince(ctx context.Context, conn SystemdConnection, unit string) (time.Time, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "ActiveEnterTimestamp")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get %s ActiveEnterTimestamp error: %w", unit, err)
+	}
+
+	usec, _ := prop.Value.Value().(uint64)
+	if usec == 0 || usec == math.MaxUint64 {
+		return time.Time{}, nil
+	}
+
+	return time.UnixMicro(int64(usec)), nil
+}
+
+// RefuseManual returns RefuseManualStart and RefuseManualStop of the unit
+func RefuseManual(ctx context.Context, conn SystemdConnection, unit string) (start, stop bool, err error) {
+	props, err := conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return false, false, fmt.Errorf("get unit properties error: %w", err)
+	}
+
+	start, _ = props["RefuseManualStart"].(bool)
+	stop, _ = props["RefuseManualStop"].(bool)
+	return start, stop, nil
+}
+
+// Masked reports whether the unit is masked
+func Masked(ctx context.Context, conn SystemdConnection, unit string) (bool, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "LoadState")
+	if err != nil {
+		return false, fmt.Errorf("get %s LoadState error: %w", unit, err)
+	}
+
+	return prop.Value.Value() == "masked", nil
+}
+
+// Unmask removes runtime masks of the units and reloads the manager, as systemctl unmask --runtime does
+func Unmask(ctx context.Context, conn SystemdConnection, units []string) error {
+	_, err := conn.UnmaskUnitFilesContext(ctx, units, true)
+	if err != nil {
+		return fmt.Errorf("unmask error: %w", err)
+	}
+
+	err = conn.ReloadContext(ctx)
+	if err != nil {
+		return fmt.Errorf("daemon-reload error: %w", err)
+	}
+
+	return nil
+}
+
+// FragmentPath returns the unit file path, empty for transient units
+func FragmentPath(ctx context.Context, conn SystemdConnection, unit string) (string, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "FragmentPath")
+	if err != nil {
+		return "", fmt.Errorf("get %s FragmentPath error: %w", unit, err)
+	}
+
+	path, _ := prop.Value.Value().(string)
+	return path, nil
+}
+
+// AllowIsolate reports whether the unit may be started with the isolate job mode
+func AllowIsolate(ctx context.Context, conn SystemdConnection, unit string) (bool, error) {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "AllowIsolate")
+	if err != nil {
+		return false, fmt.Errorf("get %s AllowIsolate error: %w", unit, err)
+	}
+
+	allow, _ := prop.Value.Value().(bool)
+	return allow, nil
+}
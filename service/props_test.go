@@ -0,0 +1,146 @@
+package service_test
+
+import (
+	"context"
+	"maps"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestStartLimitHit(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{
+		"nginx.service": "failed",
+		"mysql.service": "failed",
+		"tmp.mount":     "active",
+	})
+	conn.Properties["nginx.service"] = map[string]any{"Result": "start-limit-hit"}
+	conn.Properties["mysql.service"] = map[string]any{"Result": "exit-code"}
+
+	for unit, want := range map[string]bool{"nginx.service": true, "mysql.service": false, "tmp.mount": false} {
+		hit, err := service.StartLimitHit(ctx, conn, unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit != want {
+			t.Errorf("%s: expected %v, got %v", unit, want, hit)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed", "tmp.mount": "active"})
+	started := time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	conn.Properties["nginx.service"] = map[string]any{
+		"NRestarts":              uint64(3),
+		"ExecMainStartTimestamp": uint64(started.UnixMicro()),
+		"MemoryCurrent":          uint64(math.MaxUint64),
+	}
+
+	before, err := service.Snapshot(ctx, conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := service.UnitSnapshot{
+		"ActiveState":            "failed",
+		"SubState":               "failed",
+		"NRestarts":              "3",
+		"ExecMainStartTimestamp": "2026-10-17T02:00:00Z",
+		"MemoryCurrent":          "[not set]",
+	}
+	if !maps.Equal(before, want) {
+		t.Errorf("unexpected snapshot: %v", before)
+	}
+
+	if _, err := conn.RestartUnitContext(ctx, "nginx.service", "replace", nil); err != nil {
+		t.Fatal(err)
+	}
+	conn.Properties["nginx.service"]["NRestarts"] = uint64(4)
+	after, err := service.Snapshot(ctx, conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := before.Diff(after)
+	wantDiff := map[string]string{"ActiveState": "failed -> active", "SubState": "failed -> running", "NRestarts": "3 -> 4"}
+	if !maps.Equal(diff, wantDiff) {
+		t.Errorf("unexpected diff: %v", diff)
+	}
+
+	// only services have the Service interface properties
+	snap, err := service.Snapshot(ctx, conn, "tmp.mount")
+	if err != nil || len(snap) != 2 || snap["ActiveState"] != "active" {
+		t.Errorf("unexpected mount snapshot %v: %v", snap, err)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	conn := servicetest.NewConn(map[string]string{"mysql.service": "active"})
+	conn.Properties["mysql.service"] = map[string]any{"Id": "mariadb.service"}
+
+	name, err := service.CanonicalName(context.Background(), conn, "mysql.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "mariadb.service" {
+		t.Errorf("expected mariadb.service, got %s", name)
+	}
+}
+
+func TestActiveSince(t *testing.T) {
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "active", "mysql.service": "inactive"})
+	started := time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+	conn.Properties["nginx.service"] = map[string]any{"ActiveEnterTimestamp": uint64(started.UnixMicro())}
+	conn.Properties["mysql.service"] = map[string]any{"ActiveEnterTimestamp": uint64(0)}
+
+	since, err := service.ActiveSince(context.Background(), conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.Equal(started) {
+		t.Errorf("expected %s, got %s", started, since)
+	}
+
+	since, err = service.ActiveSince(context.Background(), conn, "mysql.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.IsZero() {
+		t.Errorf("expected zero time for never started unit, got %s", since)
+	}
+}
+
+func TestUnmask(t *testing.T) {
+	ctx := context.Background()
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "inactive"})
+	conn.Units[0].LoadState = "masked"
+
+	masked, err := service.Masked(ctx, conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !masked {
+		t.Fatal("expected masked unit")
+	}
+
+	if err := service.Unmask(ctx, conn, []string{"nginx.service"}); err != nil {
+		t.Fatal(err)
+	}
+
+	masked, err = service.Masked(ctx, conn, "nginx.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masked {
+		t.Error("unit is still masked")
+	}
+
+	calls := conn.Calls()
+	if len(calls) != 2 || calls[0].Method != "UnmaskUnitFiles" || calls[1].Method != "Reload" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
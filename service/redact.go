@@ -0,0 +1,109 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redacted replaces hidden values
+const redacted = "***"
+
+// minSecretLen keeps very short values, which would mangle the output, from being registered
+const minSecretLen = 4
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// redactPatterns hide credentials recognizable by their shape, values stop at quotes and separators to keep JSON intact
+	redactPatterns = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		// key=value and "key": "value" pairs whose key ends with a credential word, e.g. DB_PASSWORD=x
+		{regexp.MustCompile(`(?i)\b([\w.-]*(?:password|passwd|secret|token|api[_-]?key|private[_-]?key|access[_-]?key|credentials?)(?:=["']?|["']\s*:\s*["']?))[^\s"'&,;]+`), "${1}" + redacted},
+		{regexp.MustCompile(`(?i)(authorization:\s*)(?:(?:key|bearer|basic|token)\s+)?[^\s"',;]+`), "${1}" + redacted},
+		{regexp.MustCompile(`(://[^/\s:@"']+:)[^@\s"']+@`), "${1}" + redacted + "@"},
+		{regexp.MustCompile(`\S*/ssh-tun\d+/(?:id|id-cert\.pub|askpass)\b`), redacted},
+		// ssh -i <keyfile>
+		{regexp.MustCompile(`(\bssh\s(?:[^\n]*?\s)?-i\s+)[^\s"']+`), "${1}" + redacted},
+		{regexp.MustCompile(`(?i)((?:identity|certificate)file[=\s]+)[^\s"']+`), "${1}" + redacted},
+	}
+)
+
+// RegisterSecret adds values, like passwords, tokens and key paths, to be hidden by Redact
+func RegisterSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) >= minSecretLen && !slices.Contains(secrets, v) {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact hides registered secrets and credential-looking values in s
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	secretsMu.RUnlock()
+
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+
+	return s
+}
+
+// RedactingWriter redacts whole lines written to w. Flush writes out the last incomplete line.
+type RedactingWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewRedactingWriter wraps w
+func NewRedactingWriter(w io.Writer) *RedactingWriter {
+	return &RedactingWriter{w: w}
+}
+
+func (r *RedactingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(r.buf.Bytes(), '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+
+		line := string(r.buf.Next(idx + 1))
+		_, err := io.WriteString(r.w, Redact(line))
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes the buffered incomplete line
+func (r *RedactingWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(r.w, Redact(r.buf.String()))
+	r.buf.Reset()
+	return err
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("hunter22", "ab")
+
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"ssh -nNT -p 22 -i /etc/sensu/id_ed25519 -o IdentitiesOnly=yes", "ssh -nNT -p 22 -i *** -o IdentitiesOnly=yes"},
+		{"debug1: Will attempt key: /tmp/ssh-tun123/id ED25519 explicit", "debug1: Will attempt key: *** ED25519 explicit"},
+		{"login with hunter22 failed", "login with *** failed"},
+		{"curl -H 'Authorization: Bearer abc.def' https://u:pw@lb/drain", "curl -H 'Authorization: ***' https://u:***@lb/drain"},
+		{`{"error":"env DB_PASSWORD=s3cret sh -c x","unit":"ab.service"}`, `{"error":"env DB_PASSWORD=*** sh -c x","unit":"ab.service"}`},
+		{`{"token": "abc.def", "unit": "nginx.service"}`, `{"token": "***", "unit": "nginx.service"}`},
+		{"restart nginx.service: done", "restart nginx.service: done"},
+		{"ExecStartPre=/bin/sh -c 'grep -i foo /etc/motd'", "ExecStartPre=/bin/sh -c 'grep -i foo /etc/motd'"},
+		{"passwd-sync.service: job done", "passwd-sync.service: job done"},
+		{"secrets-rotate.service: Main process exited", "secrets-rotate.service: Main process exited"},
+		{"invalid token: expired", "invalid token: expired"},
+	} {
+		if got := Redact(tc.in); got != tc.out {
+			t.Errorf("Redact(%q):\n got %q\nwant %q", tc.in, got, tc.out)
+		}
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRedactingWriter(&buf)
+
+	w.Write([]byte("api_tok"))          //nolint:errcheck
+	w.Write([]byte("en=abcdef\ntail ")) //nolint:errcheck
+	w.Write([]byte("secret=xyz"))       //nolint:errcheck
+	if buf.String() != "api_token=***\n" {
+		t.Errorf("unexpected output before flush: %q", buf.String())
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "api_token=***\ntail secret=***" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
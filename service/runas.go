@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// lookupCredential resolves the local account (name or uid) ssh processes are run as
+func lookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if _, ok := err.(user.UnknownUserError); ok {
+		u, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("run-as user %s: %w", name, err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run-as user %s: uid %q: %w", name, u.Uid, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run-as user %s: gid %q: %w", name, u.Gid, err)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+
+	gids, err := u.GroupIds()
+	if err == nil {
+		for _, g := range gids {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(id))
+			}
+		}
+	}
+
+	return cred, nil
+}
+
+// own hands the tunnel file over to the run-as user, so its ssh can use it
+func (t *DBusTunnel) own(path string) error {
+	if t.cred == nil {
+		return nil
+	}
+
+	return os.Chown(path, int(t.cred.Uid), int(t.cred.Gid))
+}
+
+// writePrivate writes the file into the tunnel temp dir, readable only by the ssh user
+func (t *DBusTunnel) writePrivate(name string, data []byte, perm os.FileMode) (string, error) {
+	path := filepath.Join(t.tmpdir, name)
+
+	err := os.WriteFile(path, data, perm)
+	if err != nil {
+		return "", err
+	}
+
+	return path, t.own(path)
+}
+
+// sysProcAttr returns ssh process attributes, running it as the run-as user when configured
+func (t *DBusTunnel) sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Pdeathsig:  syscall.SIGTERM,
+		Credential: t.cred,
+	}
+}
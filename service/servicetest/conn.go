@@ -0,0 +1,433 @@
+// Package servicetest provides an in-memory systemd connection for tests
+package servicetest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+var (
+	_ service.SystemdConnection = (*Conn)(nil)
+	_ service.JobCanceler       = (*Conn)(nil)
+	_ service.UnitFilePresetter = (*Conn)(nil)
+	_ service.UnitFileReverter  = (*Conn)(nil)
+)
+
+// Call is a recorded unit method call
+type Call struct {
+	Method string
+	Unit   string
+	Mode   string
+}
+
+// Conn is an in-memory service.SystemdConnection.
+// Jobs complete immediately with the JobResults value of the unit, "done" by default.
+type Conn struct {
+	mu sync.Mutex
+
+	// Units is the unit list, jobs update ActiveState and SubState of the units
+	Units []dbus.UnitStatus
+	// Properties holds extra unit properties by unit name
+	Properties map[string]map[string]any
+	// Manager holds manager properties, e.g. Version
+	Manager map[string]any
+	// JobResults overrides job result by unit name
+	JobResults map[string]string
+	// Errors makes unit methods fail by unit name
+	Errors map[string]error
+	// Jobs is the pending job list, CancelJob removes from it
+	Jobs []dbus.JobStatus
+	// Presets is the preset policy by unit name, "enabled" or "disabled"
+	Presets map[string]string
+
+	calls []Call
+	jobID int
+}
+
+// NewConn makes connection with the units in the given active states, e.g. "nginx.service": "failed"
+func NewConn(units map[string]string) *Conn {
+	c := &Conn{
+		Properties: make(map[string]map[string]any),
+		Manager:    map[string]any{"Version": "252", "Virtualization": "", "SystemState": "running"},
+		JobResults: make(map[string]string),
+		Errors:     make(map[string]error),
+	}
+
+	for name, state := range units {
+		c.Units = append(c.Units, dbus.UnitStatus{
+			Name:        name,
+			LoadState:   "loaded",
+			ActiveState: state,
+			SubState:    subState(state),
+		})
+	}
+
+	return c
+}
+
+// Calls returns recorded unit method calls
+func (c *Conn) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+}
+
+func subState(active string) string {
+	switch active {
+	case "active":
+		return "running"
+	case "failed":
+		return "failed"
+	default:
+		return "dead"
+	}
+}
+
+func (c *Conn) unit(name string) *dbus.UnitStatus {
+	for i := range c.Units {
+		if c.Units[i].Name == name {
+			return &c.Units[i]
+		}
+	}
+	return nil
+}
+
+// job records the call and completes the job, the unit ends in the state if the job is done
+func (c *Conn) job(ctx context.Context, method, name, mode, state string, ch chan<- string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, Call{Method: method, Unit: name, Mode: mode})
+	if err := c.Errors[name]; err != nil {
+		return 0, err
+	}
+
+	u := c.unit(name)
+	if u == nil {
+		return 0, fmt.Errorf("Unit %s not found.", name)
+	}
+
+	result := "done"
+	if r, ok := c.JobResults[name]; ok {
+		result = r
+	}
+	if result == "done" && state != "" {
+		u.ActiveState = state
+		u.SubState = subState(state)
+	} else if result != "done" {
+		u.ActiveState = "failed"
+		u.SubState = "failed"
+	}
+
+	c.jobID++
+	if ch != nil {
+		go func() { ch <- result }()
+	}
+
+	return c.jobID, nil
+}
+
+func (c *Conn) StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "StartUnit", name, mode, "active", ch)
+}
+
+func (c *Conn) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "StopUnit", name, mode, "inactive", ch)
+}
+
+func (c *Conn) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "RestartUnit", name, mode, "active", ch)
+}
+
+func (c *Conn) ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadUnit", name, mode, "", ch)
+}
+
+func (c *Conn) TryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "TryRestartUnit", name, mode, "", ch)
+}
+
+func (c *Conn) ReloadOrRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadOrRestartUnit", name, mode, "active", ch)
+}
+
+func (c *Conn) ReloadOrTryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadOrTryRestartUnit", name, mode, "", ch)
+}
+
+func (c *Conn) ResetFailedUnitContext(ctx context.Context, name string) error {
+	_, err := c.job(ctx, "ResetFailedUnit", name, "", "", nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if u := c.unit(name); u.ActiveState == "failed" {
+		u.ActiveState = "inactive"
+		u.SubState = "dead"
+	}
+	return nil
+}
+
+func (c *Conn) SetUnitPropertiesContext(ctx context.Context, name string, runtime bool, properties ...dbus.Property) error {
+	_, err := c.job(ctx, "SetUnitProperties", name, "", "", nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Properties[name] == nil {
+		c.Properties[name] = make(map[string]any)
+	}
+	for _, p := range properties {
+		c.Properties[name][p.Name] = p.Value.Value()
+	}
+	return nil
+}
+
+// ListJobsContext returns Jobs, jobs made by unit methods complete immediately
+func (c *Conn) ListJobsContext(ctx context.Context) ([]dbus.JobStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]dbus.JobStatus(nil), c.Jobs...), nil
+}
+
+func (c *Conn) CancelJob(ctx context.Context, id uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, job := range c.Jobs {
+		if job.Id == id {
+			c.calls = append(c.calls, Call{Method: "CancelJob", Unit: job.Unit})
+			c.Jobs = append(c.Jobs[:i], c.Jobs[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Job %d does not exist.", id)
+}
+
+// ReloadContext records daemon-reload and clears NeedDaemonReload of all units
+func (c *Conn) ReloadContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, Call{Method: "Reload"})
+	for _, props := range c.Properties {
+		if _, ok := props["NeedDaemonReload"]; ok {
+			props["NeedDaemonReload"] = false
+		}
+	}
+	return nil
+}
+
+// PresetUnitFiles sets UnitFileState of the units to their Presets value
+func (c *Conn) PresetUnitFiles(ctx context.Context, files []string) ([]dbus.EnableUnitFileChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var changes []dbus.EnableUnitFileChange
+	for _, name := range files {
+		c.calls = append(c.calls, Call{Method: "PresetUnitFiles", Unit: name})
+		if err := c.Errors[name]; err != nil {
+			return nil, err
+		}
+
+		preset, ok := c.Presets[name]
+		if !ok {
+			continue
+		}
+		if c.Properties[name] == nil {
+			c.Properties[name] = make(map[string]any)
+		}
+		if c.Properties[name]["UnitFileState"] == preset {
+			continue
+		}
+
+		c.Properties[name]["UnitFileState"] = preset
+		link := "/etc/systemd/system/multi-user.target.wants/" + name
+		if preset == "enabled" {
+			changes = append(changes, dbus.EnableUnitFileChange{Type: "symlink", Filename: link, Destination: "/usr/lib/systemd/system/" + name})
+		} else {
+			changes = append(changes, dbus.EnableUnitFileChange{Type: "unlink", Filename: link})
+		}
+	}
+	return changes, nil
+}
+
+// RevertUnitFiles drops DropInPaths of the units
+func (c *Conn) RevertUnitFiles(ctx context.Context, files []string) ([]dbus.EnableUnitFileChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var changes []dbus.EnableUnitFileChange
+	for _, name := range files {
+		c.calls = append(c.calls, Call{Method: "RevertUnitFiles", Unit: name})
+		if err := c.Errors[name]; err != nil {
+			return nil, err
+		}
+
+		dropIns, _ := c.Properties[name]["DropInPaths"].([]string)
+		for _, path := range dropIns {
+			changes = append(changes, dbus.EnableUnitFileChange{Type: "unlink", Filename: path})
+		}
+		if len(dropIns) > 0 {
+			c.Properties[name]["DropInPaths"] = []string{}
+		}
+	}
+	return changes, nil
+}
+
+// UnmaskUnitFilesContext turns masked units into loaded ones
+func (c *Conn) UnmaskUnitFilesContext(ctx context.Context, files []string, runtime bool) ([]dbus.UnmaskUnitFileChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var changes []dbus.UnmaskUnitFileChange
+	for _, name := range files {
+		c.calls = append(c.calls, Call{Method: "UnmaskUnitFiles", Unit: name})
+		if u := c.unit(name); u != nil && u.LoadState == "masked" {
+			u.LoadState = "loaded"
+			changes = append(changes, dbus.UnmaskUnitFileChange{Type: "unlink", Filename: "/run/systemd/system/" + name})
+		}
+	}
+	return changes, nil
+}
+
+func (c *Conn) ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error) {
+	return c.ListUnitsByPatternsContext(ctx, nil, nil)
+}
+
+func (c *Conn) ListUnitsFilteredContext(ctx context.Context, states []string) ([]dbus.UnitStatus, error) {
+	return c.ListUnitsByPatternsContext(ctx, states, nil)
+}
+
+func (c *Conn) ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]dbus.UnitStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var units []dbus.UnitStatus
+	for _, u := range c.Units {
+		if len(patterns) > 0 && !matchAny(patterns, u.Name) {
+			continue
+		}
+		if len(states) > 0 && !matchAny(states, u.LoadState) && !matchAny(states, u.ActiveState) && !matchAny(states, u.SubState) {
+			continue
+		}
+		units = append(units, u)
+	}
+
+	return units, nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conn) GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	return c.GetUnitTypePropertiesContext(ctx, unit, "Unit")
+}
+
+func (c *Conn) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u := c.unit(unit)
+	if u == nil {
+		return nil, fmt.Errorf("Unit %s not found.", unit)
+	}
+
+	props := make(map[string]interface{})
+	if unitType == "Unit" {
+		props["Id"] = u.Name
+		props["LoadState"] = u.LoadState
+		props["ActiveState"] = u.ActiveState
+		props["SubState"] = u.SubState
+		props["NeedDaemonReload"] = false
+	}
+	for k, v := range c.Properties[unit] {
+		props[k] = v
+	}
+
+	return props, nil
+}
+
+func (c *Conn) GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error) {
+	props, err := c.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := props[propertyName]
+	if !ok {
+		return nil, fmt.Errorf("unknown property %s", propertyName)
+	}
+
+	return &dbus.Property{Name: propertyName, Value: godbus.MakeVariant(v)}, nil
+}
+
+func (c *Conn) GetManagerProperty(prop string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.Manager[prop]
+	if !ok {
+		return "", fmt.Errorf("unknown property %s", prop)
+	}
+
+	return godbus.MakeVariant(v).String(), nil
+}
@@ -0,0 +1,436 @@
+package servicetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	systemdPath  = godbus.ObjectPath("/org/freedesktop/systemd1")
+	managerIface = "org.freedesktop.systemd1.Manager"
+	busPath      = godbus.ObjectPath("/org/freedesktop/DBus")
+	busIface     = "org.freedesktop.DBus"
+	propsIface   = "org.freedesktop.DBus.Properties"
+)
+
+// Server is a minimal fake org.freedesktop.systemd1 speaking D-Bus on a unix socket.
+// It answers Introspect, manager properties, ListUnits*, unit job methods and emits JobRemoved,
+// unit state lives in Conn.
+type Server struct {
+	Conn *Conn
+
+	dir    string
+	ln     net.Listener
+	hidden map[string]bool
+
+	mu    sync.Mutex
+	conns []*godbus.Conn
+	wg    sync.WaitGroup
+}
+
+// NewServer starts the server backed by the conn state.
+// Hidden methods are not introspected and not answered, to mimic older systemd.
+func NewServer(conn *Conn, hidden ...string) (*Server, error) {
+	dir, err := os.MkdirTemp("", "fake-systemd*")
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "private"))
+	if err != nil {
+		os.RemoveAll(dir) //nolint:errcheck
+		return nil, err
+	}
+
+	s := &Server{
+		Conn:   conn,
+		dir:    dir,
+		ln:     ln,
+		hidden: make(map[string]bool),
+	}
+	for _, m := range hidden {
+		s.hidden[m] = true
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the socket path
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Dial makes raw authenticated connection to the server
+func (s *Server) Dial() (*godbus.Conn, error) {
+	conn, err := godbus.Dial("unix:path=" + s.Addr())
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Auth([]godbus.Auth{godbus.AuthExternal("0")})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// NewSystemdConn makes go-systemd connection to the server
+func (s *Server) NewSystemdConn() (*dbus.Conn, error) {
+	return dbus.NewConnection(s.Dial)
+}
+
+// Close stops the server and drops all connections
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	os.RemoveAll(s.dir) //nolint:errcheck
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(nc)
+		}()
+	}
+}
+
+// handle authenticates the client and serves it with a godbus connection.
+// godbus only implements the client side of SASL, so the server side connection
+// talks over a pipe which is authenticated the same way.
+func (s *Server) handle(nc net.Conn) {
+	defer nc.Close()
+
+	in := bufio.NewReader(nc)
+	if err := serverAuth(in, nc); err != nil {
+		return
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		rin := bufio.NewReader(remote)
+		if err := serverAuth(rin, remote); err != nil {
+			remote.Close()
+			return
+		}
+
+		go func() {
+			io.Copy(remote, in) //nolint:errcheck
+			remote.Close()
+		}()
+		io.Copy(nc, rin) //nolint:errcheck
+		nc.Close()
+	}()
+
+	conn, err := godbus.NewConn(local)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Auth([]godbus.Auth{godbus.AuthExternal("0")})
+	if err != nil {
+		return
+	}
+
+	err = s.export(conn)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	<-conn.Context().Done()
+}
+
+// serverAuth answers client SASL: EXTERNAL is accepted, unix fd passing is refused
+func serverAuth(in *bufio.Reader, out io.Writer) error {
+	b, err := in.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != 0 {
+		return fmt.Errorf("expected nul byte")
+	}
+
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return fmt.Errorf("empty auth line")
+		}
+
+		var reply string
+		switch {
+		case fields[0] == "AUTH" && len(fields) > 1 && fields[1] == "EXTERNAL":
+			reply = "OK 0123456789abcdef0123456789abcdef"
+		case fields[0] == "AUTH":
+			reply = "REJECTED EXTERNAL"
+		case fields[0] == "NEGOTIATE_UNIX_FD":
+			reply = "ERROR"
+		case fields[0] == "BEGIN":
+			return nil
+		default:
+			reply = "ERROR"
+		}
+
+		_, err = fmt.Fprintf(out, "%s\r\n", reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) export(conn *godbus.Conn) error {
+	m := &manager{s: s}
+	err := conn.ExportWithMap(m, s.methodMap(m), systemdPath, managerIface)
+	if err != nil {
+		return err
+	}
+
+	node := &introspect.Node{
+		Name: string(systemdPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{Name: managerIface, Methods: s.methods(m)},
+		},
+	}
+	err = conn.Export(introspect.NewIntrospectable(node), systemdPath, "org.freedesktop.DBus.Introspectable")
+	if err != nil {
+		return err
+	}
+
+	err = conn.Export(properties{s.Conn}, systemdPath, propsIface)
+	if err != nil {
+		return err
+	}
+
+	return conn.Export(bus{}, busPath, busIface)
+}
+
+func (s *Server) methods(m *manager) []introspect.Method {
+	var out []introspect.Method
+	for _, method := range introspect.Methods(m) {
+		if !s.hidden[method.Name] {
+			out = append(out, method)
+		}
+	}
+	return out
+}
+
+func (s *Server) methodMap(m *manager) map[string]string {
+	mapping := make(map[string]string)
+	for _, method := range introspect.Methods(m) {
+		if s.hidden[method.Name] {
+			// map the method to nonexistent name to hide it
+			mapping[method.Name] = "_" + method.Name
+		}
+	}
+	return mapping
+}
+
+// emit sends the signal to all connections, go-systemd listens for jobs on a separate one
+func (s *Server) emit(name string, values ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Emit(systemdPath, managerIface+"."+name, values...) //nolint:errcheck
+	}
+}
+
+// bus answers the message bus calls clients do on connect
+type bus struct{}
+
+func (bus) Hello() (string, *godbus.Error) {
+	return ":1.1", nil
+}
+
+func (bus) AddMatch(_ string) *godbus.Error {
+	return nil
+}
+
+func (bus) RemoveMatch(_ string) *godbus.Error {
+	return nil
+}
+
+// properties answers Properties.Get of the manager from Conn.Manager
+type properties struct {
+	c *Conn
+}
+
+func (p properties) Get(iface, name string) (godbus.Variant, *godbus.Error) {
+	if iface != managerIface {
+		return godbus.Variant{}, godbus.MakeFailedError(fmt.Errorf("unknown interface %s", iface))
+	}
+
+	p.c.mu.Lock()
+	defer p.c.mu.Unlock()
+
+	v, ok := p.c.Manager[name]
+	if !ok {
+		return godbus.Variant{}, godbus.MakeFailedError(fmt.Errorf("unknown property %s", name))
+	}
+	return godbus.MakeVariant(v), nil
+}
+
+// unitTuple is the ListUnits reply item, signature (ssssssouso)
+type unitTuple struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Followed    string
+	Path        godbus.ObjectPath
+	JobID       uint32
+	JobType     string
+	JobPath     godbus.ObjectPath
+}
+
+// manager exports org.freedesktop.systemd1.Manager methods
+type manager struct {
+	s *Server
+}
+
+func dbusError(err error) *godbus.Error {
+	if err == nil {
+		return nil
+	}
+	return godbus.MakeFailedError(err)
+}
+
+func tuples(units []dbus.UnitStatus, err error) ([]unitTuple, *godbus.Error) {
+	if err != nil {
+		return nil, dbusError(err)
+	}
+
+	out := make([]unitTuple, 0, len(units))
+	for _, u := range units {
+		out = append(out, unitTuple{
+			Name:        u.Name,
+			Description: u.Description,
+			LoadState:   u.LoadState,
+			ActiveState: u.ActiveState,
+			SubState:    u.SubState,
+			Path:        unitPath(u.Name),
+			JobPath:     "/",
+		})
+	}
+	return out, nil
+}
+
+func unitPath(name string) godbus.ObjectPath {
+	var b bytes.Buffer
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return systemdPath + "/unit/" + godbus.ObjectPath(b.String())
+}
+
+func (m *manager) ListUnits() ([]unitTuple, *godbus.Error) {
+	return tuples(m.s.Conn.ListUnitsContext(context.Background()))
+}
+
+func (m *manager) ListUnitsFiltered(states []string) ([]unitTuple, *godbus.Error) {
+	return tuples(m.s.Conn.ListUnitsFilteredContext(context.Background(), states))
+}
+
+func (m *manager) ListUnitsByPatterns(states []string, patterns []string) ([]unitTuple, *godbus.Error) {
+	return tuples(m.s.Conn.ListUnitsByPatternsContext(context.Background(), states, patterns))
+}
+
+type jobMethod func(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+
+// job runs the job on the Conn and emits JobRemoved with its result
+func (m *manager) job(fn jobMethod, name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	ch := make(chan string, 1)
+	id, err := fn(context.Background(), name, mode, ch)
+	if err != nil {
+		return "", dbusError(err)
+	}
+
+	path := godbus.ObjectPath(fmt.Sprintf("%s/job/%d", systemdPath, id))
+	go func() {
+		result := <-ch
+		m.s.emit("JobRemoved", uint32(id), path, name, result)
+	}()
+
+	return path, nil
+}
+
+func (m *manager) StartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.StartUnitContext, name, mode)
+}
+
+func (m *manager) StopUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.StopUnitContext, name, mode)
+}
+
+func (m *manager) RestartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.RestartUnitContext, name, mode)
+}
+
+func (m *manager) ReloadUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.ReloadUnitContext, name, mode)
+}
+
+func (m *manager) TryRestartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.TryRestartUnitContext, name, mode)
+}
+
+func (m *manager) ReloadOrRestartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.ReloadOrRestartUnitContext, name, mode)
+}
+
+func (m *manager) ReloadOrTryRestartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return m.job(m.s.Conn.ReloadOrTryRestartUnitContext, name, mode)
+}
+
+func (m *manager) ResetFailedUnit(name string) *godbus.Error {
+	return dbusError(m.s.Conn.ResetFailedUnitContext(context.Background(), name))
+}
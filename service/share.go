@@ -0,0 +1,217 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+const (
+	// leaseFile is locked shared by every handler using the tunnel, the owner included; the last one tears it down
+	leaseFile = "lease"
+	// gateFile serializes handlers releasing their lease, so exactly one of them finds itself the last
+	gateFile = "gate"
+	// keyFile holds the credential key of the tunnel, a borrower must present the same credentials
+	keyFile = "key"
+	// shareLinkPrefix names the link to the live tunnel dir of a target, it does not clash with MkdirTemp names
+	shareLinkPrefix = "ssh-tun-"
+)
+
+// shareLink returns the path of the link published for the tunnel target and socket
+func shareLink(cfg DBusTunnelConfig) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s@%s:%d\x00%s\x00%s\x00%s", cfg.User, cfg.SSHHost, cfg.SSHPort, cfg.RemoteSocket, cfg.RunAs, cfg.IdentityFile)))
+	return filepath.Join(tunnelBase(), shareLinkPrefix+hex.EncodeToString(sum[:8]))
+}
+
+// credentialKey completes the share key with the credentials. It is kept in the private tunnel dir,
+// not in the world-visible link name, where a short hash of a password could be brute forced.
+func credentialKey(cfg DBusTunnelConfig) []byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s", cfg.IdentityFile, cfg.PrivateKey, cfg.Certificate, cfg.Password)))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// borrowTunnel returns the live tunnel another handler published for the same target, socket and credentials,
+// nil if there is none. The tunnel stays up until the last handler using it closes it.
+func borrowTunnel(ctx context.Context, cfg DBusTunnelConfig) *DBusTunnel {
+	link := shareLink(cfg)
+	owners := tunnelOwners(cfg.RunAs)
+
+	dir, err := os.Readlink(link)
+	if err != nil || !ownedBy(link, owners) || !privateDir(dir, owners) {
+		return nil
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, keyFile))
+	if err != nil || !bytes.Equal(key, credentialKey(cfg)) {
+		return nil
+	}
+
+	lease, err := os.Open(filepath.Join(dir, leaseFile))
+	if err != nil {
+		return nil
+	}
+
+	// the owner holds the lock exclusively while tearing the tunnel down
+	err = syscall.Flock(int(lease.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		if cur, _ := os.Readlink(link); cur != dir || !tunnelAlive(dir) {
+			err = fmt.Errorf("tunnel gone")
+		}
+	}
+	if err != nil {
+		lease.Close()
+		return nil
+	}
+
+	tctx, cf := context.WithCancel(context.WithoutCancel(ctx))
+
+	t := &DBusTunnel{
+		ctx:      tctx,
+		ctxCf:    cf,
+		cfg:      cfg,
+		tmpdir:   dir,
+		lsock:    filepath.Join(dir, "dbus.sock"),
+		ctlsock:  filepath.Join(dir, "ctl.sock"),
+		lease:    lease,
+		borrowed: true,
+	}
+
+	if cfg.RunAs != "" {
+		t.cred, err = lookupCredential(cfg.RunAs)
+		if err != nil {
+			t.Close()
+			return nil
+		}
+	}
+
+	return t
+}
+
+// Reused reports whether the tunnel was published by another handler
+func (t *DBusTunnel) Reused() bool {
+	return t.borrowed
+}
+
+// publish makes the tunnel available to other handlers acting on the same target
+func (t *DBusTunnel) publish() error {
+	_, err := t.writePrivate(keyFile, credentialKey(t.cfg), 0o600)
+	if err != nil {
+		return err
+	}
+
+	lease, err := os.OpenFile(filepath.Join(t.tmpdir, leaseFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	err = syscall.Flock(int(lease.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err != nil {
+		lease.Close()
+		return err
+	}
+	t.lease = lease
+
+	link := shareLink(t.cfg)
+	tmp := fmt.Sprintf("%s.%d", link, os.Getpid())
+
+	// replace atomically, the latest tunnel wins
+	os.Remove(tmp) //nolint:errcheck
+	err = os.Symlink(t.tmpdir, tmp)
+	if err == nil {
+		err = os.Rename(tmp, link)
+	}
+	if err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return err
+	}
+
+	t.shared = link
+	return nil
+}
+
+// unpublish stops new handlers from reusing the tunnel
+func (t *DBusTunnel) unpublish() {
+	link := shareLink(t.cfg)
+	if dir, err := os.Readlink(link); err == nil && dir == t.tmpdir {
+		os.Remove(link) //nolint:errcheck
+	}
+}
+
+// release drops our lease on the shared tunnel. It returns the lease locked exclusively when we were the
+// last handler using the tunnel, the caller tears it down and closes the lease; otherwise nil.
+func (t *DBusTunnel) release() *os.File {
+	gate, err := os.OpenFile(filepath.Join(t.tmpdir, gateFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.lease.Close()
+		return nil
+	}
+	defer gate.Close()
+	syscall.Flock(int(gate.Fd()), syscall.LOCK_EX) //nolint:errcheck
+
+	t.lease.Close()
+	t.lease = nil
+
+	last, err := os.Open(filepath.Join(t.tmpdir, leaseFile))
+	if err != nil {
+		return nil
+	}
+	if syscall.Flock(int(last.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+		last.Close()
+		return nil
+	}
+
+	return last
+}
+
+// stopMaster asks the ssh master listening on the control socket to exit, used when its owner has gone
+func stopMaster(ctlsock string, cred *syscall.Credential) error {
+	if _, err := os.Stat(ctlsock); err != nil {
+		return nil
+	}
+
+	// the destination is required by ssh but not used with -S
+	cmd := exec.Command("ssh", "-S", ctlsock, "-O", "exit", "tunnel")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tunnel exit error: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// tunnelOwners returns uids tunnel dirs may belong to: ours and the run-as user
+func tunnelOwners(runAs string) []uint32 {
+	owners := []uint32{uint32(os.Getuid())}
+	if runAs != "" {
+		if cred, err := lookupCredential(runAs); err == nil {
+			owners = append(owners, cred.Uid)
+		}
+	}
+	return owners
+}
+
+func ownedBy(path string, owners []uint32) bool {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && slices.Contains(owners, st.Uid)
+}
+
+// privateDir reports whether the dir is a real 0700 directory of the owners, so a planted link is not followed
+func privateDir(dir string, owners []uint32) bool {
+	fi, err := os.Lstat(dir)
+	if err != nil || !fi.IsDir() || fi.Mode().Perm() != 0o700 {
+		return false
+	}
+	return ownedBy(dir, owners)
+}
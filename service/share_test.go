@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOwner makes a published tunnel with a listening socket but no ssh behind it
+func fakeOwner(t *testing.T, cfg DBusTunnelConfig) *DBusTunnel {
+	t.Helper()
+
+	dir, err := makeTunnelDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	l, err := net.Listen("unix", filepath.Join(dir, "dbus.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ctx, cf := context.WithCancel(context.Background())
+	owner := &DBusTunnel{ctx: ctx, ctxCf: cf, cfg: cfg, tmpdir: dir, lsock: filepath.Join(dir, "dbus.sock"), ctlsock: filepath.Join(dir, "ctl.sock")}
+	if err := owner.publish(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if owner.lease != nil {
+			owner.lease.Close()
+		}
+	})
+
+	return owner
+}
+
+func TestBorrowTunnel(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	cfg := DBusTunnelConfig{User: "root", SSHHost: "node1", SSHPort: 22, RemoteSocket: "/run/dbus/system_bus_socket", Password: "hunter22"}
+	owner := fakeOwner(t, cfg)
+
+	for name, change := range map[string]func(*DBusTunnelConfig){
+		"host":          func(c *DBusTunnelConfig) { c.SSHHost = "node2" },
+		"socket":        func(c *DBusTunnelConfig) { c.RemoteSocket = "/run/user/0/bus" },
+		"password":      func(c *DBusTunnelConfig) { c.Password = "hunter23" },
+		"identity file": func(c *DBusTunnelConfig) { c.IdentityFile = "/etc/sensu/id_ed25519" },
+	} {
+		other := cfg
+		change(&other)
+		if borrowTunnel(context.Background(), other) != nil {
+			t.Errorf("tunnel must not be reused with another %s", name)
+		}
+	}
+
+	borrowed := borrowTunnel(context.Background(), cfg)
+	if borrowed == nil {
+		t.Fatal("expected live tunnel to be reused")
+	}
+	if !borrowed.Reused() || borrowed.lsock != owner.lsock {
+		t.Errorf("unexpected borrowed tunnel: %+v", borrowed)
+	}
+
+	// the owner leaves the tunnel to the borrower without waiting
+	start := time.Now()
+	if err := owner.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("owner waited %s for the borrower", d)
+	}
+	if _, err := os.Stat(owner.tmpdir); err != nil {
+		t.Fatalf("owner tore down the tunnel under the borrower: %v", err)
+	}
+	if borrowTunnel(context.Background(), cfg) != nil {
+		t.Error("unpublished tunnel must not be reused")
+	}
+
+	// the last borrower tears it down
+	if err := borrowed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(owner.tmpdir); !os.IsNotExist(err) {
+		t.Errorf("last borrower left the tunnel dir: %v", err)
+	}
+}
+
+func TestReleaseTunnelConcurrently(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	cfg := DBusTunnelConfig{User: "root", SSHHost: "node1", SSHPort: 22}
+	users := []*DBusTunnel{fakeOwner(t, cfg)}
+	for range 8 {
+		b := borrowTunnel(context.Background(), cfg)
+		if b == nil {
+			t.Fatal("expected live tunnel to be reused")
+		}
+		users = append(users, b)
+	}
+
+	var wg sync.WaitGroup
+	var lastCount atomic.Int32
+	for _, u := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if last := u.release(); last != nil {
+				lastCount.Add(1)
+				last.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := lastCount.Load(); n != 1 {
+		t.Errorf("expected exactly one handler to tear the tunnel down, got %d", n)
+	}
+}
+
+func TestBorrowTunnelChecksDir(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	cfg := DBusTunnelConfig{User: "root", SSHHost: "node1", SSHPort: 22}
+	owner := fakeOwner(t, cfg)
+
+	if err := os.Chmod(owner.tmpdir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if borrowTunnel(context.Background(), cfg) != nil {
+		t.Error("tunnel in a non-private dir must not be reused")
+	}
+}
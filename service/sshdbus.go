@@ -1,13 +1,17 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,62 +28,210 @@ type DBusTunnelConfig struct {
 	SSHPort      int
 	RemoteSocket string
 	SSHVerbose   bool
+	IdentityFile string
+	PrivateKey   string
+	Certificate  string
+	Password     string
+	DBusDebug    bool
+	// RunAs is the local account (name or uid) ssh runs as, empty to run as the handler user
+	RunAs string
+	// Reuse enables reusing a live tunnel of another handler to the same target, and sharing ours
+	Reuse bool
 }
 
 // DBusTunnel makes a tunnel socket->local-tcp
 type DBusTunnel struct {
-	ctx    context.Context
-	ctxCf  context.CancelFunc
-	cfg    DBusTunnelConfig
-	cmd    *exec.Cmd
-	tmpdir string
-	lsock  string
+	ctx     context.Context
+	ctxCf   context.CancelFunc
+	cfg     DBusTunnelConfig
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	waitErr error
+	tmpdir  string
+	lsock   string
+	ctlsock string
+	cred    *syscall.Credential
+
+	// lease, shared and borrowed track the tunnel reuse between handlers, see share.go
+	lease    *os.File
+	shared   string
+	borrowed bool
+
+	counters transportCounters
+
+	mu    sync.Mutex
+	conns map[*systemdDBus.Conn][]*dbus.Conn
 }
 
-// NewDBusTunnel creates dbus socket tunnel
+// NewDBusTunnel creates dbus socket tunnel, or reuses the live one another handler opened to the same target.
+// The context bounds only the connection setup, the tunnel lives until Close.
 func NewDBusTunnel(ctx context.Context, tunnelConfig DBusTunnelConfig) (*DBusTunnel, error) {
-	tempDir, err := os.MkdirTemp("", "ssh-tun*")
+	RegisterSecret(tunnelConfig.Password, tunnelConfig.PrivateKey, tunnelConfig.IdentityFile)
+
+	if tunnelConfig.Reuse {
+		if t := borrowTunnel(ctx, tunnelConfig); t != nil {
+			return t, nil
+		}
+	}
+
+	tempDir, err := makeTunnelDir()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("tunnel dir error: %w", err)
 	}
 
 	lsock := filepath.Join(tempDir, "dbus.sock")
 
-	ctx, cf := context.WithCancel(ctx)
+	tctx, cf := context.WithCancel(context.WithoutCancel(ctx))
 
 	t := &DBusTunnel{
-		ctx:    ctx,
-		ctxCf:  cf,
-		cfg:    tunnelConfig,
-		tmpdir: tempDir,
-		lsock:  lsock,
+		ctx:     tctx,
+		ctxCf:   cf,
+		cfg:     tunnelConfig,
+		tmpdir:  tempDir,
+		lsock:   lsock,
+		ctlsock: filepath.Join(tempDir, "ctl.sock"),
+	}
+
+	if tunnelConfig.RunAs != "" {
+		t.cred, err = lookupCredential(tunnelConfig.RunAs)
+		if err == nil {
+			err = t.own(tempDir)
+		}
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
 	}
 
-	err = t.run()
+	err = t.run(ctx)
 	if err != nil {
 		t.Close()
 		return nil, err
 	}
 
+	if tunnelConfig.Reuse {
+		err = t.publish()
+		if err != nil {
+			slog.Warn("Tunnel is not shared with other handlers", "host", tunnelConfig.SSHHost, "error", err)
+		}
+	}
+
 	return t, nil
 }
 
-// New makes d-bus connection to remote systemd
+// New makes d-bus connection to remote systemd, it must be closed with CloseConn
 func (t *DBusTunnel) New() (*systemdDBus.Conn, error) {
-	return systemdDBus.NewConnection(
+	var raw []*dbus.Conn
+	conn, err := systemdDBus.NewConnection(
 		func() (*dbus.Conn, error) {
-			return dbusAuthConnection(t.ctx, t.NewDBusConn)
+			c, err := t.dial()
+			if err == nil {
+				raw = append(raw, c)
+			}
+			return c, err
 		})
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[*systemdDBus.Conn][]*dbus.Conn)
+	}
+	t.conns[conn] = raw
+
+	return conn, nil
 }
 
-// NewDBusConn makes raw d-bus connection to the remote systemd
+// CloseConn closes the connection made by New and reports close errors, which systemd Conn.Close swallows
+func (t *DBusTunnel) CloseConn(conn *systemdDBus.Conn) error {
+	t.mu.Lock()
+	raw, ok := t.conns[conn]
+	delete(t.conns, conn)
+	t.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	var err error
+	for _, c := range raw {
+		err = multierr.Append(err, c.Close())
+	}
+
+	return err
+}
+
+// dial makes authenticated raw d-bus connection
+func (t *DBusTunnel) dial() (*dbus.Conn, error) {
+	out, in := t.counters.interceptors()
+	if t.cfg.DBusDebug {
+		out = chainInterceptors(out, debugInterceptor("out"))
+		in = chainInterceptors(in, debugInterceptor("in"))
+	}
+	opts := []dbus.ConnOption{
+		dbus.WithContext(t.ctx),
+		dbus.WithOutgoingInterceptor(out),
+		dbus.WithIncomingInterceptor(in),
+	}
+
+	return dbusAuthConnection(t.NewDBusConn, opts...)
+}
+
+// NewDBusConn makes raw d-bus connection to the remote systemd, counting the bytes transferred
 func (t *DBusTunnel) NewDBusConn(opts ...dbus.ConnOption) (*dbus.Conn, error) {
-	return dbus.Dial(fmt.Sprintf("unix:path=%s", t.lsock), opts...)
+	c, err := net.Dial("unix", t.lsock)
+	if err != nil {
+		return nil, err
+	}
+
+	// no unix fd passing over ssh anyway
+	return dbus.NewConn(countingConn{Conn: c, c: &t.counters}, opts...)
+}
+
+// CommandRunner runs shell commands on the remote host
+type CommandRunner interface {
+	RunCommand(ctx context.Context, command string) ([]byte, error)
+}
+
+var _ CommandRunner = (*DBusTunnel)(nil)
+
+// RunCommand executes shell command on the remote host reusing the tunnel SSH connection
+func (t *DBusTunnel) RunCommand(ctx context.Context, command string) ([]byte, error) {
+	args := []string{
+		"-T",
+		"-S", t.ctlsock,
+		"-o", "ControlMaster=no",
+		"-p", fmt.Sprintf("%d", t.cfg.SSHPort),
+		fmt.Sprintf("%s@%s", t.cfg.User, t.cfg.SSHHost),
+		"--",
+		command,
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.SysProcAttr = t.sysProcAttr()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("remote command %q error: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// ShellQuote quotes s for POSIX shell
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 // copy from systemd/v22/dbus
-func dbusAuthConnection(ctx context.Context, createBus func(opts ...dbus.ConnOption) (*dbus.Conn, error)) (*dbus.Conn, error) {
-	conn, err := createBus(dbus.WithContext(ctx))
+func dbusAuthConnection(createBus func(opts ...dbus.ConnOption) (*dbus.Conn, error), opts ...dbus.ConnOption) (*dbus.Conn, error) {
+	conn, err := createBus(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +252,7 @@ func dbusAuthConnection(ctx context.Context, createBus func(opts ...dbus.ConnOpt
 }
 
 // run starts ssh program
-func (t *DBusTunnel) run() error {
+func (t *DBusTunnel) run(ctx context.Context) error {
 	args := []string{
 		//"ssh",
 		"-nNT",
@@ -113,13 +265,13 @@ func (t *DBusTunnel) run() error {
 
 	for _, opts := range []string{
 		"ForwardAgent=yes",
-		"ControlMaster=auto",
-		"ControlPersist=60s",
+		"ControlMaster=yes",
+		"ControlPersist=no",
+		"ControlPath=" + t.ctlsock,
 		"UserKnownHostsFile=/dev/null",
 		"StrictHostKeyChecking=no",
 		"ConnectTimeout=6",
 		"ConnectionAttempts=30",
-		"PreferredAuthentications=publickey",
 	} {
 		args = append(args, "-o", opts)
 	}
@@ -128,26 +280,98 @@ func (t *DBusTunnel) run() error {
 		args = append(args, "-v")
 	}
 
-	cmd := exec.CommandContext(t.ctx, "ssh", args...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Pdeathsig: syscall.SIGTERM,
+	credArgs, env, err := t.credentials()
+	if err != nil {
+		return err
+	}
+	args = append(args, credArgs...)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = t.sysProcAttr()
+	if t.cfg.Reuse {
+		// a shared tunnel outlives its owner until the last handler using it stops the master,
+		// if the owner is killed meanwhile, the master is left to the stale tunnel cleanup
+		cmd.SysProcAttr.Pdeathsig = 0
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	stdout, stderr := NewRedactingWriter(os.Stdout), NewRedactingWriter(os.Stderr)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	if t.cfg.SSHVerbose {
-		log.Printf("Starting: ssh %s", strings.Join(args, " "))
+		slog.Info("Starting ssh", "host", t.cfg.SSHHost, "args", strings.Join(args, " "))
 	}
 
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return fmt.Errorf("command error: %w", err)
 	}
+	t.cmd = cmd
+	t.exited = make(chan struct{})
+	go func() {
+		t.waitErr = cmd.Wait()
+		stdout.Flush() //nolint:errcheck
+		stderr.Flush() //nolint:errcheck
+		close(t.exited)
+	}()
+
+	return t.waitForSocket(ctx)
+}
+
+// credentials prepares ssh arguments and environment for configured key and password.
+// Private key contents and askpass helper are written into the tunnel temp dir.
+func (t *DBusTunnel) credentials() (args []string, env []string, err error) {
+	identity := t.cfg.IdentityFile
+	auth := "publickey"
+
+	if t.cfg.PrivateKey != "" {
+		key := t.cfg.PrivateKey
+		if !strings.Contains(key, "\n") {
+			key = strings.ReplaceAll(key, `\n`, "\n")
+		}
+		if !strings.HasSuffix(key, "\n") {
+			key += "\n"
+		}
+
+		identity, err = t.writePrivate("id", []byte(key), 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("write private key error: %w", err)
+		}
+	}
+
+	if identity != "" {
+		args = append(args, "-i", identity, "-o", "IdentitiesOnly=yes")
+	}
+
+	if t.cfg.Certificate != "" {
+		cert, err := t.writePrivate("id-cert.pub", []byte(strings.TrimSpace(t.cfg.Certificate)+"\n"), 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("write certificate error: %w", err)
+		}
+
+		args = append(args, "-o", "CertificateFile="+cert)
+	}
+
+	if t.cfg.Password != "" {
+		askpass, err := t.writePrivate("askpass", []byte("#!/bin/sh\nprintf '%s\\n' \"$SENSU_SYSTEMD_SSH_PASSWORD\"\n"), 0o700)
+		if err != nil {
+			return nil, nil, fmt.Errorf("write askpass error: %w", err)
+		}
 
-	return t.waitForSocket()
+		auth = "publickey,keyboard-interactive,password"
+		env = append(env,
+			"SSH_ASKPASS="+askpass,
+			"SSH_ASKPASS_REQUIRE=force",
+			"SENSU_SYSTEMD_SSH_PASSWORD="+t.cfg.Password,
+		)
+	}
+
+	args = append(args, "-o", "PreferredAuthentications="+auth)
+
+	return args, env, nil
 }
 
-func (t *DBusTunnel) waitForSocket() error {
+func (t *DBusTunnel) waitForSocket(ctx context.Context) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return fmt.Errorf("watcher new error: %w", err)
@@ -159,34 +383,94 @@ func (t *DBusTunnel) waitForSocket() error {
 		return fmt.Errorf("watcher add error: %w", err)
 	}
 
+	// socket may appear before the watch was added
+	if _, err := os.Stat(t.lsock); err == nil {
+		return nil
+	}
+
 	timer := time.NewTimer(30 * time.Second)
 	defer timer.Stop()
 
 	for {
 		select {
-		case <-watcher.Events:
-			return nil
+		case ev := <-watcher.Events:
+			// control master socket lives in the same directory
+			if ev.Name == t.lsock {
+				return nil
+			}
 
 		case err := <-watcher.Errors:
 			return fmt.Errorf("inotify error: %w", err)
 
+		case <-t.exited:
+			return fmt.Errorf("ssh exited: %w", t.waitErr)
+
+		case <-ctx.Done():
+			return ctx.Err()
+
 		case <-timer.C:
 			return fmt.Errorf("connection timeout")
 		}
 	}
 }
 
-// Close terminates ssh tunnel
+// Close closes leftover D-Bus connections and terminates ssh tunnel
 func (t *DBusTunnel) Close() error {
 	var err error
 
+	t.mu.Lock()
+	conns := make([]*systemdDBus.Conn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+
+	for _, conn := range conns {
+		err = multierr.Append(err, t.CloseConn(conn))
+	}
+
+	defer t.ctxCf()
+
+	if t.lease != nil {
+		if !t.borrowed {
+			t.unpublish()
+		}
+
+		last := t.release()
+		if last == nil {
+			// other handlers still use the tunnel, the last of them tears it down
+			return err
+		}
+		defer last.Close()
+
+		t.unpublish()
+		if t.cmd == nil {
+			err = multierr.Append(err, stopMaster(t.ctlsock, t.cred))
+		}
+	} else if t.borrowed {
+		return err
+	}
+
 	if t.cmd != nil {
-		err = multierr.Append(err, t.cmd.Process.Kill())
+		err = multierr.Append(err, t.stop())
 	}
 
-	t.ctxCf()
+	return multierr.Append(err, os.RemoveAll(t.tmpdir))
+}
 
-	err = multierr.Append(err, os.RemoveAll(t.tmpdir))
+// stop asks ssh to terminate and reaps it, killing it if it does not exit in time
+func (t *DBusTunnel) stop() error {
+	err := t.cmd.Process.Signal(syscall.SIGTERM)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
 
-	return err
+	select {
+	case <-t.exited:
+		return nil
+	case <-time.After(2 * time.Second):
+		err = t.cmd.Process.Kill()
+		<-t.exited
+		return err
+	}
 }
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// targetMemberProperties are the target dependencies treated as its members
+var targetMemberProperties = []string{"Requires", "Wants"}
+
+// ExpandTarget resolves the target into its Requires/Wants member units, nested targets are expanded too
+func ExpandTarget(ctx context.Context, conn SystemdConnection, target string) ([]string, error) {
+	var members []string
+	seen := map[string]bool{target: true}
+
+	var expand func(unit string) error
+	expand = func(unit string) error {
+		props, err := conn.GetUnitPropertiesContext(ctx, unit)
+		if err != nil {
+			return fmt.Errorf("get %s properties error: %w", unit, err)
+		}
+
+		for _, name := range targetMemberProperties {
+			deps, _ := props[name].([]string)
+			for _, dep := range deps {
+				if seen[dep] {
+					continue
+				}
+				seen[dep] = true
+
+				if strings.HasSuffix(dep, ".target") {
+					if err := expand(dep); err != nil {
+						return err
+					}
+					continue
+				}
+				members = append(members, dep)
+			}
+		}
+
+		return nil
+	}
+
+	if err := expand(target); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
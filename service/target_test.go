@@ -0,0 +1,29 @@
+package service_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestExpandTarget(t *testing.T) {
+	conn := servicetest.NewConn(map[string]string{
+		"web.target":    "active",
+		"php.target":    "active",
+		"nginx.service": "active",
+		"fpm.service":   "active",
+	})
+	conn.Properties["web.target"] = map[string]any{"Requires": []string{"nginx.service"}, "Wants": []string{"php.target", "nginx.service"}}
+	conn.Properties["php.target"] = map[string]any{"Wants": []string{"fpm.service", "web.target"}}
+
+	members, err := service.ExpandTarget(context.Background(), conn, "web.target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"nginx.service", "fpm.service"}) {
+		t.Errorf("unexpected members: %v", members)
+	}
+}
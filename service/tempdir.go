@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+const (
+	// tunnelDirPattern names tunnel temp dirs, also used to find stale ones
+	tunnelDirPattern = "ssh-tun*"
+
+	// maxSocketPath is the portable sun_path limit (104 on BSD/macOS, 108 on Linux), including the NUL
+	maxSocketPath = 104
+	// controlTempSuffix is appended by ssh to ControlPath while creating the master socket
+	controlTempSuffix = len(".XXXXXXXXXXXXXXXX")
+)
+
+// tunnelBase returns the dir tunnel dirs are created in, /tmp when TMPDIR makes socket paths too long
+func tunnelBase() string {
+	base := os.TempDir()
+	if !socketPathFits(base) {
+		base = "/tmp"
+	}
+	return base
+}
+
+// makeTunnelDir creates a private tunnel dir under tunnelBase
+func makeTunnelDir() (string, error) {
+	dir, err := os.MkdirTemp(tunnelBase(), tunnelDirPattern)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkTunnelDir(dir)
+	if err != nil {
+		os.RemoveAll(dir) //nolint:errcheck
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// socketPathFits reports whether the sockets in a tunnel dir under base fit sun_path
+func socketPathFits(base string) bool {
+	// MkdirTemp replaces * with up to 10 digits
+	longest := filepath.Join(base, "ssh-tun0123456789", "ctl.sock")
+	return len(longest)+controlTempSuffix < maxSocketPath
+}
+
+// checkTunnelDir verifies the dir is a real directory owned by us and accessible only to us
+func checkTunnelDir(dir string) error {
+	err := os.Chmod(dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o700 {
+		return fmt.Errorf("%s: unexpected permissions %o", dir, perm)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s: owned by uid %d, not %d", dir, st.Uid, os.Getuid())
+	}
+
+	return nil
+}
+
+// SweepTunnelDirs removes our tunnel dirs older than maxAge left by crashed runs,
+// including dirs handed over to the runAs user. Dirs with a listening tunnel socket belong to a live run and are kept,
+// unless the tunnel is shared and no handler holds a lease on it any more.
+// Share links pointing to removed dirs are dropped as well.
+func SweepTunnelDirs(maxAge time.Duration, runAs string) {
+	owners := tunnelOwners(runAs)
+
+	bases := []string{filepath.Clean(os.TempDir())}
+	if bases[0] != "/tmp" {
+		bases = append(bases, "/tmp")
+	}
+
+	for _, base := range bases {
+		dirs, err := filepath.Glob(filepath.Join(base, tunnelDirPattern))
+		if err != nil {
+			continue
+		}
+
+		for _, dir := range dirs {
+			fi, err := os.Lstat(dir)
+			if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) && ownedBy(dir, owners) {
+					os.Remove(dir) //nolint:errcheck
+				}
+				continue
+			}
+			if err != nil || !fi.IsDir() || time.Since(fi.ModTime()) < maxAge {
+				continue
+			}
+			if st, ok := fi.Sys().(*syscall.Stat_t); !ok || !slices.Contains(owners, st.Uid) {
+				continue
+			}
+			if tunnelAlive(dir) && !stopOrphan(dir) {
+				continue
+			}
+
+			slog.Debug("Removing stale tunnel dir", "dir", dir, "modified", fi.ModTime())
+			err = os.RemoveAll(dir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Stale tunnel dir removal error", "dir", dir, "error", err)
+			}
+		}
+	}
+}
+
+func tunnelAlive(dir string) bool {
+	c, err := net.DialTimeout("unix", filepath.Join(dir, "dbus.sock"), time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// stopOrphan stops the ssh master of a live shared tunnel whose handlers all crashed, it reports whether it did
+func stopOrphan(dir string) bool {
+	lease, err := os.Open(filepath.Join(dir, leaseFile))
+	if err != nil {
+		return false
+	}
+	defer lease.Close()
+
+	if syscall.Flock(int(lease.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+		return false
+	}
+
+	return stopMaster(filepath.Join(dir, "ctl.sock"), nil) == nil
+}
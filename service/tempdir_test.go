@@ -0,0 +1,58 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeTunnelDir(t *testing.T) {
+	t.Setenv("TMPDIR", "/"+strings.Repeat("x", 100))
+
+	dir, err := makeTunnelDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if filepath.Dir(dir) != "/tmp" {
+		t.Errorf("expected fallback to /tmp for long TMPDIR, got %s", dir)
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o700 {
+		t.Errorf("expected 0700, got %o", fi.Mode().Perm())
+	}
+}
+
+func TestSweepTunnelDirs(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	stale, err := os.MkdirTemp("", tunnelDirPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := os.MkdirTemp("", tunnelDirPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SweepTunnelDirs(24*time.Hour, "")
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale dir %s not removed", stale)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh dir %s removed: %v", fresh, err)
+	}
+}
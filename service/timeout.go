@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// CallTimeout bounds every single D-Bus call, so one unresponsive manager call does not eat
+// the whole handler timeout. Zero disables the bound.
+var CallTimeout = 30 * time.Second
+
+// callContext returns the context of one D-Bus call
+func callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if CallTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, CallTimeout)
+}
+
+// callError names the call that hit CallTimeout
+func callError(ctx context.Context, method string, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: no reply within %s: %w", method, CallTimeout, err)
+	}
+	return err
+}
+
+// WithCallTimeout wraps the connection so each call gets its own CallTimeout deadline.
+// Job methods are bounded only until the job is queued, not until it completes.
+func WithCallTimeout(conn SystemdConnection) SystemdConnection {
+	return timeoutConn{conn}
+}
+
+type timeoutConn struct {
+	SystemdConnection
+}
+
+type jobMethod func(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+
+func (c timeoutConn) job(ctx context.Context, method string, fn jobMethod, name, mode string, ch chan<- string) (int, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	id, err := fn(ctx, name, mode, ch)
+	return id, callError(ctx, method, err)
+}
+
+func (c timeoutConn) StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "StartUnit", c.SystemdConnection.StartUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "StopUnit", c.SystemdConnection.StopUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "RestartUnit", c.SystemdConnection.RestartUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadUnit", c.SystemdConnection.ReloadUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) TryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "TryRestartUnit", c.SystemdConnection.TryRestartUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) ReloadOrRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadOrRestartUnit", c.SystemdConnection.ReloadOrRestartUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) ReloadOrTryRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(ctx, "ReloadOrTryRestartUnit", c.SystemdConnection.ReloadOrTryRestartUnitContext, name, mode, ch)
+}
+
+func (c timeoutConn) ResetFailedUnitContext(ctx context.Context, name string) error {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	return callError(ctx, "ResetFailedUnit", c.SystemdConnection.ResetFailedUnitContext(ctx, name))
+}
+
+func (c timeoutConn) SetUnitPropertiesContext(ctx context.Context, name string, runtime bool, properties ...dbus.Property) error {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	return callError(ctx, "SetUnitProperties", c.SystemdConnection.SetUnitPropertiesContext(ctx, name, runtime, properties...))
+}
+
+func (c timeoutConn) ListJobsContext(ctx context.Context) ([]dbus.JobStatus, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	jobs, err := c.SystemdConnection.ListJobsContext(ctx)
+	return jobs, callError(ctx, "ListJobs", err)
+}
+
+func (c timeoutConn) ReloadContext(ctx context.Context) error {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	return callError(ctx, "Reload", c.SystemdConnection.ReloadContext(ctx))
+}
+
+func (c timeoutConn) UnmaskUnitFilesContext(ctx context.Context, files []string, runtime bool) ([]dbus.UnmaskUnitFileChange, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	changes, err := c.SystemdConnection.UnmaskUnitFilesContext(ctx, files, runtime)
+	return changes, callError(ctx, "UnmaskUnitFiles", err)
+}
+
+func (c timeoutConn) ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	units, err := c.SystemdConnection.ListUnitsContext(ctx)
+	return units, callError(ctx, "ListUnits", err)
+}
+
+func (c timeoutConn) ListUnitsFilteredContext(ctx context.Context, states []string) ([]dbus.UnitStatus, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	units, err := c.SystemdConnection.ListUnitsFilteredContext(ctx, states)
+	return units, callError(ctx, "ListUnitsFiltered", err)
+}
+
+func (c timeoutConn) ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]dbus.UnitStatus, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	units, err := c.SystemdConnection.ListUnitsByPatternsContext(ctx, states, patterns)
+	return units, callError(ctx, "ListUnitsByPatterns", err)
+}
+
+func (c timeoutConn) GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	props, err := c.SystemdConnection.GetUnitPropertiesContext(ctx, unit)
+	return props, callError(ctx, "GetUnitProperties", err)
+}
+
+func (c timeoutConn) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	props, err := c.SystemdConnection.GetUnitTypePropertiesContext(ctx, unit, unitType)
+	return props, callError(ctx, "GetUnitTypeProperties", err)
+}
+
+func (c timeoutConn) GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error) {
+	ctx, cancel := callContext(ctx)
+	defer cancel()
+
+	prop, err := c.SystemdConnection.GetUnitPropertyContext(ctx, unit, propertyName)
+	return prop, callError(ctx, "GetUnitProperty", err)
+}
+
+// GetManagerProperty has no context in go-systemd, the call is abandoned on timeout
+func (c timeoutConn) GetManagerProperty(prop string) (string, error) {
+	if CallTimeout <= 0 {
+		return c.SystemdConnection.GetManagerProperty(prop)
+	}
+
+	type reply struct {
+		value string
+		err   error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		value, err := c.SystemdConnection.GetManagerProperty(prop)
+		ch <- reply{value, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.value, r.err
+	case <-time.After(CallTimeout):
+		return "", fmt.Errorf("GetManagerProperty %s: no reply within %s", prop, CallTimeout)
+	}
+}
+
+// getProperty reads the property, given as interface.Name, within CallTimeout
+func getProperty(obj godbus.BusObject, property string) (godbus.Variant, error) {
+	ctx, cancel := callContext(context.Background())
+	defer cancel()
+
+	idx := strings.LastIndex(property, ".")
+	if idx < 0 {
+		return godbus.Variant{}, fmt.Errorf("invalid property %s", property)
+	}
+
+	var v godbus.Variant
+	err := obj.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, property[:idx], property[idx+1:]).Store(&v)
+	return v, callError(ctx, "Get "+property, err)
+}
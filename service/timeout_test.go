@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+// hungConn never answers list and manager property calls
+type hungConn struct {
+	*servicetest.Conn
+}
+
+func (c hungConn) ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c hungConn) GetManagerProperty(_ string) (string, error) {
+	time.Sleep(time.Second)
+	return "", nil
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	defer func(d time.Duration) { service.CallTimeout = d }(service.CallTimeout)
+	service.CallTimeout = 20 * time.Millisecond
+
+	conn := service.WithCallTimeout(hungConn{servicetest.NewConn(map[string]string{"nginx.service": "failed"})})
+
+	// the run context has no deadline, the call still gives up
+	start := time.Now()
+	_, err := conn.ListUnitsContext(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "ListUnits: no reply within 20ms") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := conn.GetManagerProperty("Version"); err == nil {
+		t.Error("expected GetManagerProperty timeout")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("calls took %s", time.Since(start))
+	}
+
+	// other calls are answered as usual
+	ch := make(chan string, 1)
+	if _, err := conn.RestartUnitContext(context.Background(), "nginx.service", "replace", ch); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-ch; result != "done" {
+		t.Errorf("unexpected job result %s", result)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// TransportStats describes the D-Bus traffic over the tunnel, to tell the transport latency from systemd job time
+type TransportStats struct {
+	// Calls is the number of method calls answered by the remote systemd
+	Calls int64
+	// RoundTrip is the total time between method calls and their replies
+	RoundTrip time.Duration
+	// MaxRoundTrip is the slowest call reply
+	MaxRoundTrip  time.Duration
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// MarshalJSON encodes durations in seconds
+func (s TransportStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"calls":                  s.Calls,
+		"avg_round_trip_seconds": s.AvgRoundTrip().Seconds(),
+		"max_round_trip_seconds": s.MaxRoundTrip.Seconds(),
+		"bytes_sent":             s.BytesSent,
+		"bytes_received":         s.BytesReceived,
+	})
+}
+
+// AvgRoundTrip returns the mean call round trip
+func (s TransportStats) AvgRoundTrip() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.RoundTrip / time.Duration(s.Calls)
+}
+
+// Sub returns the traffic since the base snapshot, the max round trip is kept as is
+func (s TransportStats) Sub(base TransportStats) TransportStats {
+	s.Calls -= base.Calls
+	s.RoundTrip -= base.RoundTrip
+	s.BytesSent -= base.BytesSent
+	s.BytesReceived -= base.BytesReceived
+	return s
+}
+
+// transportCounters collects TransportStats of all connections of the tunnel
+type transportCounters struct {
+	sent     atomic.Int64
+	received atomic.Int64
+
+	mu           sync.Mutex
+	calls        int64
+	roundTrip    time.Duration
+	maxRoundTrip time.Duration
+}
+
+func (c *transportCounters) snapshot() TransportStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return TransportStats{
+		Calls:         c.calls,
+		RoundTrip:     c.roundTrip,
+		MaxRoundTrip:  c.maxRoundTrip,
+		BytesSent:     c.sent.Load(),
+		BytesReceived: c.received.Load(),
+	}
+}
+
+func (c *transportCounters) observe(rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	c.roundTrip += rtt
+	c.maxRoundTrip = max(c.maxRoundTrip, rtt)
+}
+
+// interceptors time method calls of one connection, serials are unique only within the connection
+func (c *transportCounters) interceptors() (out, in dbus.Interceptor) {
+	var mu sync.Mutex
+	pending := make(map[uint32]time.Time)
+
+	out = func(msg *dbus.Message) {
+		if msg.Type != dbus.TypeMethodCall || msg.Flags&dbus.FlagNoReplyExpected != 0 {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		pending[msg.Serial()] = time.Now()
+	}
+
+	in = func(msg *dbus.Message) {
+		if msg.Type != dbus.TypeMethodReply && msg.Type != dbus.TypeError {
+			return
+		}
+		v, ok := msg.Headers[dbus.FieldReplySerial]
+		if !ok {
+			return
+		}
+		serial, ok := v.Value().(uint32)
+		if !ok {
+			return
+		}
+
+		mu.Lock()
+		sent, ok := pending[serial]
+		delete(pending, serial)
+		mu.Unlock()
+
+		if ok {
+			c.observe(time.Since(sent))
+		}
+	}
+
+	return out, in
+}
+
+// chainInterceptors calls the interceptors in order
+func chainInterceptors(interceptors ...dbus.Interceptor) dbus.Interceptor {
+	return func(msg *dbus.Message) {
+		for _, i := range interceptors {
+			i(msg)
+		}
+	}
+}
+
+// countingConn counts bytes passing the connection
+type countingConn struct {
+	net.Conn
+	c *transportCounters
+}
+
+func (cc countingConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	cc.c.received.Add(int64(n))
+	return n, err
+}
+
+func (cc countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	cc.c.sent.Add(int64(n))
+	return n, err
+}
+
+// Stats returns the D-Bus traffic of the tunnel so far
+func (t *DBusTunnel) Stats() TransportStats {
+	return t.counters.snapshot()
+}
@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestTransportStats(t *testing.T) {
+	srv := newServer(t)
+
+	tun := service.TunnelForSocket(srv.Addr())
+	defer tun.Close()
+
+	conn, err := tun.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := tun.Stats()
+
+	units, err := conn.ListUnitsContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 3 {
+		t.Errorf("unexpected units: %v", units)
+	}
+	if err := tun.CloseConn(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := tun.Stats().Sub(base)
+	if stats.Calls != 1 {
+		t.Errorf("expected one call, got %d", stats.Calls)
+	}
+	if stats.RoundTrip <= 0 || stats.MaxRoundTrip <= 0 || stats.AvgRoundTrip() != stats.RoundTrip {
+		t.Errorf("unexpected round trip: %+v", stats)
+	}
+	if stats.BytesSent <= 0 || stats.BytesReceived <= stats.BytesSent {
+		t.Errorf("unexpected byte counts: %+v", stats)
+	}
+}
+
+func TestCloseConn(t *testing.T) {
+	srv := newServer(t)
+	tun := service.TunnelForSocket(srv.Addr())
+
+	conn, err := tun.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftover, err := tun.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tun.CloseConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ListUnitsContext(context.Background()); err == nil {
+		t.Error("expected closed connection to fail")
+	}
+	if err := tun.CloseConn(conn); err != nil {
+		t.Errorf("expected closing twice to be a no-op, got %v", err)
+	}
+
+	// the tunnel closes connections its caller forgot
+	if _, err := leftover.ListUnitsContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tun.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leftover.ListUnitsContext(context.Background()); err == nil {
+		t.Error("expected leftover connection closed with the tunnel")
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// unitNameMax is UNIT_NAME_MAX of systemd
+const unitNameMax = 255
+
+// UnitTypes are the unit type suffixes known to systemd
+var UnitTypes = []string{"service", "socket", "device", "mount", "automount", "swap", "target", "path", "timer", "slice", "scope"}
+
+// ValidateUnitName checks unit name syntax: allowed characters, template instance and type suffix.
+// With pattern set glob characters are allowed too.
+func ValidateUnitName(name string, pattern bool) error {
+	if name == "" {
+		return fmt.Errorf("empty unit name")
+	}
+	if len(name) > unitNameMax {
+		return fmt.Errorf("unit name %q is longer than %d characters", name, unitNameMax)
+	}
+
+	glob := pattern && strings.ContainsAny(name, "*?[")
+
+	dot := strings.LastIndexByte(name, '.')
+	switch {
+	case glob && (dot < 0 || strings.ContainsAny(name[dot+1:], "*?[")):
+		// suffix is matched by the pattern
+		dot = len(name)
+
+	case dot <= 0:
+		return fmt.Errorf("unit name %q has no type suffix", name)
+
+	default:
+		suffix := name[dot+1:]
+		found := false
+		for _, t := range UnitTypes {
+			if suffix == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unit name %q has unknown type suffix %q", name, suffix)
+		}
+	}
+
+	prefix := name[:dot]
+	if strings.Count(prefix, "@") > 1 {
+		return fmt.Errorf("unit name %q has more than one instance separator", name)
+	}
+	if strings.HasPrefix(prefix, "@") {
+		return fmt.Errorf("unit name %q has empty template prefix", name)
+	}
+
+	for _, r := range prefix {
+		if validUnitChar(r) || r == '@' {
+			continue
+		}
+		if glob && strings.ContainsRune("*?[]!^", r) {
+			continue
+		}
+
+		return fmt.Errorf("unit name %q contains invalid character %q, consider --escape-instance", name, r)
+	}
+
+	if glob {
+		if _, err := filepath.Match(name, ""); err != nil {
+			return fmt.Errorf("unit pattern %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func validUnitChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(":-_.\\", r)
+}
+
+// EscapeInstance applies systemd-escape to the instance part of the template unit name,
+// e.g. "systemd-fsck@/dev/sda1.service" becomes "systemd-fsck@dev-sda1.service".
+// Names without instance are returned as is.
+func EscapeInstance(name string) string {
+	at := strings.IndexByte(name, '@')
+	dot := strings.LastIndexByte(name, '.')
+	if at < 0 || dot < at {
+		return name
+	}
+
+	return name[:at+1] + Escape(name[at+1:dot]) + name[dot:]
+}
+
+// Escape implements systemd-escape --path like escaping of the string
+func Escape(s string) string {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0,
+			c >= 0x80,
+			!validUnitChar(rune(c)) || c == '-' || c == '\\':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+func TestValidateUnitName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pattern bool
+		ok      bool
+	}{
+		{"nginx.service", false, true},
+		{"foo@bar.service", false, true},
+		{"foo@.service", false, true},
+		{"systemd-fsck@dev-sda1.service", false, true},
+		{`foo@a\x20b.service`, false, true},
+		{"dev-disk-by\\x2duuid-1234.device", false, true},
+		{strings.Repeat("a", 247) + ".service", false, true},
+		{"nginx*", true, true},
+		{"php*-fpm.service", true, true},
+		{"getty@tty[1-6].service", true, true},
+
+		{"", false, false},
+		{strings.Repeat("a", 248) + ".service", false, false},
+		{"../etc/passwd", false, false},
+		{"../../etc/cron.d/x.service", false, false},
+		{"nginx/../sshd.service", false, false},
+		{"foo@a b.service", false, false},
+		{"foo@a;reboot.service", false, false},
+		{"a@b@c.service", false, false},
+		{"@bar.service", false, false},
+		{"nginx", false, false},
+		{".service", false, false},
+		{"nginx.serv", false, false},
+		{"nginx.service.bak", false, false},
+		{"php*-fpm.service", false, false},
+		{"nginx[.service", true, false},
+		{"../*.service", true, false},
+	} {
+		err := service.ValidateUnitName(tc.name, tc.pattern)
+		if (err == nil) != tc.ok {
+			t.Errorf("ValidateUnitName(%q, %v): expected ok=%v, got %v", tc.name, tc.pattern, tc.ok, err)
+		}
+	}
+}
+
+func TestEscapeInstance(t *testing.T) {
+	for in, out := range map[string]string{
+		"systemd-fsck@/dev/sda1.service": "systemd-fsck@dev-sda1.service",
+		"foo@a b.service":                `foo@a\x20b.service`,
+		"foo@my-app.service":             `foo@my\x2dapp.service`,
+		"foo@.service":                   "foo@-.service",
+		"nginx.service":                  "nginx.service",
+	} {
+		got := service.EscapeInstance(in)
+		if got != out {
+			t.Errorf("EscapeInstance(%q): expected %q, got %q", in, out, got)
+		}
+		if err := service.ValidateUnitName(got, false); err != nil {
+			t.Errorf("escaped %q: %v", got, err)
+		}
+	}
+}
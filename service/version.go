@@ -0,0 +1,36 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionRe = regexp.MustCompile(`\d+`)
+
+// ManagerVersion returns the major version of the remote systemd and the full version string
+func ManagerVersion(conn SystemdConnection) (int, string, error) {
+	prop, err := conn.GetManagerProperty("Version")
+	if err != nil {
+		return 0, "", fmt.Errorf("get systemd version error: %w", err)
+	}
+
+	version, err := strconv.Unquote(prop)
+	if err != nil {
+		version = strings.Trim(prop, `"`)
+	}
+
+	major, err := ParseVersion(version)
+	return major, version, err
+}
+
+// ParseVersion extracts the major number from systemd version string, e.g. "252.5-2ubuntu3" or "systemd 219"
+func ParseVersion(version string) (int, error) {
+	m := versionRe.FindString(version)
+	if m == "" {
+		return 0, fmt.Errorf("unexpected systemd version: %q", version)
+	}
+
+	return strconv.Atoi(m)
+}
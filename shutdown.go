@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long in-flight jobs may report their result after a termination signal
+const shutdownGrace = 5 * time.Second
+
+// signalContext returns context canceled on SIGTERM or SIGINT, the stop function restores default signal handling
+func signalContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			slog.Warn("Received signal, shutting down", "signal", sig.String(), "grace", shutdownGrace)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
+
+// waitResult waits for the job result, after cancellation it waits for in-flight job for shutdownGrace more
+func waitResult(ctx context.Context, resultCh <-chan string) (string, error) {
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(shutdownGrace)
+	defer timer.Stop()
+
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case <-timer.C:
+		return "canceled", ctx.Err()
+	}
+}
+
+// cleanupContext returns ctx, or a grace period context detached from it when the run was canceled.
+// Cleanup steps undoing earlier changes on the host use it.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(context.WithoutCancel(ctx), shutdownGrace)
+}
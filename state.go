@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// handlerState is persisted between handler runs
+type handlerState struct {
+	// Escalations maps host/unit to the time of the first phase action
+	Escalations map[string]time.Time `json:"escalations,omitempty"`
+	// Actions maps entity to the times of performed remediation actions
+	Actions map[string][]time.Time `json:"actions,omitempty"`
+	// Episodes maps namespace/entity/check to its current failure episode
+	Episodes map[string]episodeState `json:"episodes,omitempty"`
+	// Runs maps event ID to units completed by attempts of the event, for --resume
+	Runs map[string]runProgress `json:"runs,omitempty"`
+	// Handled maps event ID to the time it was handled successfully, for --dedup-ttl
+	Handled map[string]time.Time `json:"handled,omitempty"`
+}
+
+// updateState locks the state file, loads it, calls fn and saves the result
+func updateState(path string, fn func(st *handlerState) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("state dir error: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open state file error: %w", err)
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("lock state file error: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	st := &handlerState{}
+	err = json.NewDecoder(f).Decode(st)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decode state file error: %w", err)
+	}
+
+	err = fn(st)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("truncate state file error: %w", err)
+	}
+
+	_, err = f.WriteAt(buf, 0)
+	if err != nil {
+		return fmt.Errorf("write state file error: %w", err)
+	}
+
+	return nil
+}
+
+// stateKey makes key for per-unit state
+func stateKey(host, unit string) string {
+	return host + "/" + unit
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// statsdMaxPacket keeps datagrams under the usual network MTU
+const statsdMaxPacket = 1432
+
+// invalidStatsdTagChars would break the InfluxDB-style tagged line format
+var invalidStatsdTagChars = regexp.MustCompile(`[,:|=\s]`)
+
+func statsdTag(name, value string) string {
+	return "," + name + "=" + invalidStatsdTagChars.ReplaceAllString(value, "_")
+}
+
+// statsdLines makes timers and counters in the InfluxDB-style tagged format understood by Telegraf:
+// per unit action duration and outcome counters, the run duration and, for skipped runs, the skip counter
+func statsdLines(prefix string, results []unitResult, duration time.Duration, skipKind string) []string {
+	lines := make([]string, 0, 2*len(results)+2)
+	for _, r := range results {
+		tags := statsdTag("host", r.Host) + statsdTag("unit", r.Unit) + statsdTag("action", r.Action) + statsdTag("result", r.Result)
+		lines = append(lines,
+			fmt.Sprintf("%s.action_duration%s:%d|ms", prefix, tags, r.Duration.Milliseconds()),
+			fmt.Sprintf("%s.actions%s:1|c", prefix, tags),
+		)
+	}
+
+	if skipKind != "" {
+		lines = append(lines, fmt.Sprintf("%s.skipped%s:1|c", prefix, statsdTag("reason", skipKind)))
+	}
+	lines = append(lines, fmt.Sprintf("%s.run_duration:%d|ms", prefix, duration.Milliseconds()))
+
+	return lines
+}
+
+// sendStatsd writes the lines to the StatsD UDP address, packing as many lines per datagram as fit
+func sendStatsd(addr string, lines []string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	return flush()
+}
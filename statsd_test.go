@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendStatsd(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	lines := statsdLines("systemd_handler", []unitResult{
+		{Host: "web1", Unit: "nginx@a:b.service", Action: "restart", Result: "done", Duration: 1500 * time.Millisecond},
+	}, 2*time.Second, "")
+	if err := sendStatsd(pc.LocalAddr().String(), lines); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, statsdMaxPacket)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "systemd_handler.action_duration,host=web1,unit=nginx@a_b.service,action=restart,result=done:1500|ms\n" +
+		"systemd_handler.actions,host=web1,unit=nginx@a_b.service,action=restart,result=done:1|c\n" +
+		"systemd_handler.run_duration:2000|ms"
+	if string(buf[:n]) != expected {
+		t.Errorf("unexpected packet:\n%s", buf[:n])
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+var (
+	systemStateGates = []string{"off", "skip", "wait"}
+
+	// busySystemStates are manager states in which restarting units tends to make things worse
+	busySystemStates = []string{"initializing", "starting", "stopping", "maintenance"}
+
+	// systemStatePollInterval is how often --system-state-gate wait polls the manager
+	systemStatePollInterval = 5 * time.Second
+)
+
+// errSystemBusy is returned while the target boots, shuts down or is in maintenance
+var errSystemBusy = errors.New("system is not running normally")
+
+// gateSystemState checks the manager SystemState, with --system-state-gate wait it polls until the target
+// is no longer busy or --system-state-wait passes. It returns the last seen state.
+func gateSystemState(ctx context.Context, logger *slog.Logger, conn service.SystemdConnection) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, plugin.systemStateWait)
+	defer cancel()
+
+	ticker := time.NewTicker(systemStatePollInterval)
+	defer ticker.Stop()
+
+	for logged := false; ; logged = true {
+		state, err := service.SystemState(conn)
+		if err != nil {
+			return "", err
+		}
+		if !stringsContains(busySystemStates, state) {
+			return state, nil
+		}
+		if plugin.SystemStateGate != "wait" {
+			return state, fmt.Errorf("%w: %s", errSystemBusy, state)
+		}
+		if !logged {
+			logger.Info("Waiting for target to leave system state", "state", state, "timeout", plugin.systemStateWait)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return state, fmt.Errorf("%w: still %s after %s", errSystemBusy, state, plugin.systemStateWait)
+		}
+	}
+}
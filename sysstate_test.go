@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestGateSystemState(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conn := servicetest.NewConn(nil)
+
+	defer func(gate string, wait, interval time.Duration) {
+		plugin.SystemStateGate, plugin.systemStateWait, systemStatePollInterval = gate, wait, interval
+	}(plugin.SystemStateGate, plugin.systemStateWait, systemStatePollInterval)
+	plugin.SystemStateGate = "skip"
+
+	if state, err := gateSystemState(ctx, logger, conn); err != nil || state != "running" {
+		t.Errorf("running system must pass: %s, %v", state, err)
+	}
+
+	conn.Manager["SystemState"] = "starting"
+	if _, err := gateSystemState(ctx, logger, conn); !errors.Is(err, errSystemBusy) {
+		t.Errorf("expected busy system, got %v", err)
+	}
+
+	plugin.SystemStateGate = "wait"
+	plugin.systemStateWait = 50 * time.Millisecond
+	systemStatePollInterval = 10 * time.Millisecond
+	start := time.Now()
+	if _, err := gateSystemState(ctx, logger, conn); !errors.Is(err, errSystemBusy) || time.Since(start) < plugin.systemStateWait {
+		t.Errorf("expected wait to time out, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+// resolveSSHHost determines the SSH target for the event entity.
+// Proxy entities rarely have a meaningful hostname, so the label is consulted first. Otherwise the hostname,
+// the entity name and the entity annotation are tried in order.
+func resolveSSHHost(event *corev2.Event, proxyLabel, hostAnnotation string) (string, error) {
+	if event == nil || event.Entity == nil {
+		return "", fmt.Errorf("cannot determine SSH target: event has no entity")
+	}
+
+	entity := event.Entity
+	candidates := []string{entity.System.Hostname}
+	if entity.EntityClass == corev2.EntityProxyClass {
+		candidates = nil
+		if proxyLabel != "" {
+			candidates = append(candidates, entity.Labels[proxyLabel])
+		}
+	}
+	candidates = append(candidates, entity.Name)
+	if hostAnnotation != "" {
+		candidates = append(candidates, entity.Annotations[hostAnnotation])
+	}
+
+	for _, host := range candidates {
+		if host = strings.TrimSpace(host); host != "" {
+			return host, nil
+		}
+	}
+
+	tried := []string{"hostname"}
+	if entity.EntityClass == corev2.EntityProxyClass {
+		tried = []string{fmt.Sprintf("%q label", proxyLabel)}
+	}
+	tried = append(tried, "entity name")
+	if hostAnnotation != "" {
+		tried = append(tried, fmt.Sprintf("%q annotation", hostAnnotation))
+	}
+
+	return "", fmt.Errorf("cannot determine SSH target for %s entity: no %s, set --ssh-host",
+		entity.EntityClass, strings.Join(tried, ", "))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+)
+
+func TestResolveSSHHost(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		entity *corev2.Entity
+		host   string
+	}{
+		{"hostname", &corev2.Entity{ObjectMeta: corev2.ObjectMeta{Name: "web"}, EntityClass: corev2.EntityAgentClass, System: corev2.System{Hostname: "web.example.com"}}, "web.example.com"},
+		{"agent name", &corev2.Entity{ObjectMeta: corev2.ObjectMeta{Name: "web"}, EntityClass: corev2.EntityAgentClass}, "web"},
+		{"proxy label", &corev2.Entity{ObjectMeta: corev2.ObjectMeta{Name: "switch", Labels: map[string]string{"ssh_host": "10.0.0.1"}}, EntityClass: corev2.EntityProxyClass}, "10.0.0.1"},
+		{"proxy name", &corev2.Entity{ObjectMeta: corev2.ObjectMeta{Name: "switch"}, EntityClass: corev2.EntityProxyClass}, "switch"},
+		{"annotation", &corev2.Entity{ObjectMeta: corev2.ObjectMeta{Annotations: map[string]string{"systemd-handler/ssh-host": "10.0.0.2"}}, EntityClass: corev2.EntityAgentClass, System: corev2.System{Hostname: " "}}, "10.0.0.2"},
+	} {
+		host, err := resolveSSHHost(&corev2.Event{Entity: tc.entity}, "ssh_host", "systemd-handler/ssh-host")
+		if err != nil || host != tc.host {
+			t.Errorf("%s: expected %q, got %q, %v", tc.name, tc.host, host, err)
+		}
+	}
+
+	_, err := resolveSSHHost(&corev2.Event{Entity: &corev2.Entity{EntityClass: corev2.EntityProxyClass}}, "ssh_host", "systemd-handler/ssh-host")
+	if err == nil || !strings.Contains(err.Error(), `cannot determine SSH target for proxy entity: no "ssh_host" label, entity name, "systemd-handler/ssh-host" annotation`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/core/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service/servicetest"
+)
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	saved := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(saved) })
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	handlerConfig(t)
+	conn := servicetest.NewConn(map[string]string{"nginx.service": "failed", "mysql.service": "failed"})
+	conn.JobResults["mysql.service"] = "failed"
+	if _, _, err := runHandler(t, corev2.FixtureEvent("node1", "check-nginx"), conn); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range sr.Ended() {
+		name := span.Name()
+		for _, kv := range span.Attributes() {
+			if kv.Key == "unit" {
+				name += " " + kv.Value.AsString()
+			}
+		}
+		spans[name] = span
+	}
+
+	handler, host := spans["handler"], spans["host"]
+	if handler == nil || host == nil || spans["match"] == nil {
+		t.Fatalf("missing spans: %v", sr.Ended())
+	}
+	if host.Parent().SpanID() != handler.SpanContext().SpanID() || handler.Status().Code != codes.Error {
+		t.Errorf("expected failed handler span with host child, got %v", handler.Status())
+	}
+	for unit, code := range map[string]codes.Code{"nginx.service": codes.Unset, "mysql.service": codes.Error} {
+		span := spans["action "+unit]
+		if span == nil || span.Parent().SpanID() != host.SpanContext().SpanID() || span.Status().Code != code {
+			t.Errorf("%s: expected action span with status %v under the host", unit, code)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultClient is a minimal HashiCorp Vault HTTP API client
+type vaultClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// vaultResponse is the common envelope of Vault API responses
+type vaultResponse struct {
+	Data map[string]any `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// newVaultClient authenticates with VAULT_TOKEN or, when it is not set, with AppRole login
+func newVaultClient(ctx context.Context) (*vaultClient, error) {
+	v := &vaultClient{
+		addr:   strings.TrimRight(plugin.VaultAddr, "/"),
+		token:  plugin.VaultToken,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if v.token == "" {
+		resp, err := v.do(ctx, http.MethodPost, "auth/approle/login", map[string]string{
+			"role_id":   plugin.VaultRoleID,
+			"secret_id": plugin.VaultSecretID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault approle login error: %w", err)
+		}
+		if resp.Auth.ClientToken == "" {
+			return nil, fmt.Errorf("vault approle login: no client token in response")
+		}
+		v.token = resp.Auth.ClientToken
+	}
+
+	return v, nil
+}
+
+func (v *vaultClient) do(ctx context.Context, method, path string, body any) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimLeft(path, "/"), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := &vaultResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s %s: %s: decode error: %w", method, path, resp.Status, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.Join(out.Errors, "; "))
+	}
+
+	return out, nil
+}
+
+// loadVaultCredentials fills the tunnel private key (and certificate) from Vault, if configured
+func loadVaultCredentials(ctx context.Context, logger *slog.Logger) error {
+	if plugin.VaultSSHKey == "" && plugin.VaultSSHIssue == "" {
+		return nil
+	}
+
+	v, err := newVaultClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if plugin.VaultSSHKey != "" {
+		resp, err := v.do(ctx, http.MethodGet, plugin.VaultSSHKey, nil)
+		if err != nil {
+			return fmt.Errorf("vault read error: %w", err)
+		}
+
+		data := resp.Data
+		// KV version 2 nests the secret under data.data
+		if nested, ok := data["data"].(map[string]any); ok {
+			data = nested
+		}
+
+		key, _ := data["private_key"].(string)
+		if key == "" {
+			return fmt.Errorf("vault secret %s has no private_key field", plugin.VaultSSHKey)
+		}
+
+		logger.Debug("SSH private key read from vault", "path", plugin.VaultSSHKey)
+		plugin.Tun.PrivateKey = key
+		return nil
+	}
+
+	resp, err := v.do(ctx, http.MethodPost, plugin.VaultSSHIssue, map[string]string{
+		"key_type":         "ed25519",
+		"valid_principals": plugin.Tun.User,
+	})
+	if err != nil {
+		return fmt.Errorf("vault ssh issue error: %w", err)
+	}
+
+	key, _ := resp.Data["private_key"].(string)
+	cert, _ := resp.Data["signed_key"].(string)
+	if key == "" || cert == "" {
+		return fmt.Errorf("vault %s: no private_key or signed_key in response", plugin.VaultSSHIssue)
+	}
+
+	logger.Debug("SSH certificate issued by vault", "path", plugin.VaultSSHIssue, "serial", resp.Data["serial_number"])
+	plugin.Tun.PrivateKey = key
+	plugin.Tun.Certificate = cert
+	return nil
+}
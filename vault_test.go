@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadVaultCredentials(t *testing.T) {
+	defer func(cfg Config) { plugin = cfg }(plugin)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth/approle/login":
+			fmt.Fprint(w, `{"auth": {"client_token": "s.approle"}}`)
+		case r.Header.Get("X-Vault-Token") != "s.approle":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+		case r.URL.Path == "/v1/secret/data/sensu/ssh":
+			fmt.Fprint(w, `{"data": {"data": {"private_key": "KEY"}}}`)
+		case r.URL.Path == "/v1/ssh/issue/sensu" && r.Method == http.MethodPost:
+			fmt.Fprint(w, `{"data": {"private_key": "EPHEMERAL", "signed_key": "CERT"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	logger := slog.Default()
+	plugin.VaultAddr = srv.URL
+	plugin.VaultRoleID, plugin.VaultSecretID = "role", "secret"
+
+	plugin.VaultSSHKey = "secret/data/sensu/ssh"
+	if err := loadVaultCredentials(context.Background(), logger); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Tun.PrivateKey != "KEY" {
+		t.Errorf("kv: expected KEY, got %q", plugin.Tun.PrivateKey)
+	}
+
+	plugin.VaultSSHKey, plugin.VaultSSHIssue = "", "ssh/issue/sensu"
+	if err := loadVaultCredentials(context.Background(), logger); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Tun.PrivateKey != "EPHEMERAL" || plugin.Tun.Certificate != "CERT" {
+		t.Errorf("issue: unexpected credentials %q, %q", plugin.Tun.PrivateKey, plugin.Tun.Certificate)
+	}
+
+	plugin.VaultToken = "s.wrong"
+	if err := loadVaultCredentials(context.Background(), logger); err == nil {
+		t.Error("expected permission denied error")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sardinasystems/sensu-go-systemd-handler/service"
+)
+
+// verifyCheckInterval is the pause between remote check attempts
+const verifyCheckInterval = 5 * time.Second
+
+// verifyCheck re-runs the check on the remote host until it exits with zero or the timeout passes
+func verifyCheck(ctx context.Context, logger *slog.Logger, stun *service.DBusTunnel, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, plugin.verifyTimeout)
+	defer cancel()
+
+	logger.Info("Verifying recovery with the check", "command", command, "timeout", plugin.verifyTimeout)
+	for {
+		out, err := stun.RunCommand(ctx, command)
+		if err == nil {
+			logger.Info("Check passed, recovery confirmed")
+			return nil
+		}
+		logger.Info("Check still failing", "error", err, "output", strings.TrimSpace(string(out)))
+
+		select {
+		case <-time.After(verifyCheckInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("check still failing after %s: %w", plugin.verifyTimeout, err)
+		}
+	}
+}
+
+// markUnverified records the failed check on the successful results, they count as not verified
+func markUnverified(results []unitResult, reason string) {
+	for idx := range results {
+		if !results[idx].Failed() && results[idx].Verify == "" {
+			results[idx].Verify = reason
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+)
+
+// actionMinVersion lists actions (and features) which need newer systemd than --min-systemd-version
+var actionMinVersion = map[string]int{
+	"mark-restart":   248,
+	"mark-reload":    248,
+	"enqueue-marked": 248,
+	"revert":         230,
+
+	// job modes
+	"triggering":           250,
+	"restart-dependencies": 254,
+}
+
+// requiredVersion returns the minimal systemd version for the actions
+func requiredVersion(actions ...string) int {
+	required := plugin.MinSystemdVersion
+	for _, action := range actions {
+		required = max(required, actionMinVersion[action])
+	}
+
+	return required
+}
+
+// checkVersion fails when the remote systemd is older than the actions need
+func checkVersion(host string, version int, raw string, actions ...string) error {
+	for _, action := range actions {
+		required := requiredVersion(action)
+		if version < required {
+			return fmt.Errorf("%s: systemd %s is older than %d required for %s", host, raw, required, action)
+		}
+	}
+
+	return nil
+}